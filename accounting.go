@@ -0,0 +1,169 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// defaultAccountingChunk is the size of the chunks TransferAccounted moves
+// if Accounting.Bytes is zero.
+const defaultAccountingChunk = 64 << 10
+
+// Accounting configures the accounting hook of TransferAccounted.
+type Accounting struct {
+	// Bytes is the granularity of the hook, in bytes: Func is called
+	// whenever at least Bytes bytes have been written to dst since the
+	// last call. If Bytes is zero, Func is not called on this account.
+	Bytes int64
+
+	// Interval is the granularity of the hook, in time: Func is called
+	// if at least Interval has passed since the last call, and data has
+	// been written to dst since. If Interval is zero, Func is not
+	// called on this account.
+	Interval time.Duration
+
+	// Func receives the number of bytes written to dst since the last
+	// call. It is called synchronously, between chunks, and should
+	// return quickly.
+	Func func(delta int64)
+
+	// Quota, if not nil, enforces a hard byte quota. It is called after
+	// every chunk, with the number of bytes the chunk wrote to dst, and
+	// returns the number of bytes the quota still allows. If it returns
+	// a negative number, the quota is exceeded by that many bytes, and
+	// TransferAccounted stops, and returns a *QuotaError. Quota is
+	// called before Func, which still receives the bytes of the last
+	// chunk. A chunk which fails part way is charged for the bytes it
+	// wrote before TransferAccounted returns its error.
+	Quota func(delta int64) (remaining int64)
+}
+
+// ErrQuotaExceeded is the error wrapped by a *QuotaError.
+var ErrQuotaExceeded = errors.New("zerocopy: quota exceeded")
+
+// A QuotaError is returned by TransferAccounted when Accounting.Quota
+// stops a transfer.
+type QuotaError struct {
+	// Overshoot is the number of bytes written to dst in excess of the
+	// quota, as reported by Quota. Since the transfer stops at a chunk
+	// boundary, Overshoot is less than the chunk size.
+	Overshoot int64
+}
+
+func (e *QuotaError) Error() string {
+	return ErrQuotaExceeded.Error() + " (" + strconv.FormatInt(e.Overshoot, 10) + " bytes over)"
+}
+
+// Unwrap returns ErrQuotaExceeded.
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
+
+// TransferAccounted is like Transfer, but reports the progress of the
+// transfer to acct.Func, as it happens. It is meant for billing and quota
+// systems in multi-tenant relays, which must not wrap src and dst, since
+// wrapping hides the file descriptors Transfer needs.
+//
+// TransferAccounted moves data in chunks of acct.Bytes bytes, or 64 KiB if
+// acct.Bytes is zero. It chooses how to move them once, as Transfer would,
+// and uses the same strategy, and the same pipe, for every chunk.
+// The hook is called between chunks, so with a slow source, acct.Interval
+// is honored only as precisely as the chunk size allows. Before
+// TransferAccounted returns, Func receives the bytes not yet reported, if
+// any, so that the deltas add up to the number of bytes written to dst.
+//
+// If acct is nil, or both acct.Func and acct.Quota are nil,
+// TransferAccounted is equivalent to Transfer.
+func TransferAccounted(dst io.Writer, src io.Reader, acct *Accounting) (int64, error) {
+	if acct == nil || (acct.Func == nil && acct.Quota == nil) {
+		return Transfer(dst, src)
+	}
+	defer startTransfer()()
+
+	chunk := acct.Bytes
+	if chunk <= 0 {
+		chunk = defaultAccountingChunk
+	}
+
+	var (
+		written int64
+		pending int64
+		last    = time.Now()
+	)
+	flush := func() {
+		if pending > 0 && acct.Func != nil {
+			acct.Func(pending)
+			pending = 0
+			last = time.Now()
+		}
+	}
+	defer flush()
+	err := transferChunks(dst, src, chunk, func(n int64, err error) error {
+		written += n
+		pending += n
+		if acct.Quota != nil && n > 0 {
+			// Charge the chunk even if it failed part way: the
+			// bytes it wrote reached dst all the same.
+			if remaining := acct.Quota(n); remaining < 0 && err == nil {
+				err = &QuotaError{Overshoot: -remaining}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if (acct.Bytes > 0 && pending >= acct.Bytes) ||
+			(acct.Interval > 0 && time.Since(last) >= acct.Interval) {
+			flush()
+		}
+		return nil
+	})
+	return written, err
+}
+
+// transferChunksGeneric implements transferChunks by moving every chunk
+// as Transfer would.
+func transferChunksGeneric(dst io.Writer, src io.Reader, chunk int64, step func(n int64, err error) error) error {
+	return transferChunksFunc(src, chunk, func(clr *io.LimitedReader) (int64, error) {
+		return transferPipeBuffer(nil, dst, clr, nil)
+	}, step)
+}
+
+// transferChunksFunc splits src into chunks of at most chunk bytes, and
+// calls move for each of them, then step with the results, until src
+// reaches EOF, or step returns an error. It honors the limit of src, if
+// src is an *io.LimitedReader, and updates it.
+func transferChunksFunc(src io.Reader, chunk int64, move func(clr *io.LimitedReader) (int64, error), step func(n int64, err error) error) error {
+	rd := src
+	limit := int64(1<<63 - 1)
+	lr, limited := src.(*io.LimitedReader)
+	if limited {
+		rd = lr.R
+		limit = lr.N
+	}
+	for limit > 0 {
+		max := chunk
+		if max > limit {
+			max = limit
+		}
+		clr := &io.LimitedReader{R: rd, N: max}
+		n, err := move(clr)
+		read := max - clr.N
+		limit -= read
+		if limited {
+			lr.N -= read
+		}
+		if err := step(n, err); err != nil {
+			return err
+		}
+		if read < max {
+			// src reached EOF.
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"net"
+)
+
+// transferChunks moves data from src to dst in chunks of at most chunk
+// bytes, and calls step after every chunk, with the number of bytes the
+// chunk wrote to dst, and the error which stopped it, if any. If step
+// returns an error, transferChunks stops, and returns it.
+//
+// The strategy, and the pipe if one is needed, are chosen once, as
+// transferPipeBuffer would choose them, and used for every chunk.
+func transferChunks(dst io.Writer, src io.Reader, chunk int64, step func(n int64, err error) error) error {
+	rd := src
+	if lr, ok := src.(*io.LimitedReader); ok {
+		rd = lr.R
+	}
+	if isTLSConn(rd) || isTLSConn(dst) {
+		return transferChunksGeneric(dst, src, chunk, step)
+	}
+	if isMessageSocketType(socketType(rd)) || isDatagramSocket(dst) {
+		return transferChunksGeneric(dst, src, chunk, step)
+	}
+	if tc, ok := dst.(*net.TCPConn); ok && delegateToStdlib(rd) {
+		return transferChunksFunc(src, chunk, func(clr *io.LimitedReader) (int64, error) {
+			return readFromStdlib(tc, clr)
+		}, step)
+	}
+	rfile := isRegularFile(rd)
+	if rfile && isRegularFile(dst) {
+		// copy_file_range(2) needs no setup.
+		return transferChunksGeneric(dst, src, chunk, step)
+	}
+	rrc, wrc, ok := rawConns(dst, rd)
+	if !ok {
+		return transferChunksGeneric(dst, src, chunk, step)
+	}
+	p, err := getPipe()
+	if err != nil {
+		return transferChunksGeneric(dst, src, chunk, step)
+	}
+	defer putPipe(p)
+	sizePipeForSockets(p, rrc, wrc)
+	return transferChunksFunc(src, chunk, func(clr *io.LimitedReader) (int64, error) {
+		return spliceBuffer(p, dst, rd, clr, clr.N, rrc, wrc, rfile, nil)
+	}, step)
+}
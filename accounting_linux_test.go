@@ -0,0 +1,168 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+)
+
+func TestTransferAccounted(t *testing.T) {
+	t.Run("Bytes", func(t *testing.T) {
+		content := make([]byte, 1<<20+100)
+		rand.Read(content)
+		src := newParitySource(t, content)
+		defer src.Close()
+		dst, received := newParitySink(t)
+
+		var deltas []int64
+		acct := &zerocopy.Accounting{
+			Bytes: 256 << 10,
+			Func:  func(delta int64) { deltas = append(deltas, delta) },
+		}
+		n, err := zerocopy.TransferAccounted(dst, src, acct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst.Close()
+		if got := received(); !bytes.Equal(got, content) {
+			t.Fatalf("received %d bytes, which do not match", len(got))
+		}
+		if n != int64(len(content)) {
+			t.Errorf("moved %d bytes, want %d", n, len(content))
+		}
+		want := []int64{256 << 10, 256 << 10, 256 << 10, 256 << 10, 100}
+		if !equalInt64s(deltas, want) {
+			t.Errorf("got deltas %v, want %v", deltas, want)
+		}
+	})
+	t.Run("Interval", func(t *testing.T) {
+		content := make([]byte, 200<<10)
+		rand.Read(content)
+		var buf bytes.Buffer
+
+		var deltas []int64
+		acct := &zerocopy.Accounting{
+			Interval: time.Nanosecond,
+			Func:     func(delta int64) { deltas = append(deltas, delta) },
+		}
+		if _, err := zerocopy.TransferAccounted(&buf, bytes.NewReader(content), acct); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), content) {
+			t.Fatal("content does not match")
+		}
+		// Chunks are 64 KiB by default.
+		want := []int64{64 << 10, 64 << 10, 64 << 10, 8 << 10}
+		if !equalInt64s(deltas, want) {
+			t.Errorf("got deltas %v, want %v", deltas, want)
+		}
+	})
+	t.Run("LimitedReader", func(t *testing.T) {
+		content := make([]byte, 1000)
+		lr := &io.LimitedReader{R: bytes.NewReader(content), N: 700}
+		var buf bytes.Buffer
+
+		var total int64
+		acct := &zerocopy.Accounting{
+			Bytes: 300,
+			Func:  func(delta int64) { total += delta },
+		}
+		n, err := zerocopy.TransferAccounted(&buf, lr, acct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 700 || total != 700 || buf.Len() != 700 {
+			t.Errorf("moved %d bytes, accounted %d, wrote %d, want 700", n, total, buf.Len())
+		}
+		if lr.N != 0 {
+			t.Errorf("lr.N = %d, want 0", lr.N)
+		}
+	})
+	t.Run("Quota", func(t *testing.T) {
+		content := make([]byte, 1000)
+		var buf bytes.Buffer
+
+		const quota = 450
+		var used, reported int64
+		acct := &zerocopy.Accounting{
+			Bytes: 200,
+			Func:  func(delta int64) { reported += delta },
+			Quota: func(delta int64) int64 {
+				used += delta
+				return quota - used
+			},
+		}
+		n, err := zerocopy.TransferAccounted(&buf, bytes.NewReader(content), acct)
+		qerr, ok := err.(*zerocopy.QuotaError)
+		if !ok {
+			t.Fatalf("got error %v, want *QuotaError", err)
+		}
+		// The third chunk goes over the quota, by 150 bytes.
+		if n != 600 || reported != 600 || buf.Len() != 600 {
+			t.Errorf("moved %d bytes, reported %d, wrote %d, want 600", n, reported, buf.Len())
+		}
+		if qerr.Overshoot != 150 {
+			t.Errorf("overshoot is %d, want 150", qerr.Overshoot)
+		}
+		if qerr.Unwrap() != zerocopy.ErrQuotaExceeded {
+			t.Errorf("got %v, want ErrQuotaExceeded", qerr.Unwrap())
+		}
+	})
+	t.Run("QuotaFailedChunk", func(t *testing.T) {
+		content := make([]byte, 1000)
+		dst := &shortWriter{limit: 500}
+
+		var used int64
+		acct := &zerocopy.Accounting{
+			Bytes: 200,
+			Quota: func(delta int64) int64 {
+				used += delta
+				return 1 << 20
+			},
+		}
+		n, err := zerocopy.TransferAccounted(dst, bytes.NewReader(content), acct)
+		if err != errTargetFailed {
+			t.Fatalf("got error %v, want %v", err, errTargetFailed)
+		}
+		// The third chunk fails after 100 bytes, which are charged
+		// all the same.
+		if n != 500 || used != 500 {
+			t.Errorf("moved %d bytes, charged %d, want 500", n, used)
+		}
+	})
+}
+
+// shortWriter writes up to limit bytes, then fails.
+type shortWriter struct {
+	limit int
+	buf   bytes.Buffer
+}
+
+func (sw *shortWriter) Write(b []byte) (int, error) {
+	if room := sw.limit - sw.buf.Len(); len(b) > room {
+		sw.buf.Write(b[:room])
+		return room, errTargetFailed
+	}
+	return sw.buf.Write(b)
+}
+
+func equalInt64s(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package zerocopy
+
+import "io"
+
+// transferChunks moves data from src to dst in chunks of at most chunk
+// bytes, and calls step after every chunk, with the number of bytes the
+// chunk wrote to dst, and the error which stopped it, if any. If step
+// returns an error, transferChunks stops, and returns it.
+func transferChunks(dst io.Writer, src io.Reader, chunk int64, step func(n int64, err error) error) error {
+	return transferChunksGeneric(dst, src, chunk, step)
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"sync"
+)
+
+// Adaptive buffers start at minAdaptiveBufferSize bytes, and double up to
+// maxAdaptiveBufferSize bytes.
+const (
+	minAdaptiveBufferSize = 32 << 10
+	maxAdaptiveBufferSize = 1 << 20
+)
+
+// adaptivePools holds pools of adaptive buffers, one per size, starting
+// from minAdaptiveBufferSize.
+var adaptivePools [adaptiveSizeClasses]sync.Pool
+
+// adaptiveSizeClasses is the number of distinct adaptive buffer sizes.
+const adaptiveSizeClasses = 6 // 32 KiB to 1 MiB
+
+func getAdaptiveBuffer(class int) *[]byte {
+	if bp, ok := adaptivePools[class].Get().(*[]byte); ok {
+		return bp
+	}
+	b := make([]byte, minAdaptiveBufferSize<<uint(class))
+	return &b
+}
+
+// copyAdaptive is like io.Copy, but copies through a pooled buffer which
+// grows as long as reads from src fill it, so that fast streams are moved
+// with fewer, larger system calls, while slow streams, such as
+// interactive connections, do not hold large buffers.
+//
+// As with io.Copy, if src implements io.WriterTo, or dst implements
+// io.ReaderFrom, the copy is left to them.
+func copyAdaptive(dst io.Writer, src io.Reader) (written int64, err error) {
+	count(&metrics.fallbacks, 1)
+	defer func() { count(&metrics.bytesGeneric, written) }()
+	if wt, ok := src.(io.WriterTo); ok {
+		return wt.WriteTo(dst)
+	}
+	if rf, ok := dst.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	class := 0
+	bp := getAdaptiveBuffer(class)
+	defer func() { adaptivePools[class].Put(bp) }()
+	for {
+		buf := *bp
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			nw, werr := dst.Write(buf[:nr])
+			if nw > 0 {
+				written += int64(nw)
+			}
+			if werr != nil {
+				return written, werr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+		if nr == len(buf) && class < adaptiveSizeClasses-1 {
+			// src had more data than fit. Trade up.
+			adaptivePools[class].Put(bp)
+			class++
+			bp = getAdaptiveBuffer(class)
+		}
+	}
+}
+
+// usesAdaptive reports whether copyAdaptive(dst, src) copies through its
+// own buffer, rather than leaving the copy to src or dst.
+func usesAdaptive(dst io.Writer, src io.Reader) bool {
+	if _, ok := src.(io.WriterTo); ok {
+		return false
+	}
+	_, ok := dst.(io.ReaderFrom)
+	return !ok
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestCopyAdaptive(t *testing.T) {
+	t.Run("Grows", func(t *testing.T) {
+		content := make([]byte, 8<<20)
+		rand.Read(content)
+		src := &readSizeRecorder{r: bytes.NewReader(content)}
+		var dst bytes.Buffer
+		n, err := copyAdaptive(onlyWriter{&dst}, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(content)) || !bytes.Equal(dst.Bytes(), content) {
+			t.Fatalf("copied %d bytes, which do not match", n)
+		}
+		if src.max != maxAdaptiveBufferSize {
+			t.Errorf("largest read was %d bytes, want %d", src.max, maxAdaptiveBufferSize)
+		}
+	})
+	t.Run("Slow", func(t *testing.T) {
+		// A source which never fills the buffer keeps the smallest one.
+		content := make([]byte, 1<<20)
+		src := &readSizeRecorder{r: bytes.NewReader(content), chunk: 1000}
+		var dst bytes.Buffer
+		if _, err := copyAdaptive(onlyWriter{&dst}, src); err != nil {
+			t.Fatal(err)
+		}
+		if src.max != minAdaptiveBufferSize {
+			t.Errorf("largest read was %d bytes, want %d", src.max, minAdaptiveBufferSize)
+		}
+	})
+}
+
+// readSizeRecorder records the size of the largest buffer passed to Read.
+// If chunk is not zero, Read returns at most chunk bytes.
+type readSizeRecorder struct {
+	r     io.Reader
+	chunk int
+	max   int
+}
+
+func (r *readSizeRecorder) Read(b []byte) (int, error) {
+	if len(b) > r.max {
+		r.max = len(b)
+	}
+	if r.chunk > 0 && len(b) > r.chunk {
+		b = b[:r.chunk]
+	}
+	return r.r.Read(b)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"bufio"
+	"io"
+)
+
+// A BufferedReader is a bufio.Reader which can hand its source back to the
+// splice path. It is meant for protocols which parse some data, such as a
+// header, in userspace, then pass the rest of the stream, or a region of
+// it, through untouched.
+//
+// Reading from a source through a bufio.Reader usually consumes more data
+// than the parser needs. TransferTo accounts for this by writing the data
+// which was buffered, but not yet read, to the destination first, then
+// transferring the remainder directly from the source.
+type BufferedReader struct {
+	*bufio.Reader
+
+	src io.Reader
+	p   *Pipe
+}
+
+// NewBufferedReader creates a BufferedReader reading from src, with the
+// default buffer size. The BufferedReader must be closed after use.
+func NewBufferedReader(src io.Reader) *BufferedReader {
+	return &BufferedReader{
+		Reader: bufio.NewReader(src),
+		src:    src,
+	}
+}
+
+// TransferTo transfers n bytes to dst, or everything up to EOF if n is
+// negative. Data which is buffered in the bufio.Reader is written first,
+// and the rest is transferred from the source as if by Transfer, using
+// a pipe which is reused across calls.
+//
+// If n is not negative, and fewer than n bytes are available before EOF,
+// TransferTo returns io.EOF, like io.CopyN.
+func (r *BufferedReader) TransferTo(dst io.Writer, n int64) (int64, error) {
+	var written int64
+	if buffered := int64(r.Buffered()); buffered > 0 && n != 0 {
+		if n > 0 && buffered > n {
+			buffered = n
+		}
+		// Limiting the copy to the number of buffered bytes ensures
+		// that the bufio.Reader never reads from src on our behalf.
+		nb, err := io.CopyN(dst, r.Reader, buffered)
+		written += nb
+		if err != nil {
+			return written, err
+		}
+		if n > 0 {
+			n -= buffered
+		}
+	}
+	if n == 0 {
+		return written, nil
+	}
+	// No pipe is needed if either side is a TLS connection: transferPipe
+	// copies through a userspace buffer right away in that case.
+	if r.p == nil && !isTLSConn(r.src) && !isTLSConn(dst) {
+		p, err := NewPipe()
+		if err != nil {
+			return written, err
+		}
+		r.p = p
+	}
+	if n < 0 {
+		nt, err := transferPipe(r.p, dst, r.src)
+		return written + nt, err
+	}
+	lr := &io.LimitedReader{R: r.src, N: n}
+	nt, err := transferPipe(r.p, dst, lr)
+	written += nt
+	if err == nil && lr.N > 0 {
+		err = io.EOF
+	}
+	return written, err
+}
+
+// Close releases the pipe used by TransferTo, if any. It does not close
+// the source.
+func (r *BufferedReader) Close() error {
+	if r.p == nil {
+		return nil
+	}
+	return r.p.close()
+}
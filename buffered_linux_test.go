@@ -0,0 +1,86 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestBufferedReader(t *testing.T) {
+	first := strings.Repeat("a", 100000)
+	second := strings.Repeat("b", 200000)
+	input := "first 100000\n" + first + "second\n" + second
+
+	client, server, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		io.WriteString(client, input)
+		client.Close()
+	}()
+
+	dstClient, dst, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dstClient.Close()
+	gotc := make(chan []byte, 1)
+	go func() {
+		got, _ := ioutil.ReadAll(dstClient)
+		gotc <- got
+	}()
+
+	br := zerocopy.NewBufferedReader(server)
+	defer br.Close()
+
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "first 100000\n" {
+		t.Fatalf("got line %q", line)
+	}
+	if br.Buffered() == 0 {
+		t.Log("nothing buffered after the first line; test is weaker")
+	}
+	n, err := br.TransferTo(dst, int64(len(first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(first)) {
+		t.Fatalf("transferred %d bytes, want %d", n, len(first))
+	}
+	line, err = br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "second\n" {
+		t.Fatalf("got line %q", line)
+	}
+	n, err = br.TransferTo(dst, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(second)) {
+		t.Fatalf("transferred %d bytes, want %d", n, len(second))
+	}
+	dst.Close()
+	if got := <-gotc; !bytes.Equal(got, []byte(first+second)) {
+		t.Fatalf("got %d bytes, want %d, or content mismatch", len(got), len(first+second))
+	}
+
+	if n, err := br.TransferTo(ioutil.Discard, 10); n != 0 || err != io.EOF {
+		t.Fatalf("at EOF: got (%d, %v), want (0, io.EOF)", n, err)
+	}
+}
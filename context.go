@@ -0,0 +1,89 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// TransferContext is like Transfer, but stops when ctx is done, in which
+// case it returns ctx.Err(), or a *StagedError wrapping ctx.Err(), if data
+// read from src had not yet been written to dst.
+//
+// To interrupt a transfer which is waiting for I/O, TransferContext sets
+// the read deadline of src and the write deadline of dst to a time in the
+// past, using their SetReadDeadline and SetWriteDeadline methods. Once ctx
+// is done, the deadlines must be reset before src or dst can be used
+// again. If src is an *io.LimitedReader, the deadline is set on src.R.
+//
+// If either src or dst does not support deadlines, TransferContext copies
+// the data through a userspace buffer, and checks ctx between reads from
+// src. Reads from src and writes to dst which block, and which do not
+// support deadlines, are not interrupted: cancellation takes effect once
+// they return.
+func TransferContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	rd := src
+	if lr, ok := src.(*io.LimitedReader); ok {
+		rd = lr.R
+	}
+	rdl, rok := rd.(interface{ SetReadDeadline(time.Time) error })
+	wdl, wok := dst.(interface{ SetWriteDeadline(time.Time) error })
+	if !rok || !wok {
+		src = contextReader{ctx: ctx, r: src}
+	}
+	if ctx.Done() == nil {
+		return Transfer(dst, src)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			past := time.Unix(1, 0)
+			if rok {
+				rdl.SetReadDeadline(past)
+			}
+			if wok {
+				wdl.SetWriteDeadline(past)
+			}
+		case <-stop:
+		}
+	}()
+	n, err := Transfer(dst, src)
+	close(stop)
+	<-stopped
+
+	if err != nil {
+		if cerr := ctx.Err(); cerr != nil {
+			if serr, ok := err.(*StagedError); ok {
+				serr.Err = cerr
+				return n, serr
+			}
+			return n, cerr
+		}
+	}
+	return n, err
+}
+
+// contextReader is an io.Reader which stops reading once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(b []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(b)
+}
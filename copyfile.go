@@ -0,0 +1,238 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// CopyStats describes a completed file copy.
+type CopyStats struct {
+	// Bytes is the number of bytes copied.
+	Bytes int64
+
+	// Strategy is the strategy which completed the copy: one of
+	// StrategyReflink, StrategyCopyFileRange, StrategySendfile and
+	// StrategyGeneric. Earlier strategies in the chain may have copied
+	// part of the data.
+	Strategy Strategy
+}
+
+// CopyMetadata is a set of file metadata which CopyFile can preserve.
+type CopyMetadata uint
+
+// Kinds of file metadata.
+const (
+	// PreserveMode preserves the permission bits, as well as the
+	// setuid, setgid and sticky bits.
+	PreserveMode CopyMetadata = 1 << iota
+
+	// PreserveOwner preserves the owner and the group. Changing the
+	// owner usually requires privileges. Only supported on Linux.
+	PreserveOwner
+
+	// PreserveTimes preserves the access and modification times.
+	// On platforms other than Linux, the access time is set to the
+	// modification time.
+	PreserveTimes
+
+	// PreserveXattrs preserves extended attributes, in all namespaces
+	// visible to the process, including security labels such as
+	// security.selinux. Setting a security label requires permission
+	// from the security module. Only supported on Linux.
+	PreserveXattrs
+
+	// PreserveAll preserves all of the above.
+	PreserveAll = PreserveMode | PreserveOwner | PreserveTimes | PreserveXattrs
+)
+
+// CopyFileOptions configures CopyFile. The zero value is the default
+// configuration.
+type CopyFileOptions struct {
+	// NoReflink disables StrategyReflink, for callers which need the data
+	// to be duplicated on the storage, for example for redundancy.
+	NoReflink bool
+
+	// Preserve is the set of metadata to copy from the source to the
+	// destination, after the data has been copied.
+	Preserve CopyMetadata
+
+	// Atomic makes CopyFile write the data to a temporary file in the
+	// directory of the destination, and rename it to the destination
+	// once the copy is complete, so that a partially copied file never
+	// appears at the destination path. The file and the directory are
+	// synced to stable storage before and after the rename respectively.
+	//
+	// The destination is replaced, rather than truncated, so its previous
+	// owner and mode are not kept, and other links to it are unaffected.
+	Atomic bool
+}
+
+// CopyFile copies the contents of the regular file at path src to a file
+// at path dst, which is created if it does not exist, with the permission
+// bits of src, and truncated otherwise. If opts is nil, the default options
+// are used.
+//
+// CopyFile tries StrategyReflink, StrategyCopyFileRange, StrategySendfile
+// and StrategyGeneric, in this order: whenever a strategy is not supported
+// for the files in question, for example because they are on different
+// file systems, CopyFile moves on to the next one, carrying on from where
+// the previous strategy left off. Only StrategyGeneric is supported on
+// platforms other than Linux.
+//
+// If preserving metadata fails, CopyFile returns the error, along with
+// the stats of the data copy, which has completed by then.
+func CopyFile(dst, src string, opts *CopyFileOptions) (CopyStats, error) {
+	if opts == nil {
+		opts = new(CopyFileOptions)
+	}
+	sf, err := os.Open(src)
+	if err != nil {
+		return CopyStats{}, err
+	}
+	defer sf.Close()
+	fi, err := sf.Stat()
+	if err != nil {
+		return CopyStats{}, err
+	}
+	if !fi.Mode().IsRegular() {
+		return CopyStats{}, errors.New("zerocopy: CopyFile: " + src + " is not a regular file")
+	}
+	if opts.Atomic {
+		return copyFileAtomic(dst, sf, fi, opts)
+	}
+	df, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return CopyStats{}, err
+	}
+	stats, err := copyFileData(df, sf, opts)
+	if err == nil {
+		err = preserveMetadata(df, sf, fi, opts.Preserve)
+	}
+	if cerr := df.Close(); err == nil {
+		err = cerr
+	}
+	return stats, err
+}
+
+// CopyFileData copies the data of src, from its current offset to the end
+// of the file, to dst, at its current offset, using the same strategies as
+// CopyFile, and advances both offsets. It reports the number of bytes
+// copied, and the strategy which completed the copy. dst and src must be
+// regular files. If opts is nil, the default options are used. CopyFileData
+// copies data only, so opts.Preserve and opts.Atomic are ignored.
+//
+// StrategyReflink clones the whole file if both offsets are 0 and dst is empty.
+// Otherwise, it clones the remaining range of src, which is only supported
+// if both offsets are multiples of the block size of the file system.
+func CopyFileData(dst, src *os.File, opts *CopyFileOptions) (CopyStats, error) {
+	if opts == nil {
+		opts = new(CopyFileOptions)
+	}
+	return copyFileData(dst, src, opts)
+}
+
+// copyFileAtomic implements CopyFile for opts.Atomic.
+func copyFileAtomic(dst string, sf *os.File, fi os.FileInfo, opts *CopyFileOptions) (stats CopyStats, err error) {
+	dir, base := filepath.Split(dst)
+	if dir == "" {
+		dir = "."
+	}
+	df, err := createTemp(dir, "."+base+".tmp", fi.Mode().Perm())
+	if err != nil {
+		return CopyStats{}, err
+	}
+	defer func() {
+		if err != nil {
+			df.Close()
+			os.Remove(df.Name())
+		}
+	}()
+	stats, err = copyFileData(df, sf, opts)
+	if err != nil {
+		return stats, err
+	}
+	if err := preserveMetadata(df, sf, fi, opts.Preserve); err != nil {
+		return stats, err
+	}
+	if err := df.Sync(); err != nil {
+		return stats, err
+	}
+	if err := df.Close(); err != nil {
+		return stats, err
+	}
+	if err := os.Rename(df.Name(), dst); err != nil {
+		return stats, err
+	}
+	// The file is in place now, so don't remove it if syncing the
+	// directory fails.
+	return stats, syncDir(dir)
+}
+
+// createTemp creates a new file in dir, whose name starts with prefix, with
+// the specified permission bits, subject to the umask. Unlike
+// ioutil.TempFile, which always uses mode 0600, it lets the umask apply to
+// the mode of the destination, as it would to a file created in place.
+func createTemp(dir, prefix string, perm os.FileMode) (*os.File, error) {
+	var b [4]byte
+	for i := 0; ; i++ {
+		rand.Read(b[:])
+		name := filepath.Join(dir, prefix+hex.EncodeToString(b[:]))
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// copyFileBuffered copies the rest of src to dst through a userspace
+// buffer, and accounts for it in stats.
+func copyFileBuffered(dst, src *os.File, stats CopyStats) (CopyStats, error) {
+	n, err := copyFallback(onlyWriter{dst}, onlyReader{src})
+	stats.Bytes += n
+	stats.Strategy = StrategyGeneric
+	return stats, err
+}
+
+// preserveMetadata copies the metadata in the preserve set from src, which
+// was described by fi before the data copy, to dst.
+func preserveMetadata(dst, src *os.File, fi os.FileInfo, preserve CopyMetadata) error {
+	// Changing the owner clears the setuid and setgid bits, and setting
+	// POSIX ACLs, which are extended attributes, changes the permission
+	// bits, so the mode is set after both. Timestamps come last, since
+	// the other changes may update them.
+	if preserve&PreserveOwner != 0 {
+		if err := preserveOwner(dst, fi); err != nil {
+			return err
+		}
+	}
+	if preserve&PreserveXattrs != 0 {
+		if err := preserveXattrs(dst, src); err != nil {
+			return err
+		}
+	}
+	if preserve&PreserveMode != 0 {
+		mode := fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if err := dst.Chmod(mode); err != nil {
+			return err
+		}
+	}
+	if preserve&PreserveTimes != 0 {
+		if err := preserveTimes(dst, fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errPreserveNotSupported is returned when the requested metadata cannot be
+// preserved on the current platform.
+var errPreserveNotSupported = errors.New("zerocopy: CopyFile: metadata not supported on this platform")
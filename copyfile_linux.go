@@ -0,0 +1,347 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyFileData runs the CopyFile strategy chain, from the current offset
+// of src to the current offset of dst.
+func copyFileData(dst, src *os.File, opts *CopyFileOptions) (CopyStats, error) {
+	var stats CopyStats
+	if !opts.NoReflink {
+		n, ok, err := reflink(dst, src)
+		if err != nil {
+			return stats, err
+		}
+		if ok {
+			return CopyStats{Bytes: n, Strategy: StrategyReflink}, nil
+		}
+	}
+
+	n, handled, err := copyFileRangeKernel(dst, src, 1<<63-1)
+	stats.Bytes += n
+	if handled {
+		stats.Strategy = StrategyCopyFileRange
+		return stats, err
+	}
+
+	n, handled, err = sendFileToFile(dst, src)
+	stats.Bytes += n
+	if handled {
+		stats.Strategy = StrategySendfile
+		return stats, err
+	}
+
+	return copyFileBuffered(dst, src, stats)
+}
+
+// fileCloneRange is struct file_clone_range, from linux/fs.h.
+type fileCloneRange struct {
+	srcFD      int64
+	srcOffset  uint64
+	srcLength  uint64
+	destOffset uint64
+}
+
+// reflink makes dst share the rest of the data of src, from the current
+// offset of src to the current offset of dst, and advances both offsets.
+// If both offsets are 0 and dst is empty, reflink uses the FICLONE ioctl,
+// otherwise the FICLONERANGE ioctl, which requires the offsets to be
+// aligned to the block size of the file system. reflink returns false if
+// the file system does not support either for these files.
+func reflink(dst, src *os.File) (n int64, ok bool, err error) {
+	srcoff, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false, nil
+	}
+	dstoff, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false, nil
+	}
+	sfi, err := src.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	dfi, err := dst.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	if srcoff >= sfi.Size() {
+		return 0, false, nil
+	}
+	rrc, err := src.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	wrc, err := dst.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	whole := srcoff == 0 && dstoff == 0 && dfi.Size() == 0
+	var (
+		ioerr  error
+		rrcerr error
+	)
+	wrcerr := wrc.Control(func(wfd uintptr) {
+		rrcerr = rrc.Control(func(rfd uintptr) {
+			if whole {
+				ioerr = unix.IoctlSetInt(int(wfd), ficlone, int(rfd))
+				return
+			}
+			// A length of 0 means up to the end of src, which
+			// need not be aligned.
+			arg := fileCloneRange{
+				srcFD:      int64(rfd),
+				srcOffset:  uint64(srcoff),
+				destOffset: uint64(dstoff),
+			}
+			_, _, errno := unix.Syscall(unix.SYS_IOCTL, wfd, ficlonerange, uintptr(unsafe.Pointer(&arg)))
+			if errno != 0 {
+				ioerr = errno
+			}
+		})
+	})
+	if wrcerr != nil {
+		return 0, false, wrcerr
+	}
+	if rrcerr != nil {
+		return 0, false, rrcerr
+	}
+	switch ioerr {
+	case nil:
+	case unix.EOPNOTSUPP, unix.ENOTTY, unix.EXDEV, unix.EINVAL, unix.EBADF, unix.EPERM, unix.ENOSYS:
+		return 0, false, nil
+	default:
+		return 0, false, os.NewSyscallError("ioctl", ioerr)
+	}
+	n = sfi.Size() - srcoff
+	if _, err := src.Seek(n, io.SeekCurrent); err != nil {
+		return n, true, err
+	}
+	if _, err := dst.Seek(n, io.SeekCurrent); err != nil {
+		return n, true, err
+	}
+	return n, true, nil
+}
+
+// sendFileToFile copies the rest of src to dst, using sendfile(2), which
+// supports regular file destinations since Linux 2.6.33. If handled is
+// false, sendfile(2) could not be used, or found src empty, and the rest
+// of the data, if any, must be copied by other means.
+func sendFileToFile(dst, src *os.File) (copied int64, handled bool, err error) {
+	rrc, err := src.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	wrc, err := dst.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	tw := TwoFDWaiter{R: rrc, W: wrc}
+	for {
+		var n int
+		release := acquireFileIO()
+		err := tw.Do(func(rfd, wfd uintptr) error {
+			var err error
+			n, err = unix.Sendfile(int(wfd), int(rfd), nil, maxSpliceSize)
+			return err
+		})
+		release()
+		switch err {
+		case nil:
+		case unix.EINVAL, unix.ENOSYS:
+			if copied == 0 {
+				return 0, false, nil
+			}
+			return copied, true, os.NewSyscallError("sendfile", err)
+		default:
+			return copied, true, os.NewSyscallError("sendfile", err)
+		}
+		if n == 0 {
+			if copied == 0 {
+				// src was empty, so sendfile(2) copied
+				// nothing. Leave it to the buffered copy,
+				// rather than report a strategy which was
+				// never used.
+				return 0, false, nil
+			}
+			return copied, true, nil
+		}
+		copied += int64(n)
+	}
+}
+
+func preserveOwner(dst *os.File, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errPreserveNotSupported
+	}
+	return dst.Chown(int(st.Uid), int(st.Gid))
+}
+
+// preserveTimes sets the access and modification times of dst to those
+// in fi. It goes through the file descriptor, rather than dst.Name(),
+// which may name a different file by now.
+func preserveTimes(dst *os.File, fi os.FileInfo) error {
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(fileAtime(fi).UnixNano()),
+		unix.NsecToTimespec(fi.ModTime().UnixNano()),
+	}
+	rc, err := dst.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var uerr error
+	err = rc.Control(func(fd uintptr) {
+		// utimensat(2) with a NULL path would do, but x/sys/unix
+		// has no way to pass one, so use the same path glibc uses
+		// for futimes(3).
+		path := "/proc/self/fd/" + strconv.Itoa(int(fd))
+		uerr = unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, 0)
+	})
+	if err != nil {
+		return err
+	}
+	if uerr != nil {
+		return &os.PathError{Op: "utimensat", Path: dst.Name(), Err: uerr}
+	}
+	return nil
+}
+
+func fileAtime(fi os.FileInfo) time.Time {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fi.ModTime()
+	}
+	return time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec))
+}
+
+// preserveXattrs copies the extended attributes of src to dst.
+func preserveXattrs(dst, src *os.File) error {
+	rrc, err := src.SyscallConn()
+	if err != nil {
+		return err
+	}
+	wrc, err := dst.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var xerr error
+	wrcerr := wrc.Control(func(wfd uintptr) {
+		rrcerr := rrc.Control(func(rfd uintptr) {
+			xerr = copyXattrs(int(wfd), int(rfd))
+		})
+		if xerr == nil {
+			xerr = rrcerr
+		}
+	})
+	if wrcerr != nil {
+		return wrcerr
+	}
+	return xerr
+}
+
+func copyXattrs(wfd, rfd int) error {
+	names, err := listXattrs(rfd)
+	if err != nil {
+		return err
+	}
+	var val []byte
+	for _, name := range names {
+		val, err = getXattr(rfd, name, val)
+		if err == unix.ENODATA {
+			// Removed since listed.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := unix.Fsetxattr(wfd, name, val, 0); err != nil {
+			return os.NewSyscallError("fsetxattr", err)
+		}
+	}
+	return nil
+}
+
+// listXattrs returns the names of the extended attributes of fd.
+func listXattrs(fd int) ([]string, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := unix.Flistxattr(fd, buf)
+		switch err {
+		case nil:
+		case unix.ERANGE:
+			n, err = unix.Flistxattr(fd, nil)
+			if err != nil {
+				return nil, os.NewSyscallError("flistxattr", err)
+			}
+			buf = make([]byte, n)
+			continue
+		case unix.ENOTSUP:
+			// The file system does not support extended attributes,
+			// so there are none to copy.
+			return nil, nil
+		default:
+			return nil, os.NewSyscallError("flistxattr", err)
+		}
+		var names []string
+		for _, name := range strings.Split(string(buf[:n]), "\x00") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	}
+}
+
+// getXattr returns the value of the extended attribute name of fd, reusing
+// buf if it is large enough.
+func getXattr(fd int, name string, buf []byte) ([]byte, error) {
+	if cap(buf) == 0 {
+		buf = make([]byte, 256)
+	}
+	for {
+		n, err := unix.Fgetxattr(fd, name, buf[:cap(buf)])
+		switch err {
+		case nil:
+			return buf[:n], nil
+		case unix.ERANGE:
+			n, err = unix.Fgetxattr(fd, name, nil)
+			if err != nil {
+				return nil, os.NewSyscallError("fgetxattr", err)
+			}
+			buf = make([]byte, n)
+		case unix.ENODATA:
+			return nil, err
+		default:
+			return nil, os.NewSyscallError("fgetxattr", err)
+		}
+	}
+}
+
+// syncDir syncs the directory at path to stable storage, so that a rename
+// into it is durable.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
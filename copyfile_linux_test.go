@@ -0,0 +1,280 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyFile(t *testing.T) {
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+
+	dir, err := ioutil.TempDir("", "zerocopy-copyfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, content, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		dst := filepath.Join(dir, "default")
+		stats, err := zerocopy.CopyFile(dst, src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("strategy: %v", stats.Strategy)
+		expectCopyFileResult(t, dst, stats, content)
+	})
+	t.Run("NoReflink", func(t *testing.T) {
+		dst := filepath.Join(dir, "noreflink")
+		opts := &zerocopy.CopyFileOptions{NoReflink: true}
+		stats, err := zerocopy.CopyFile(dst, src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Strategy == zerocopy.StrategyReflink {
+			t.Fatal("used reflink with NoReflink set")
+		}
+		expectCopyFileResult(t, dst, stats, content)
+	})
+	t.Run("Truncate", func(t *testing.T) {
+		dst := filepath.Join(dir, "truncate")
+		if err := ioutil.WriteFile(dst, make([]byte, 2<<20), 0640); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := zerocopy.CopyFile(dst, src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectCopyFileResult(t, dst, stats, content)
+	})
+	t.Run("Empty", func(t *testing.T) {
+		src := filepath.Join(dir, "empty-src")
+		if err := ioutil.WriteFile(src, nil, 0640); err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(dir, "empty")
+		opts := &zerocopy.CopyFileOptions{NoReflink: true}
+		stats, err := zerocopy.CopyFile(dst, src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Strategy != zerocopy.StrategyGeneric {
+			t.Errorf("strategy is %v, want %v", stats.Strategy, zerocopy.StrategyGeneric)
+		}
+		expectCopyFileResult(t, dst, stats, nil)
+	})
+	t.Run("Procfs", func(t *testing.T) {
+		want, err := ioutil.ReadFile("/proc/self/stat")
+		if err != nil {
+			t.Skip(err)
+		}
+		dst := filepath.Join(dir, "procfs")
+		stats, err := zerocopy.CopyFile(dst, "/proc/self/stat", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) == 0 || stats.Bytes != int64(len(got)) {
+			t.Fatalf("copied %d bytes, file has %d (want about %d)", stats.Bytes, len(got), len(want))
+		}
+	})
+	t.Run("Preserve", func(t *testing.T) {
+		src := filepath.Join(dir, "preserve-src")
+		if err := ioutil.WriteFile(src, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		atime := time.Date(2001, 2, 3, 4, 5, 6, 7, time.UTC)
+		mtime := time.Date(2002, 3, 4, 5, 6, 7, 8, time.UTC)
+		if err := os.Chtimes(src, atime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		xattr := true
+		if err := unix.Setxattr(src, "user.zerocopy", []byte("test"), 0); err != nil {
+			t.Logf("extended attributes not supported: %v", err)
+			xattr = false
+		}
+		owner := os.Getuid() == 0
+		if owner {
+			if err := os.Chown(src, 1, 2); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Chown clears the setgid bit, so set the mode afterwards.
+		if err := os.Chmod(src, 0751|os.ModeSetgid); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := filepath.Join(dir, "preserve-dst")
+		preserve := zerocopy.PreserveMode | zerocopy.PreserveTimes
+		if xattr {
+			preserve |= zerocopy.PreserveXattrs
+		}
+		if owner {
+			preserve |= zerocopy.PreserveOwner
+		}
+		opts := &zerocopy.CopyFileOptions{Preserve: preserve}
+		if _, err := zerocopy.CopyFile(dst, src, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := os.FileMode(0751) | os.ModeSetgid; fi.Mode() != want {
+			t.Errorf("mode is %v, want %v", fi.Mode(), want)
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("modification time is %v, want %v", fi.ModTime(), mtime)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if got := time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec)); !got.Equal(atime) {
+			t.Errorf("access time is %v, want %v", got, atime)
+		}
+		if owner && (st.Uid != 1 || st.Gid != 2) {
+			t.Errorf("owner is %d:%d, want 1:2", st.Uid, st.Gid)
+		}
+		if xattr {
+			buf := make([]byte, 16)
+			n, err := unix.Getxattr(dst, "user.zerocopy", buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(buf[:n]); got != "test" {
+				t.Errorf("got extended attribute %q, want %q", got, "test")
+			}
+		}
+	})
+	t.Run("Atomic", func(t *testing.T) {
+		sub, err := ioutil.TempDir(dir, "atomic")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(sub, "dst")
+		old := []byte("old content")
+		if err := ioutil.WriteFile(dst, old, 0640); err != nil {
+			t.Fatal(err)
+		}
+		link := filepath.Join(sub, "link")
+		if err := os.Link(dst, link); err != nil {
+			t.Fatal(err)
+		}
+		opts := &zerocopy.CopyFileOptions{Atomic: true}
+		stats, err := zerocopy.CopyFile(dst, src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectCopyFileResult(t, dst, stats, content)
+
+		// The destination was replaced, so the other link still
+		// refers to the old file.
+		got, err := ioutil.ReadFile(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(old) {
+			t.Errorf("other link has content %q, want %q", got, old)
+		}
+		names, err := ioutil.ReadDir(sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 2 {
+			t.Errorf("found %d files after the copy, want 2", len(names))
+		}
+	})
+	t.Run("Data", func(t *testing.T) {
+		sf, err := os.Open(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sf.Close()
+		header := make([]byte, 4096)
+		rand.Read(header)
+		dst := filepath.Join(dir, "data")
+		if err := ioutil.WriteFile(dst, header, 0640); err != nil {
+			t.Fatal(err)
+		}
+		df, err := os.OpenFile(dst, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer df.Close()
+
+		// Block-aligned offsets, so that reflink can use
+		// FICLONERANGE where it is supported.
+		if _, err := sf.Seek(8192, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := df.Seek(0, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := zerocopy.CopyFileData(df, sf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("strategy: %v", stats.Strategy)
+		rest := content[8192:]
+		if stats.Bytes != int64(len(rest)) {
+			t.Errorf("copied %d bytes, want %d", stats.Bytes, len(rest))
+		}
+		if off, _ := sf.Seek(0, io.SeekCurrent); off != int64(len(content)) {
+			t.Errorf("source offset is %d, want %d", off, len(content))
+		}
+		if off, _ := df.Seek(0, io.SeekCurrent); off != int64(len(header)+len(rest)) {
+			t.Errorf("destination offset is %d, want %d", off, len(header)+len(rest))
+		}
+		expectFileContent(t, df, append(header, rest...))
+	})
+	t.Run("NotRegular", func(t *testing.T) {
+		dst := filepath.Join(dir, "notregular")
+		if _, err := zerocopy.CopyFile(dst, dir, nil); err == nil {
+			t.Fatal("copied a directory")
+		}
+	})
+}
+
+func expectCopyFileResult(t *testing.T, name string, stats zerocopy.CopyStats, want []byte) {
+	t.Helper()
+	if stats.Bytes != int64(len(want)) {
+		t.Errorf("copied %d bytes, want %d", stats.Bytes, len(want))
+	}
+	if stats.Strategy.String() == "unknown" {
+		t.Errorf("unknown strategy %d", stats.Strategy)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	expectFileContent(t, f, want)
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("destination mode is %v, want %v", fi.Mode().Perm(), os.FileMode(0640))
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package zerocopy
+
+import "os"
+
+func copyFileData(dst, src *os.File, opts *CopyFileOptions) (CopyStats, error) {
+	return copyFileBuffered(dst, src, CopyStats{})
+}
+
+func preserveOwner(dst *os.File, fi os.FileInfo) error {
+	return errPreserveNotSupported
+}
+
+func preserveXattrs(dst, src *os.File) error {
+	return errPreserveNotSupported
+}
+
+func preserveTimes(dst *os.File, fi os.FileInfo) error {
+	return os.Chtimes(dst.Name(), fi.ModTime(), fi.ModTime())
+}
+
+// syncDir syncs the directory at path to stable storage, where the
+// platform supports it. Errors are ignored, since some platforms do not
+// allow syncing directories at all.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	d.Sync()
+	d.Close()
+	return nil
+}
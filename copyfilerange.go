@@ -0,0 +1,45 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+)
+
+// CopyFileRange copies n bytes from src to dst, starting at the current
+// file offsets, and advances both offsets by the number of bytes copied.
+// If n is negative, CopyFileRange copies until the end of src. It stops
+// early, without an error, if it reaches the end of src.
+//
+// On Linux, CopyFileRange uses copy_file_range(2), which lets the kernel,
+// or the file system, copy the data without passing it through userspace,
+// possibly on the storage server, for network file systems. If that is not
+// possible, because the files are on different file systems and the kernel
+// does not support copies across file systems, or because the file system
+// does not support copy_file_range(2) at all, CopyFileRange falls back to
+// a generic copy. The same happens if copy_file_range(2) reports a short
+// copy which does not correspond to the end of src, which is a known quirk
+// of some file systems, such as procfs, and some NFS and CIFS
+// implementations.
+func CopyFileRange(dst, src *os.File, n int64) (int64, error) {
+	return copyFileRange(dst, src, n)
+}
+
+// copyFileRangeGeneric implements CopyFileRange using a generic copy.
+func copyFileRangeGeneric(dst, src *os.File, n int64) (int64, error) {
+	var r io.Reader = onlyReader{src}
+	if n >= 0 {
+		r = io.LimitReader(src, n)
+	}
+	return copyFallback(onlyWriter{dst}, r)
+}
+
+// onlyWriter hides the ReadFrom method of *os.File, which would use
+// copy_file_range(2) again.
+type onlyWriter struct {
+	io.Writer
+}
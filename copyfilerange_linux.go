@@ -0,0 +1,134 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxCopyFileRangeSize is the maximum number of bytes we ask
+// copy_file_range(2) to copy in one call.
+const maxCopyFileRangeSize = 1 << 30
+
+func copyFileRange(dst, src *os.File, n int64) (int64, error) {
+	if n < 0 {
+		n = 1<<63 - 1
+	}
+	copied, handled, err := copyFileRangeKernel(dst, src, n)
+	if handled {
+		return copied, err
+	}
+	written, err := copyFileRangeGeneric(dst, src, n-copied)
+	return copied + written, err
+}
+
+// copyFileRangeKernel copies up to n bytes from src to dst using
+// copy_file_range(2). If handled is false, copy_file_range(2) could not be
+// used, and the remaining n-copied bytes must be copied by other means.
+func copyFileRangeKernel(dst, src *os.File, n int64) (copied int64, handled bool, err error) {
+	if !copyFileRangeAllowed() {
+		return 0, false, nil
+	}
+	fi, err := src.Stat()
+	if err != nil {
+		return 0, true, err
+	}
+	if fi.Size() == 0 {
+		// Files in procfs and sysfs report a size of 0, and
+		// copy_file_range(2) copies nothing from them.
+		return 0, false, nil
+	}
+	if !copyFileRangeCrossFS() {
+		dfi, err := dst.Stat()
+		if err != nil {
+			return 0, true, err
+		}
+		if !sameDevice(fi, dfi) {
+			return 0, false, nil
+		}
+	}
+	rrc, err := src.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	wrc, err := dst.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+
+	// Regular files are always ready, so in practice, TwoFDWaiter never
+	// waits, but it keeps us honest if src or dst are something else.
+	tw := TwoFDWaiter{R: rrc, W: wrc}
+	for copied < n {
+		max := maxCopyFileRangeSize
+		if int64(max) > n-copied {
+			max = int(n - copied)
+		}
+		var c int
+		release := acquireFileIO()
+		err := tw.Do(func(rfd, wfd uintptr) error {
+			var err error
+			c, err = unix.CopyFileRange(int(rfd), nil, int(wfd), nil, max, 0)
+			return err
+		})
+		release()
+		switch err {
+		case nil:
+		case unix.ENOSYS, unix.EXDEV, unix.EOPNOTSUPP, unix.EINVAL, unix.EPERM:
+			// Either copy_file_range(2) is not supported at all
+			// (ENOSYS), or not across these file systems
+			// (EXDEV, since Linux 5.19, if they are of
+			// different types; older kernels are caught above),
+			// or not by this file system (EOPNOTSUPP, EINVAL),
+			// or not for these files (EPERM: immutable or
+			// append-only files, for example).
+			return copied, false, nil
+		case unix.EIO, unix.EBADF:
+			// Some NFS and CIFS implementations report EIO, and
+			// destination files opened with O_APPEND result in
+			// EBADF. Neither is conclusive once data has been
+			// copied.
+			if copied == 0 {
+				return 0, false, nil
+			}
+			return copied, true, os.NewSyscallError("copy_file_range", err)
+		default:
+			return copied, true, os.NewSyscallError("copy_file_range", err)
+		}
+		if c == 0 {
+			// copy_file_range(2) returns 0 at the end of the file,
+			// but some file systems also return 0 when they
+			// cannot copy. Tell the two cases apart.
+			off, err := src.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return copied, true, err
+			}
+			fi, err := src.Stat()
+			if err != nil {
+				return copied, true, err
+			}
+			return copied, off >= fi.Size(), nil
+		}
+		copied += int64(c)
+		count(&metrics.bytesCopyFileRange, int64(c))
+	}
+	return copied, true, nil
+}
+
+// sameDevice reports whether the files described by a and b, as returned by
+// Stat on an *os.File, live on the same device.
+func sameDevice(a, b os.FileInfo) bool {
+	sa, ok := a.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	sb, ok := b.Sys().(*syscall.Stat_t)
+	return ok && sa.Dev == sb.Dev
+}
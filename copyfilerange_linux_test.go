@@ -0,0 +1,147 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestCopyFileRange(t *testing.T) {
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+
+	t.Run("All", func(t *testing.T) {
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+		dst := newCopyFileRangeDst(t, 0)
+		defer os.Remove(dst.Name())
+		defer dst.Close()
+
+		n, err := zerocopy.CopyFileRange(dst, src, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(content)) {
+			t.Fatalf("copied %d bytes, want %d", n, len(content))
+		}
+		expectFileContent(t, dst, content)
+	})
+	t.Run("Range", func(t *testing.T) {
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+		dst := newCopyFileRangeDst(t, 0)
+		defer os.Remove(dst.Name())
+		defer dst.Close()
+
+		if _, err := src.Seek(100, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		n, err := zerocopy.CopyFileRange(dst, src, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1000 {
+			t.Fatalf("copied %d bytes, want 1000", n)
+		}
+		off, err := src.Seek(0, io.SeekCurrent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off != 1100 {
+			t.Fatalf("source offset is %d, want 1100", off)
+		}
+		expectFileContent(t, dst, content[100:1100])
+	})
+	t.Run("PastEOF", func(t *testing.T) {
+		src := newSendFileTestFile(t, content[:10])
+		defer os.Remove(src.Name())
+		defer src.Close()
+		dst := newCopyFileRangeDst(t, 0)
+		defer os.Remove(dst.Name())
+		defer dst.Close()
+
+		n, err := zerocopy.CopyFileRange(dst, src, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 10 {
+			t.Fatalf("copied %d bytes, want 10", n)
+		}
+	})
+	t.Run("Procfs", func(t *testing.T) {
+		// Files in procfs report a size of 0.
+		want, err := ioutil.ReadFile("/proc/self/cmdline")
+		if err != nil {
+			t.Skip(err)
+		}
+		src, err := os.Open("/proc/self/cmdline")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		dst := newCopyFileRangeDst(t, 0)
+		defer os.Remove(dst.Name())
+		defer dst.Close()
+
+		if _, err := zerocopy.CopyFileRange(dst, src, -1); err != nil {
+			t.Fatal(err)
+		}
+		expectFileContent(t, dst, want)
+	})
+	t.Run("Append", func(t *testing.T) {
+		// copy_file_range(2) refuses destinations opened with O_APPEND.
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+		dst := newCopyFileRangeDst(t, os.O_APPEND)
+		defer os.Remove(dst.Name())
+		defer dst.Close()
+
+		if _, err := zerocopy.CopyFileRange(dst, src, -1); err != nil {
+			t.Fatal(err)
+		}
+		expectFileContent(t, dst, content)
+	})
+}
+
+func newCopyFileRangeDst(t *testing.T, flag int) *os.File {
+	t.Helper()
+	f, err := ioutil.TempFile("", "zerocopy-copyfilerange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flag == 0 {
+		return f
+	}
+	name := f.Name()
+	f.Close()
+	f, err = os.OpenFile(name, os.O_RDWR|flag, 0)
+	if err != nil {
+		os.Remove(name)
+		t.Fatal(err)
+	}
+	return f
+}
+
+func expectFileContent(t *testing.T, f *os.File, want []byte) {
+	t.Helper()
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d bytes, matching", len(got), len(want))
+	}
+}
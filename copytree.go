@@ -0,0 +1,271 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A SymlinkPolicy specifies how CopyTree handles symbolic links.
+type SymlinkPolicy int
+
+// Symbolic link policies.
+const (
+	// SymlinkCopy creates a symbolic link with the same target in the
+	// destination tree. The target is not rewritten, so relative links
+	// to locations outside the tree may be broken in the copy.
+	SymlinkCopy SymlinkPolicy = iota
+
+	// SymlinkFollow copies the file or directory which the link refers
+	// to, as if it were found in place of the link. Loops are reported
+	// as errors.
+	SymlinkFollow
+
+	// SymlinkSkip ignores symbolic links.
+	SymlinkSkip
+)
+
+// CopyTreeProgress describes the progress of CopyTree, after a file has
+// been copied.
+type CopyTreeProgress struct {
+	// Path is the path of the file, relative to the root of the tree.
+	Path string
+
+	// Stats describes the copy of the file.
+	Stats CopyStats
+
+	// Files and Bytes are the total number of files and bytes copied
+	// so far, including this file.
+	Files int
+	Bytes int64
+}
+
+// CopyTreeOptions configures CopyTree. The zero value is the default
+// configuration.
+type CopyTreeOptions struct {
+	// File configures the copy of each regular file. Preserve applies
+	// to directories as well.
+	File CopyFileOptions
+
+	// Concurrency is the maximum number of files to copy concurrently.
+	// If Concurrency is not positive, files are copied one at a time.
+	Concurrency int
+
+	// Symlinks specifies how symbolic links are handled.
+	Symlinks SymlinkPolicy
+
+	// Progress, if not nil, is called after each regular file has been
+	// copied. Calls are not concurrent, but may happen on different
+	// goroutines.
+	Progress func(CopyTreeProgress)
+}
+
+// CopyTree copies the directory tree rooted at src to dst, using CopyFile
+// for regular files. dst is created if it does not exist. Existing regular
+// files in dst are overwritten. Kinds of files other than regular files,
+// directories and symbolic links, such as devices and named pipes, are
+// skipped. If opts is nil, the default options are used.
+//
+// Directories are created with mode 0700 while the tree is being copied,
+// and receive the permission bits of their source once their contents
+// are complete, so that read-only directories can be populated.
+//
+// CopyTree stops at the first error, after the copies already in progress
+// have completed, and returns the error.
+func CopyTree(dst, src string, opts *CopyTreeOptions) error {
+	if opts == nil {
+		opts = new(CopyTreeOptions)
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New("zerocopy: CopyTree: " + src + " is not a directory")
+	}
+	tc := &treeCopier{
+		opts: opts,
+		jobs: make(chan treeCopyJob),
+	}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			tc.work()
+		}()
+	}
+	err = tc.walk(dst, src, ".", fi, nil)
+	close(tc.jobs)
+	wg.Wait()
+	if err == nil {
+		err = tc.firstErr()
+	}
+	if err != nil {
+		return err
+	}
+	// Directories are finished bottom up, so that setting the mode or
+	// the times of a directory happens after its contents are complete.
+	for i := len(tc.dirs) - 1; i >= 0; i-- {
+		d := tc.dirs[i]
+		if err := finishDir(d.dst, d.src, d.fi, opts.File.Preserve); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeCopier holds the state of a CopyTree call.
+type treeCopier struct {
+	opts *CopyTreeOptions
+	jobs chan treeCopyJob
+	dirs []treeCopyDir
+
+	mu    sync.Mutex
+	err   error
+	files int
+	bytes int64
+}
+
+type treeCopyJob struct {
+	dst, src, rel string
+}
+
+type treeCopyDir struct {
+	dst, src string
+	fi       os.FileInfo
+}
+
+// walk copies the entry at src, described by fi, to dst. rel is the path
+// of the entry relative to the root of the tree. ancestors holds the
+// directories on the path from the root to the entry, for detecting loops
+// through symbolic links.
+func (tc *treeCopier) walk(dst, src, rel string, fi os.FileInfo, ancestors []os.FileInfo) error {
+	if tc.firstErr() != nil {
+		return nil
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		switch tc.opts.Symlinks {
+		case SymlinkSkip:
+			return nil
+		case SymlinkFollow:
+			var err error
+			fi, err = os.Stat(src)
+			if err != nil {
+				return err
+			}
+		default:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		}
+	}
+	switch {
+	case fi.Mode().IsRegular():
+		tc.jobs <- treeCopyJob{dst: dst, src: src, rel: rel}
+		return nil
+	case fi.IsDir():
+	default:
+		return nil
+	}
+
+	for _, a := range ancestors {
+		if os.SameFile(a, fi) {
+			return errors.New("zerocopy: CopyTree: symbolic link loop at " + src)
+		}
+	}
+	if err := os.Mkdir(dst, 0700); err != nil {
+		dfi, serr := os.Stat(dst)
+		if serr != nil || !dfi.IsDir() {
+			return err
+		}
+	}
+	tc.dirs = append(tc.dirs, treeCopyDir{dst: dst, src: src, fi: fi})
+	d, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+	ancestors = append(ancestors, fi)
+	for _, name := range names {
+		csrc := filepath.Join(src, name)
+		cfi, err := os.Lstat(csrc)
+		if err != nil {
+			return err
+		}
+		cdst := filepath.Join(dst, name)
+		crel := filepath.Join(rel, name)
+		if err := tc.walk(cdst, csrc, crel, cfi, ancestors[:len(ancestors):len(ancestors)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// work copies files received on tc.jobs, until the channel is closed.
+// After an error, remaining jobs are discarded.
+func (tc *treeCopier) work() {
+	for job := range tc.jobs {
+		if tc.firstErr() != nil {
+			continue
+		}
+		stats, err := CopyFile(job.dst, job.src, &tc.opts.File)
+		tc.mu.Lock()
+		if err != nil {
+			if tc.err == nil {
+				tc.err = err
+			}
+			tc.mu.Unlock()
+			continue
+		}
+		tc.files++
+		tc.bytes += stats.Bytes
+		if tc.opts.Progress != nil {
+			tc.opts.Progress(CopyTreeProgress{
+				Path:  job.rel,
+				Stats: stats,
+				Files: tc.files,
+				Bytes: tc.bytes,
+			})
+		}
+		tc.mu.Unlock()
+	}
+}
+
+func (tc *treeCopier) firstErr() error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.err
+}
+
+// finishDir sets the metadata of the directory at dst, which is a copy of
+// the directory at src, described by fi. The mode is always set.
+func finishDir(dst, src string, fi os.FileInfo, preserve CopyMetadata) error {
+	sd, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sd.Close()
+	dd, err := os.Open(dst)
+	if err != nil {
+		return err
+	}
+	defer dd.Close()
+	return preserveMetadata(dd, sd, fi, preserve|PreserveMode)
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestCopyTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zerocopy-copytree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	files := map[string]string{
+		"a":           "hello",
+		"b/c":         "world",
+		"b/d/e":       "",
+		"readonly/f":  "read only",
+		"b/d/g/h/i/j": "deep",
+	}
+	for name, content := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("b/c", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "readonly"), 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(src, "readonly"), 0755)
+
+	t.Run("SymlinkCopy", func(t *testing.T) {
+		dst := filepath.Join(dir, "copy")
+		var (
+			mu       sync.Mutex
+			progress []zerocopy.CopyTreeProgress
+		)
+		opts := &zerocopy.CopyTreeOptions{
+			Concurrency: 4,
+			Progress: func(p zerocopy.CopyTreeProgress) {
+				mu.Lock()
+				progress = append(progress, p)
+				mu.Unlock()
+			},
+		}
+		if err := zerocopy.CopyTree(dst, src, opts); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(filepath.Join(dst, "readonly"), 0755)
+		expectTreeFiles(t, dst, files)
+		target, err := os.Readlink(filepath.Join(dst, "link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target != "b/c" {
+			t.Errorf("link target is %q, want %q", target, "b/c")
+		}
+		fi, err := os.Stat(filepath.Join(dst, "readonly"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0555 {
+			t.Errorf("directory mode is %v, want %v", fi.Mode().Perm(), os.FileMode(0555))
+		}
+		if len(progress) != len(files) {
+			t.Fatalf("got %d progress reports, want %d", len(progress), len(files))
+		}
+		var total int64
+		for _, content := range files {
+			total += int64(len(content))
+		}
+		last := progress[len(progress)-1]
+		if last.Files != len(files) || last.Bytes != total {
+			t.Errorf("final progress is %d files, %d bytes, want %d files, %d bytes",
+				last.Files, last.Bytes, len(files), total)
+		}
+	})
+	t.Run("SymlinkFollow", func(t *testing.T) {
+		dst := filepath.Join(dir, "follow")
+		opts := &zerocopy.CopyTreeOptions{Symlinks: zerocopy.SymlinkFollow}
+		if err := zerocopy.CopyTree(dst, src, opts); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(filepath.Join(dst, "readonly"), 0755)
+		want := map[string]string{"link": files["b/c"]}
+		for name, content := range files {
+			want[name] = content
+		}
+		expectTreeFiles(t, dst, want)
+		fi, err := os.Lstat(filepath.Join(dst, "link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.Mode().IsRegular() {
+			t.Errorf("link was copied as %v, want a regular file", fi.Mode())
+		}
+	})
+	t.Run("SymlinkSkip", func(t *testing.T) {
+		dst := filepath.Join(dir, "skip")
+		opts := &zerocopy.CopyTreeOptions{Symlinks: zerocopy.SymlinkSkip}
+		if err := zerocopy.CopyTree(dst, src, opts); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(filepath.Join(dst, "readonly"), 0755)
+		expectTreeFiles(t, dst, files)
+		if _, err := os.Lstat(filepath.Join(dst, "link")); !os.IsNotExist(err) {
+			t.Errorf("link exists in the copy: %v", err)
+		}
+	})
+	t.Run("Loop", func(t *testing.T) {
+		loop := filepath.Join(dir, "loop")
+		if err := os.MkdirAll(filepath.Join(loop, "x"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("..", filepath.Join(loop, "x", "up")); err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(dir, "loopcopy")
+		opts := &zerocopy.CopyTreeOptions{Symlinks: zerocopy.SymlinkFollow}
+		if err := zerocopy.CopyTree(dst, loop, opts); err == nil {
+			t.Fatal("copied a tree with a symbolic link loop")
+		}
+	})
+}
+
+func expectTreeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, want := range files {
+		got, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
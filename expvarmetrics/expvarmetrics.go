@@ -0,0 +1,34 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package expvarmetrics exports the aggregate counters of package zerocopy
+// through package expvar.
+//
+// Package expvar serves its variables on http.DefaultServeMux, at
+// /debug/vars, as soon as it is imported, so this is a package of its
+// own, rather than part of package zerocopy, which programs may import
+// without wanting either.
+package expvarmetrics
+
+import (
+	"expvar"
+	"sync"
+
+	"acln.ro/zerocopy"
+)
+
+var publish sync.Once
+
+// Enable publishes the counters returned by zerocopy.ReadMetrics as the
+// expvar variable "zerocopy", so that they are served by the /debug/vars
+// endpoint, along with the other variables of the program. Calling Enable
+// more than once has no further effect.
+func Enable() {
+	publish.Do(func() {
+		expvar.Publish("zerocopy", expvar.Func(func() interface{} {
+			return zerocopy.ReadMetrics()
+		}))
+	})
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package expvarmetrics_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"acln.ro/zerocopy"
+	"acln.ro/zerocopy/expvarmetrics"
+)
+
+func TestEnable(t *testing.T) {
+	expvarmetrics.Enable()
+	expvarmetrics.Enable() // must not panic
+
+	v := expvar.Get("zerocopy")
+	if v == nil {
+		t.Fatal("zerocopy variable not published")
+	}
+	var m zerocopy.Metrics
+	if err := json.Unmarshal([]byte(v.String()), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.TransfersStarted < m.TransfersCompleted {
+		t.Errorf("started %d transfers, but completed %d", m.TransfersStarted, m.TransfersCompleted)
+	}
+}
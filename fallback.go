@@ -0,0 +1,106 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultFallbackBufferSize = 32 << 10
+
+var (
+	fallbackBufferSize int64 = defaultFallbackBufferSize
+	fallbackPool       sync.Pool
+)
+
+// SetFallbackBufferSize sets the size of the buffers used for generic
+// copies, when I/O acceleration is not possible. Buffers are pooled and
+// shared by all pipes and transfers. The default size is 32 KiB.
+//
+// SetFallbackBufferSize panics if n is not positive.
+func SetFallbackBufferSize(n int) {
+	if n <= 0 {
+		panic("zerocopy: non-positive fallback buffer size")
+	}
+	atomic.StoreInt64(&fallbackBufferSize, int64(n))
+}
+
+// copyFallback is like io.Copy, but uses a pooled buffer.
+//
+// If src implements io.WriterTo, or dst implements io.ReaderFrom, the copy
+// is left to them, as io.Copy does, so that destinations such as
+// *bytes.Buffer or *gzip.Writer can read directly into their own memory,
+// rather than through an intermediate buffer. No buffer is taken from the
+// pool in this case.
+func copyFallback(dst io.Writer, src io.Reader) (n int64, err error) {
+	count(&metrics.fallbacks, 1)
+	defer func() { count(&metrics.bytesGeneric, n) }()
+	if wt, ok := src.(io.WriterTo); ok {
+		return wt.WriteTo(dst)
+	}
+	if rf, ok := dst.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	bp := getFallbackBuffer()
+	defer fallbackPool.Put(bp)
+	return io.CopyBuffer(dst, src, *bp)
+}
+
+// copyFallbackBuffer is like copyFallback, but copies through buf, if it
+// is not nil.
+func copyFallbackBuffer(dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	if buf == nil {
+		return copyFallback(dst, src)
+	}
+	count(&metrics.fallbacks, 1)
+	n, err := io.CopyBuffer(dst, src, buf)
+	count(&metrics.bytesGeneric, n)
+	return n, err
+}
+
+func getFallbackBuffer() *[]byte {
+	size := int(atomic.LoadInt64(&fallbackBufferSize))
+	if bp, ok := fallbackPool.Get().(*[]byte); ok && len(*bp) == size {
+		return bp
+	}
+	// Either the pool is empty, or the buffer size has changed since
+	// the buffer was allocated. In the latter case, we drop the old
+	// buffer on the floor.
+	b := make([]byte, size)
+	return &b
+}
+
+// fallbackReader returns the reader to use for generic copies out of p.
+//
+// If p is not teeing data, this is the read side of the pipe itself,
+// which lets the destination's ReadFrom method, or the WriteTo method of
+// *os.File, see the underlying file and use their own optimizations.
+// Otherwise, reads must apply the tee policy, as p.Read does, so p is
+// wrapped in order to hide its WriteTo method, which would recurse. The
+// wrapper does not count the bytes it reads, nor trace the reads: the
+// WriteTo call which falls back does.
+func (p *Pipe) fallbackReader() io.Reader {
+	teerd, teepipes, _ := p.teeState()
+	if len(teepipes) == 0 && teerd == io.Reader(p.r) {
+		return p.r
+	}
+	return policyReader{p}
+}
+
+// policyReader reads from a pipe, applying its tee policy.
+type policyReader struct {
+	p *Pipe
+}
+
+func (r policyReader) Read(b []byte) (int, error) {
+	return r.p.readPolicy(b)
+}
+
+type onlyReader struct {
+	io.Reader
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+// +build mips mipsle mips64 mips64le ppc ppc64 ppc64le sparc64
+
+package zerocopy
+
+// ficlone is FICLONE, _IOW(0x94, 9, int), and ficlonerange is FICLONERANGE,
+// _IOW(0x94, 13, struct file_clone_range), from linux/fs.h. On these
+// architectures, the direction bits of ioctl numbers are laid out
+// differently.
+const (
+	ficlone      = 0x80049409
+	ficlonerange = 0x8020940d
+)
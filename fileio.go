@@ -0,0 +1,88 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+// fileIOSem holds a chan struct{}, the semaphore for file I/O, or a nil
+// channel if there is no limit.
+var fileIOSem atomic.Value
+
+// SetMaxFileIO sets the maximum number of system calls which move data out
+// of regular files that package zerocopy runs concurrently. Calls in
+// excess of the limit wait for others to complete. If n is not positive,
+// there is no limit, which is the default.
+//
+// Waiting for sockets and pipes to become ready for I/O does not consume
+// operating system threads: goroutines are parked, and woken up by the
+// runtime network poller. System calls which read from regular files,
+// however, block on disk I/O whenever the data is not in the page cache,
+// and the runtime starts a new thread for every such blocked call. If
+// storage stalls under load, the number of threads can grow without
+// bound, and the runtime eventually crashes the program when it reaches
+// the limit set by runtime/debug.SetMaxThreads. SetMaxFileIO protects
+// against this.
+//
+// Calls never wait for a slot while they use a file descriptor, so they
+// do not delay a concurrent Close, and never hold a slot while they wait
+// for the destination to become ready for I/O. SetMaxFileIO should be
+// called before any file transfers are started.
+func SetMaxFileIO(n int) {
+	var sem chan struct{}
+	if n > 0 {
+		sem = make(chan struct{}, n)
+	}
+	fileIOSem.Store(sem)
+}
+
+// acquireFileIO acquires a file I/O slot, and returns a function which
+// releases it.
+func acquireFileIO() (release func()) {
+	sem, _ := fileIOSem.Load().(chan struct{})
+	if sem == nil {
+		return func() {}
+	}
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+// tryAcquireFileIO is like acquireFileIO, but does not wait for a slot.
+// It reports whether it acquired one.
+func tryAcquireFileIO() (release func(), ok bool) {
+	sem, _ := fileIOSem.Load().(chan struct{})
+	if sem == nil {
+		return func() {}, true
+	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, true
+	default:
+		return nil, false
+	}
+}
+
+// acquireFileIOIf is like acquireFileIO, but only acquires a slot if file
+// is true.
+func acquireFileIOIf(file bool) (release func()) {
+	if !file {
+		return func() {}
+	}
+	return acquireFileIO()
+}
+
+// isRegularFile reports whether v is an *os.File which refers to a regular
+// file.
+func isRegularFile(v interface{}) bool {
+	f, ok := v.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode().IsRegular()
+}
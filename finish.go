@@ -0,0 +1,65 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	devNullOnce sync.Once
+	devNull     io.Writer
+)
+
+// discard returns a writer which discards all data written to it. Where
+// possible, it is the null device, which data can be spliced to.
+func discard() io.Writer {
+	devNullOnce.Do(func() {
+		f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			devNull = nullWriter{}
+			return
+		}
+		devNull = f
+	})
+	return devNull
+}
+
+// nullWriter is like ioutil.Discard, but with no ReadFrom method, so that
+// copies to it use the pooled fallback buffers.
+type nullWriter struct{}
+
+func (nullWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+// Finish closes conn gracefully. If conn implements CloseWrite, as
+// *net.TCPConn and *net.UnixConn do, Finish calls it first, to signal the
+// end of the data to the peer. It then discards up to limit bytes of
+// remaining data from conn, splicing them to the null device where
+// possible, and finally closes conn.
+//
+// Closing a TCP connection with unread data in its receive buffer causes
+// the kernel to send a reset, which can make the peer lose data it has
+// not read yet, such as the last response of an HTTP server. Finish avoids
+// this, as long as the peer stops sending within the limit. Finish reads
+// until EOF, so callers should set a read deadline on conn beforehand, to
+// bound the time spent waiting for the peer.
+//
+// Finish returns the first error encountered.
+func Finish(conn io.ReadCloser, limit int64) error {
+	var err error
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		err = cw.CloseWrite()
+	}
+	if err == nil {
+		_, err = Transfer(discard(), &io.LimitedReader{R: conn, N: limit})
+	}
+	if cerr := conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
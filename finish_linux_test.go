@@ -0,0 +1,55 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+)
+
+func TestFinish(t *testing.T) {
+	client, server, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The client sends a request the server never reads, then waits
+	// for the response before closing its side.
+	request := strings.Repeat("x", 100000)
+	response := strings.Repeat("y", 100000)
+	go func() {
+		io.WriteString(client, request)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	errc := make(chan error, 1)
+	go func() {
+		if _, err := io.WriteString(server, response); err != nil {
+			errc <- err
+			return
+		}
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		errc <- zerocopy.Finish(server, 1<<20)
+	}()
+
+	got, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	if string(got) != response {
+		t.Fatalf("client got %d bytes, want %d", len(got), len(response))
+	}
+	client.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"io"
+	"strconv"
+)
+
+// RelayFrames relays length-prefixed messages from src to dst, until src
+// reaches EOF. For each message, RelayFrames reads a prefix of prefixLen
+// bytes from src, in userspace, and writes it to dst unchanged. It then
+// calls size with the prefix, to obtain the length of the payload which
+// follows, and transfers exactly that many bytes from src to dst, as if
+// by Transfer. A single pipe is used for all the messages.
+//
+// If size returns an error, RelayFrames stops and returns that error. If
+// size returns a negative length, RelayFrames stops and returns an error,
+// without writing the prefix. If src reaches EOF at a message boundary,
+// RelayFrames returns a nil error. If src reaches EOF in the middle of a
+// message, RelayFrames returns io.ErrUnexpectedEOF. In all cases,
+// RelayFrames returns the number of bytes written to dst, including the
+// prefixes.
+//
+// src must not be buffered in userspace. See the README for details.
+func RelayFrames(dst io.Writer, src io.Reader, prefixLen int, size func(prefix []byte) (int64, error)) (int64, error) {
+	// A nil pipe makes transferPipe get one from the pool if it needs
+	// one, which it does not if either side is a TLS connection.
+	var p *Pipe
+	if !isTLSConn(src) && !isTLSConn(dst) {
+		var err error
+		p, err = NewPipe()
+		if err != nil {
+			return 0, err
+		}
+		defer p.close()
+	}
+
+	var (
+		written int64
+		prefix  = make([]byte, prefixLen)
+		lr      = &io.LimitedReader{R: src}
+	)
+	for {
+		if _, err := io.ReadFull(src, prefix); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return written, err
+		}
+		n, err := size(prefix)
+		if err != nil {
+			return written, err
+		}
+		if n < 0 {
+			return written, errors.New("zerocopy: RelayFrames: negative payload size " + strconv.FormatInt(n, 10))
+		}
+		nw, err := dst.Write(prefix)
+		written += int64(nw)
+		if err != nil {
+			return written, err
+		}
+		lr.N = n
+		nt, err := transferPipe(p, dst, lr)
+		written += nt
+		if err != nil {
+			return written, err
+		}
+		if lr.N > 0 {
+			return written, io.ErrUnexpectedEOF
+		}
+	}
+}
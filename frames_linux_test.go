@@ -0,0 +1,89 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestRelayFrames(t *testing.T) {
+	var stream bytes.Buffer
+	for _, size := range []int{0, 1, 100, 4096, 1 << 20, 7} {
+		var prefix [4]byte
+		binary.BigEndian.PutUint32(prefix[:], uint32(size))
+		stream.Write(prefix[:])
+		stream.Write(bytes.Repeat([]byte{byte(size)}, size))
+	}
+	want := stream.Bytes()
+
+	t.Run("complete", func(t *testing.T) {
+		got, err := relayFramesTest(t, want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("got %d bytes, want %d, or content mismatch", len(got), len(want))
+		}
+	})
+	t.Run("truncated", func(t *testing.T) {
+		_, err := relayFramesTest(t, want[:len(want)-1])
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+		}
+	})
+	t.Run("negative", func(t *testing.T) {
+		var dst bytes.Buffer
+		size := func(prefix []byte) (int64, error) { return -1, nil }
+		n, err := zerocopy.RelayFrames(&dst, bytes.NewReader(want), 4, size)
+		if err == nil {
+			t.Fatal("RelayFrames accepted a negative payload size")
+		}
+		if n != 0 || dst.Len() != 0 {
+			t.Fatalf("wrote %d bytes (reported %d), want none", dst.Len(), n)
+		}
+	})
+}
+
+func relayFramesTest(t *testing.T, input []byte) ([]byte, error) {
+	t.Helper()
+	clientUp, serverUp, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverUp.Close()
+	clientDown, serverDown, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientDown.Close()
+
+	go func() {
+		clientUp.Write(input)
+		clientUp.Close()
+	}()
+	gotc := make(chan []byte, 1)
+	go func() {
+		got, _ := ioutil.ReadAll(clientDown)
+		gotc <- got
+	}()
+
+	size := func(prefix []byte) (int64, error) {
+		return int64(binary.BigEndian.Uint32(prefix)), nil
+	}
+	n, err := zerocopy.RelayFrames(serverDown, serverUp, 4, size)
+	serverDown.Close()
+	got := <-gotc
+	if n != int64(len(got)) {
+		t.Errorf("RelayFrames reported %d bytes, peer got %d", n, len(got))
+	}
+	return got, err
+}
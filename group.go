@@ -0,0 +1,127 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var errGroupClosed = errors.New("zerocopy: Group closed")
+
+// A Group tracks the pipes, transfers and other resources of a pipeline,
+// so that they can be released together. Servers which build a pipeline
+// per request can then clean up with a single deferred CloseAll, on every
+// path, including early returns on errors.
+//
+// The zero value is an empty Group, ready to use. A Group must not be
+// copied after first use.
+type Group struct {
+	wg sync.WaitGroup
+
+	mu      sync.Mutex
+	closers []io.Closer
+	closed  bool
+	err     error // first error from a goroutine started by Go
+}
+
+// NewPipe creates a new pipe, as NewPipe does, and adds it to the group.
+// After CloseAll, NewPipe fails.
+func (g *Group) NewPipe() (*Pipe, error) {
+	p, err := NewPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := g.add(p); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Add adds c to the group, so that CloseAll closes it. This is typically
+// used for the connections or files at the ends of a pipeline. If the
+// group is already closed, Add closes c immediately.
+func (g *Group) Add(c io.Closer) {
+	if err := g.add(c); err != nil {
+		c.Close()
+	}
+}
+
+func (g *Group) add(c io.Closer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return errGroupClosed
+	}
+	g.closers = append(g.closers, c)
+	return nil
+}
+
+// Go calls fn in a new goroutine, which Wait waits for. The first non-nil
+// error returned by such a function is returned by Wait.
+func (g *Group) Go(fn func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := fn(); err != nil {
+			g.mu.Lock()
+			if g.err == nil {
+				g.err = err
+			}
+			g.mu.Unlock()
+		}
+	}()
+}
+
+// Transfer starts Transfer(dst, src) in a new goroutine, as if by Go.
+func (g *Group) Transfer(dst io.Writer, src io.Reader) {
+	g.Go(func() error {
+		_, err := Transfer(dst, src)
+		return err
+	})
+}
+
+// CloseAll closes all the pipes and other resources in the group, in the
+// reverse order of their addition, which unblocks the transfers operating
+// on them. It does not wait for the goroutines started by Go. CloseAll
+// returns the first error encountered while closing. Calls after the first
+// do nothing, and return nil.
+//
+// Transfers which were started by Group.Transfer, between endpoints which
+// are not in the group, are not interrupted by CloseAll.
+func (g *Group) CloseAll() error {
+	g.mu.Lock()
+	closers := g.closers
+	g.closers = nil
+	g.closed = true
+	g.mu.Unlock()
+
+	var first error
+	for i := len(closers) - 1; i >= 0; i-- {
+		var err error
+		if p, ok := closers[i].(*Pipe); ok {
+			// Pipes may have been closed by their users already.
+			err = p.close()
+		} else {
+			err = closers[i].Close()
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Wait waits for all the goroutines started by Go to return, and returns
+// the first non-nil error returned by them.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
@@ -0,0 +1,435 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An HTTPHeader is the start line and the header of an HTTP/1.1 message.
+type HTTPHeader struct {
+	// StartLine is the request line or the status line, without the
+	// trailing CRLF.
+	StartLine string
+
+	// Header holds the header fields.
+	Header textproto.MIMEHeader
+
+	// order holds the keys of Header in the order in which they first
+	// appeared in the message.
+	order []string
+}
+
+// An HTTPForwarder forwards HTTP/1.1 messages from one connection to another.
+// Start lines and headers are parsed in userspace, and can be rewritten by
+// the caller, while message bodies are transferred as if by Transfer.
+// Header fields are forwarded in the order in which they were received,
+// followed by fields added by the caller, in lexical order.
+//
+// An HTTPForwarder reads from src through a BufferedReader, so src must not
+// be read by anything other than the HTTPForwarder.
+type HTTPForwarder struct {
+	dst io.Writer
+	br  *BufferedReader
+	tp  *textproto.Reader
+	buf bytes.Buffer
+
+	cork bool
+}
+
+// NewHTTPForwarder creates an HTTPForwarder which forwards messages from src
+// to dst. The HTTPForwarder must be closed after use.
+func NewHTTPForwarder(dst io.Writer, src io.Reader) *HTTPForwarder {
+	br := NewBufferedReader(src)
+	return &HTTPForwarder{
+		dst: dst,
+		br:  br,
+		tp:  textproto.NewReader(br.Reader),
+	}
+}
+
+// Close releases the resources associated with the HTTPForwarder. It does
+// not close the connections.
+func (f *HTTPForwarder) Close() error {
+	return f.br.Close()
+}
+
+// SetCork sets whether the HTTPForwarder corks the destination while
+// forwarding a message, so that the header, as rewritten, is not sent on
+// its own, but coalesces with the first segment of the body. The
+// destination is uncorked, flushing any pending data, once the message
+// has been forwarded.
+//
+// Corking is only supported for TCP destinations on Linux, and is ignored
+// otherwise.
+func (f *HTTPForwarder) SetCork(cork bool) {
+	f.cork = cork
+}
+
+// ForwardRequest forwards a request. It reads the request header, calls
+// rewrite on it, if rewrite is not nil, writes the header to the
+// destination, then forwards the request body.
+//
+// The framing of the body is determined from the header as read from the
+// source, before rewrite is called. If rewrite changes the framing (for
+// example, by removing a Transfer-Encoding field), the forwarded message is
+// malformed. Messages with ambiguous framing, such as conflicting
+// Content-Length values, are rejected with an error, and a Content-Length
+// field which accompanies Transfer-Encoding is removed. If rewrite returns
+// an error, ForwardRequest stops and returns that error, and nothing is
+// written to the destination.
+//
+// ForwardRequest returns io.EOF if the source is at EOF before the start of
+// a new request.
+func (f *HTTPForwarder) ForwardRequest(rewrite func(*HTTPHeader) error) error {
+	h, err := f.readHeader()
+	if err != nil {
+		return err
+	}
+	length, err := h.bodyLength(false)
+	if err != nil {
+		return err
+	}
+	return f.forward(h, length, rewrite)
+}
+
+// ForwardResponse forwards a response to a request with the specified
+// method. It is otherwise like ForwardRequest.
+//
+// If the response has no framing information, its body extends to the end
+// of the source connection, and is forwarded as such.
+func (f *HTTPForwarder) ForwardResponse(method string, rewrite func(*HTTPHeader) error) error {
+	h, err := f.readHeader()
+	if err != nil {
+		return err
+	}
+	length := int64(0)
+	if method != "HEAD" && h.responseHasBody() {
+		if length, err = h.bodyLength(true); err != nil {
+			return err
+		}
+	}
+	return f.forward(h, length, rewrite)
+}
+
+const (
+	bodyChunked  = -1
+	bodyUntilEOF = -2
+)
+
+func (f *HTTPForwarder) forward(h *HTTPHeader, length int64, rewrite func(*HTTPHeader) error) error {
+	if rewrite != nil {
+		if err := rewrite(h); err != nil {
+			return err
+		}
+	}
+	if f.cork {
+		setCork(f.dst, true)
+		defer setCork(f.dst, false)
+	}
+	if err := f.writeHeader(h); err != nil {
+		return err
+	}
+	switch length {
+	case bodyChunked:
+		return f.forwardChunked()
+	case bodyUntilEOF:
+		return f.forwardBody(-1)
+	default:
+		return f.forwardBody(length)
+	}
+}
+
+// readHeader reads the start line and the header of a message. The header
+// is parsed as by textproto.Reader.ReadMIMEHeader, except that the order
+// of the fields is recorded, so that writeHeader can preserve it.
+func (f *HTTPForwarder) readHeader() (*HTTPHeader, error) {
+	line, err := f.tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	h := &HTTPHeader{StartLine: line, Header: make(textproto.MIMEHeader)}
+	for {
+		kv, err := f.tp.ReadContinuedLine()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if kv == "" {
+			return h, nil
+		}
+		i := strings.IndexByte(kv, ':')
+		if i <= 0 || !isToken(kv[:i]) {
+			// Notably, whitespace between the field name and
+			// the colon is rejected, as RFC 7230, section 3.2.4
+			// requires.
+			return nil, errors.New("zerocopy: malformed header line " + strconv.Quote(kv))
+		}
+		key := textproto.CanonicalMIMEHeaderKey(kv[:i])
+		value := strings.Trim(kv[i+1:], " \t")
+		if _, ok := h.Header[key]; !ok {
+			h.order = append(h.order, key)
+		}
+		h.Header[key] = append(h.Header[key], value)
+	}
+}
+
+// writeHeader writes h to the destination. Fields are written in the
+// order in which they were read, followed by fields added by the caller,
+// in lexical order.
+func (f *HTTPForwarder) writeHeader(h *HTTPHeader) error {
+	f.buf.Reset()
+	f.buf.WriteString(h.StartLine)
+	f.buf.WriteString("\r\n")
+	seen := make(map[string]bool, len(h.order))
+	for _, k := range h.order {
+		seen[k] = true
+		f.writeField(k, h.Header[k])
+	}
+	var added []string
+	for k := range h.Header {
+		if !seen[k] {
+			added = append(added, k)
+		}
+	}
+	sort.Strings(added)
+	for _, k := range added {
+		f.writeField(k, h.Header[k])
+	}
+	f.buf.WriteString("\r\n")
+	_, err := f.dst.Write(f.buf.Bytes())
+	return err
+}
+
+func (f *HTTPForwarder) writeField(key string, values []string) {
+	for _, v := range values {
+		fmt.Fprintf(&f.buf, "%s: %s\r\n", key, v)
+	}
+}
+
+// forwardBody forwards n bytes of body, or everything up to EOF if n < 0.
+func (f *HTTPForwarder) forwardBody(n int64) error {
+	_, err := f.br.TransferTo(f.dst, n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// forwardChunked forwards a chunked body. Chunk lines are validated before
+// they are forwarded verbatim, so that a line which the destination might
+// parse differently never reaches it.
+func (f *HTTPForwarder) forwardChunked() error {
+	for {
+		line, err := f.readLine()
+		if err != nil {
+			return err
+		}
+		size, err := parseChunkSize(line)
+		if err != nil {
+			return err
+		}
+		if err := f.writeLine(line); err != nil {
+			return err
+		}
+		if size == 0 {
+			break
+		}
+		if err := f.forwardBody(size); err != nil {
+			return err
+		}
+		line, err = f.readLine()
+		if err != nil {
+			return err
+		}
+		if line != "" {
+			return errors.New("zerocopy: malformed chunked encoding")
+		}
+		if err := f.writeLine(line); err != nil {
+			return err
+		}
+	}
+	// Forward the trailer, up to and including the empty line.
+	for {
+		line, err := f.readLine()
+		if err != nil {
+			return err
+		}
+		if err := f.writeLine(line); err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+	}
+}
+
+// readLine reads a line from the source, and returns it without the CRLF.
+func (f *HTTPForwarder) readLine() (string, error) {
+	line, err := f.tp.ReadLine()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return line, nil
+}
+
+// writeLine forwards a line read by readLine to the destination.
+func (f *HTTPForwarder) writeLine(line string) error {
+	_, err := io.WriteString(f.dst, line+"\r\n")
+	return err
+}
+
+// parseChunkSize parses the chunk size at the start of line, which must be
+// 1*HEXDIG, as RFC 7230, section 4.1 requires, and may be followed by
+// chunk extensions. Signs and whitespace, which strconv.ParseInt and
+// some implementations accept, are rejected.
+func parseChunkSize(line string) (int64, error) {
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	if !isHexDigits(line) {
+		return 0, errors.New("zerocopy: malformed chunk size " + strconv.Quote(line))
+	}
+	size, err := strconv.ParseInt(line, 16, 64)
+	if err != nil {
+		return 0, errors.New("zerocopy: malformed chunk size " + strconv.Quote(line))
+	}
+	return size, nil
+}
+
+// bodyLength returns the length of the body described by h, or one of
+// bodyChunked or bodyUntilEOF.
+//
+// Framing which intermediaries could disagree about is rejected, as
+// described in RFC 7230, section 3.3.3. If both Transfer-Encoding and
+// Content-Length are present, Transfer-Encoding takes precedence, and
+// bodyLength removes Content-Length from h, as net/http does, so that it
+// is not forwarded.
+func (h *HTTPHeader) bodyLength(response bool) (int64, error) {
+	if te := headerTokens(h.Header["Transfer-Encoding"]); len(te) > 0 {
+		h.Header.Del("Content-Length")
+		chunked := 0
+		for _, coding := range te {
+			if coding == "chunked" {
+				chunked++
+			}
+		}
+		if te[len(te)-1] != "chunked" || chunked > 1 {
+			if response {
+				return bodyUntilEOF, nil
+			}
+			return 0, errors.New("zerocopy: unsupported Transfer-Encoding " + strings.Join(te, ", "))
+		}
+		return bodyChunked, nil
+	}
+	if cls := headerTokens(h.Header["Content-Length"]); len(cls) > 0 {
+		for _, cl := range cls[1:] {
+			if cl != cls[0] {
+				return 0, errors.New("zerocopy: conflicting Content-Length values")
+			}
+		}
+		if !isDigits(cls[0]) {
+			// Content-Length is 1*DIGIT. Signs, which
+			// strconv.ParseInt accepts, are rejected.
+			return 0, errors.New("zerocopy: malformed Content-Length " + cls[0])
+		}
+		n, err := strconv.ParseInt(cls[0], 10, 64)
+		if err != nil {
+			return 0, errors.New("zerocopy: malformed Content-Length " + cls[0])
+		}
+		return n, nil
+	}
+	if response {
+		return bodyUntilEOF, nil
+	}
+	return 0, nil
+}
+
+// headerTokens splits the comma-separated lists in values, from all the
+// field lines of a header field, into lower case tokens. Empty list
+// elements are skipped.
+func headerTokens(values []string) []string {
+	var tokens []string
+	for _, v := range values {
+		for _, tok := range strings.Split(v, ",") {
+			tok = strings.ToLower(strings.TrimSpace(tok))
+			if tok != "" {
+				tokens = append(tokens, tok)
+			}
+		}
+	}
+	return tokens
+}
+
+// isDigits reports whether s is a non-empty string of decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isHexDigits reports whether s is a non-empty string of hexadecimal
+// digits.
+func isHexDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isToken reports whether s is a token, as defined by RFC 7230, section
+// 3.2.6, such as a header field name.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *HTTPHeader) responseHasBody() bool {
+	fields := strings.SplitN(h.StartLine, " ", 3)
+	if len(fields) < 2 {
+		return true
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return true
+	}
+	return !(code >= 100 && code < 200 || code == 204 || code == 304)
+}
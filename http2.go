@@ -0,0 +1,103 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+const (
+	http2FrameHeaderLen = 9
+	http2FrameData      = 0x0
+	http2ClientPreface  = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+)
+
+// RelayHTTP2Frames relays HTTP/2 frames from src to dst, until src reaches
+// EOF. Frame headers are read in userspace. Payloads of DATA frames are
+// transferred as if by Transfer, while the payloads of all other frames,
+// which are usually small, are copied in userspace.
+//
+// If clientPreface is true, src is expected to start with the HTTP/2
+// client connection preface, which is forwarded as well. This is the case
+// for the client to server direction of a connection.
+//
+// RelayHTTP2Frames does not interpret frames in any way, so it is only
+// suitable for proxies which do not need to act on the contents of the
+// connection. It is experimental.
+func RelayHTTP2Frames(dst io.Writer, src io.Reader, clientPreface bool) (int64, error) {
+	var written int64
+	if clientPreface {
+		preface := make([]byte, len(http2ClientPreface))
+		if _, err := io.ReadFull(src, preface); err != nil {
+			return 0, err
+		}
+		if string(preface) != http2ClientPreface {
+			return 0, errors.New("zerocopy: bad HTTP/2 client preface")
+		}
+		n, err := dst.Write(preface)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	// As in RelayFrames, TLS connections cannot use the pipe.
+	var p *Pipe
+	if !isTLSConn(src) && !isTLSConn(dst) {
+		var err error
+		p, err = NewPipe()
+		if err != nil {
+			return written, err
+		}
+		defer p.close()
+	}
+
+	var (
+		hdr = make([]byte, http2FrameHeaderLen)
+		buf bytes.Buffer
+		lr  = &io.LimitedReader{R: src}
+	)
+	for {
+		if _, err := io.ReadFull(src, hdr); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return written, err
+		}
+		lr.N = int64(hdr[0])<<16 | int64(hdr[1])<<8 | int64(hdr[2])
+		if hdr[3] == http2FrameData {
+			n, err := dst.Write(hdr)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+			n64, err := transferPipe(p, dst, lr)
+			written += n64
+			if err != nil {
+				return written, err
+			}
+		} else {
+			// Write the header and the payload together.
+			buf.Reset()
+			buf.Write(hdr)
+			if _, err := buf.ReadFrom(lr); err != nil {
+				return written, err
+			}
+			if lr.N == 0 {
+				n, err := dst.Write(buf.Bytes())
+				written += int64(n)
+				if err != nil {
+					return written, err
+				}
+			}
+		}
+		if lr.N > 0 {
+			return written, io.ErrUnexpectedEOF
+		}
+	}
+}
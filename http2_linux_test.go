@@ -0,0 +1,78 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestRelayHTTP2Frames(t *testing.T) {
+	var input bytes.Buffer
+	input.WriteString("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	writeFrame := func(typ byte, stream uint32, payload []byte) {
+		n := len(payload)
+		input.Write([]byte{
+			byte(n >> 16), byte(n >> 8), byte(n),
+			typ, 0,
+			byte(stream >> 24), byte(stream >> 16), byte(stream >> 8), byte(stream),
+		})
+		input.Write(payload)
+	}
+	writeFrame(0x4, 0, nil)                              // SETTINGS
+	writeFrame(0x1, 1, []byte("headers"))                // HEADERS
+	writeFrame(0x0, 1, bytes.Repeat([]byte("d"), 16384)) // DATA
+	writeFrame(0x0, 1, nil)                              // empty DATA
+	writeFrame(0x6, 0, make([]byte, 8))                  // PING
+	want := input.Bytes()
+
+	clientUp, serverUp, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverUp.Close()
+	clientDown, serverDown, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientDown.Close()
+
+	go func() {
+		clientUp.Write(want)
+		clientUp.Close()
+	}()
+	gotc := make(chan []byte, 1)
+	go func() {
+		got, _ := ioutil.ReadAll(clientDown)
+		gotc <- got
+	}()
+
+	n, err := zerocopy.RelayHTTP2Frames(serverDown, serverUp, true)
+	serverDown.Close()
+	got := <-gotc
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(want)) || !bytes.Equal(got, want) {
+		t.Fatalf("relayed %d bytes, peer got %d, want %d", n, len(got), len(want))
+	}
+}
+
+func TestRelayHTTP2FramesTruncated(t *testing.T) {
+	frame := []byte{0, 0, 10, 0x1, 0, 0, 0, 0, 1, 'a', 'b'}
+	var dst bytes.Buffer
+	_, err := zerocopy.RelayHTTP2Frames(&dst, bytes.NewReader(frame), false)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if dst.Len() != 0 {
+		t.Fatalf("partial frame was forwarded: %q", dst.Bytes())
+	}
+}
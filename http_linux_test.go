@@ -0,0 +1,318 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestHTTPForwarder(t *testing.T) {
+	t.Run("ContentLength", testHTTPForwarderContentLength)
+	t.Run("Chunked", testHTTPForwarderChunked)
+	t.Run("ResponseUntilEOF", testHTTPForwarderResponseUntilEOF)
+	t.Run("HEAD", testHTTPForwarderHEAD)
+	t.Run("ContentLengthAndChunked", testHTTPForwarderContentLengthAndChunked)
+	t.Run("AmbiguousFraming", testHTTPForwarderAmbiguousFraming)
+	t.Run("MalformedChunkSize", testHTTPForwarderMalformedChunkSize)
+	t.Run("HeaderOrder", testHTTPForwarderHeaderOrder)
+	t.Run("MalformedHeader", testHTTPForwarderMalformedHeader)
+	t.Run("Cork", func(t *testing.T) {
+		t.Run("tcp", func(t *testing.T) { testHTTPForwarderCork(t, "tcp") })
+		t.Run("unix", func(t *testing.T) { testHTTPForwarderCork(t, "unix") })
+	})
+}
+
+func testHTTPForwarderContentLength(t *testing.T) {
+	body := strings.Repeat("0123456789", 100000)
+	input := "POST /upload HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Content-Length: 1000000\r\n" +
+		"\r\n" + body +
+		"GET /next HTTP/1.1\r\nHost: example.com\r\n\r\n"
+
+	rewrite := func(h *zerocopy.HTTPHeader) error {
+		h.Header.Set("X-Forwarded-For", "192.0.2.1")
+		return nil
+	}
+	out := forwardHTTPTest(t, "unix", input, func(f *zerocopy.HTTPForwarder) error {
+		if err := f.ForwardRequest(rewrite); err != nil {
+			return err
+		}
+		if err := f.ForwardRequest(nil); err != nil {
+			return err
+		}
+		if err := f.ForwardRequest(nil); err != io.EOF {
+			t.Errorf("got %v at end of input, want io.EOF", err)
+		}
+		return nil
+	})
+
+	br := bufio.NewReader(strings.NewReader(out))
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("X-Forwarded-For"); got != "192.0.2.1" {
+		t.Errorf("X-Forwarded-For = %q", got)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Fatalf("got %d bytes of body, want %d", len(got), len(body))
+	}
+	req, err = http.ReadRequest(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.Path != "/next" {
+		t.Fatalf("second request for %q, want /next", req.URL.Path)
+	}
+}
+
+func testHTTPForwarderChunked(t *testing.T) {
+	chunk := strings.Repeat("x", 70000)
+	input := "POST / HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5;ext=1\r\nhello\r\n" +
+		"11170\r\n" + chunk + "\r\n" +
+		"0\r\n" +
+		"Trailer-Field: value\r\n" +
+		"\r\n"
+
+	out := forwardHTTPTest(t, "unix", input, func(f *zerocopy.HTTPForwarder) error {
+		return f.ForwardRequest(nil)
+	})
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello"+chunk {
+		t.Fatalf("got %d bytes of body, want %d", len(got), 5+len(chunk))
+	}
+	if req.Trailer.Get("Trailer-Field") != "value" {
+		t.Errorf("trailer = %v", req.Trailer)
+	}
+}
+
+func testHTTPForwarderResponseUntilEOF(t *testing.T) {
+	body := strings.Repeat("0123456789", 50000)
+	input := "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n" + body
+	out := forwardHTTPTest(t, "unix", input, func(f *zerocopy.HTTPForwarder) error {
+		return f.ForwardResponse("GET", nil)
+	})
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(out)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Fatalf("got %d bytes of body, want %d", len(got), len(body))
+	}
+}
+
+func testHTTPForwarderHEAD(t *testing.T) {
+	input := "HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n" +
+		"HTTP/1.1 204 No Content\r\n\r\n"
+	out := forwardHTTPTest(t, "unix", input, func(f *zerocopy.HTTPForwarder) error {
+		if err := f.ForwardResponse("HEAD", nil); err != nil {
+			return err
+		}
+		return f.ForwardResponse("GET", nil)
+	})
+	if out != input {
+		t.Fatalf("got %q, want %q", out, input)
+	}
+}
+
+func testHTTPForwarderContentLengthAndChunked(t *testing.T) {
+	input := "POST / HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Content-Length: 4\r\n" +
+		"Transfer-Encoding: gzip\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n0\r\n\r\n"
+	out := forwardHTTPTest(t, "unix", input, func(f *zerocopy.HTTPForwarder) error {
+		return f.ForwardRequest(nil)
+	})
+	if strings.Contains(out, "Content-Length") {
+		t.Errorf("Content-Length forwarded along with Transfer-Encoding:\n%s", out)
+	}
+	if !strings.HasSuffix(out, "5\r\nhello\r\n0\r\n\r\n") {
+		t.Errorf("chunked body not forwarded whole:\n%s", out)
+	}
+}
+
+func testHTTPForwarderAmbiguousFraming(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"ChunkedSuffix", "Transfer-Encoding: xchunked\r\n"},
+		{"ChunkedNotLast", "Transfer-Encoding: chunked, identity\r\n"},
+		{"ChunkedNotLastLine", "Transfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\n"},
+		{"ChunkedTwice", "Transfer-Encoding: chunked, chunked\r\n"},
+		{"ContentLengthList", "Content-Length: 5, 6\r\n"},
+		{"ContentLengthLines", "Content-Length: 5\r\nContent-Length: 6\r\n"},
+		{"ContentLengthPlus", "Content-Length: +5\r\n"},
+		{"ContentLengthMinusZero", "Content-Length: -0\r\n"},
+		{"ContentLengthHex", "Content-Length: 0x5\r\n"},
+		{"ContentLengthInnerSpace", "Content-Length: 5 5\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "POST / HTTP/1.1\r\nHost: example.com\r\n" + tt.header +
+				"\r\n5\r\nhello\r\n0\r\n\r\n"
+			out := forwardHTTPTest(t, "unix", input, func(f *zerocopy.HTTPForwarder) error {
+				if err := f.ForwardRequest(nil); err == nil {
+					t.Error("ForwardRequest succeeded")
+				}
+				return nil
+			})
+			if out != "" {
+				t.Errorf("forwarded %q", out)
+			}
+		})
+	}
+}
+
+func testHTTPForwarderMalformedChunkSize(t *testing.T) {
+	sizes := []string{"+5", "-5", " 5", "5 ", "0x5", "5 ;ext=1", ""}
+	for _, size := range sizes {
+		t.Run(strconv.Quote(size), func(t *testing.T) {
+			header := "POST / HTTP/1.1\r\nHost: example.com\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n"
+			input := header + size + "\r\nhello\r\n0\r\n\r\n"
+			out := forwardHTTPTest(t, "unix", input, func(f *zerocopy.HTTPForwarder) error {
+				if err := f.ForwardRequest(nil); err == nil {
+					t.Error("ForwardRequest succeeded")
+				}
+				return nil
+			})
+			// The header is forwarded, but the chunk line must
+			// not be.
+			if out != header {
+				t.Errorf("forwarded %q, want %q", out, header)
+			}
+		})
+	}
+}
+
+func testHTTPForwarderHeaderOrder(t *testing.T) {
+	input := "GET / HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"X-B: 1\r\n" +
+		"Accept: */*\r\n" +
+		"X-A: 2\r\n" +
+		"X-B: 3\r\n" +
+		"\r\n"
+	out := forwardHTTPTest(t, "unix", input, func(f *zerocopy.HTTPForwarder) error {
+		return f.ForwardRequest(func(h *zerocopy.HTTPHeader) error {
+			h.Header.Del("Accept")
+			h.Header.Set("X-Forwarded-For", "192.0.2.1")
+			h.Header.Set("Via", "1.1 zerocopy")
+			return nil
+		})
+	})
+	want := "GET / HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"X-B: 1\r\n" +
+		"X-B: 3\r\n" +
+		"X-A: 2\r\n" +
+		"Via: 1.1 zerocopy\r\n" +
+		"X-Forwarded-For: 192.0.2.1\r\n" +
+		"\r\n"
+	if out != want {
+		t.Errorf("got\n%q\nwant\n%q", out, want)
+	}
+}
+
+func testHTTPForwarderMalformedHeader(t *testing.T) {
+	lines := []string{"Content-Length : 5", "Content Length: 5", ": 5", "Content-Length"}
+	for _, line := range lines {
+		t.Run(strconv.Quote(line), func(t *testing.T) {
+			input := "POST / HTTP/1.1\r\n" + line + "\r\n\r\nhello"
+			out := forwardHTTPTest(t, "unix", input, func(f *zerocopy.HTTPForwarder) error {
+				if err := f.ForwardRequest(nil); err == nil {
+					t.Error("ForwardRequest succeeded")
+				}
+				return nil
+			})
+			if out != "" {
+				t.Errorf("forwarded %q", out)
+			}
+		})
+	}
+}
+
+func forwardHTTPTest(t *testing.T, downNet, input string, forward func(*zerocopy.HTTPForwarder) error) string {
+	t.Helper()
+	clientUp, serverUp, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverUp.Close()
+	clientDown, serverDown, err := transferTestSocketPair(downNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientDown.Close()
+
+	go func() {
+		io.WriteString(clientUp, input)
+		clientUp.Close()
+	}()
+	outc := make(chan []byte, 1)
+	go func() {
+		out, _ := ioutil.ReadAll(clientDown)
+		outc <- out
+	}()
+
+	f := zerocopy.NewHTTPForwarder(serverDown, serverUp)
+	defer f.Close()
+	err = forward(f)
+	serverDown.Close()
+	out := <-outc
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func testHTTPForwarderCork(t *testing.T, downNet string) {
+	body := strings.Repeat("x", 10)
+	input := "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n" + body
+	out := forwardHTTPTest(t, downNet, input, func(f *zerocopy.HTTPForwarder) error {
+		f.SetCork(true)
+		return f.ForwardResponse("GET", func(h *zerocopy.HTTPHeader) error {
+			h.Header.Set("Server", "zerocopy")
+			return nil
+		})
+	})
+	want := "HTTP/1.1 200 OK\r\nContent-Length: 10\r\nServer: zerocopy\r\n\r\n" + body
+	if out != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+}
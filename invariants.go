@@ -0,0 +1,23 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !zerocopydebug
+
+package zerocopy
+
+import "syscall"
+
+// fdscope tracks the file descriptor references held by a single run of
+// the algorithm described at the top of zerocopy_linux.go. In regular
+// builds, it does nothing. See invariants_debug.go.
+type fdscope struct{}
+
+func (s *fdscope) read(rc syscall.RawConn, fn func(fd uintptr) bool) error {
+	return rc.Read(fn)
+}
+
+func (s *fdscope) write(rc syscall.RawConn, fn func(fd uintptr) bool) error {
+	return rc.Write(fn)
+}
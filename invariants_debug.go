@@ -0,0 +1,44 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build zerocopydebug
+
+package zerocopy
+
+import "syscall"
+
+// fdscope tracks the file descriptor references held by a single run of
+// the algorithm described at the top of zerocopy_linux.go, and panics if
+// the algorithm is about to wait on a file descriptor while holding a
+// reference to another one.
+//
+// A syscall.RawConn holds a reference to its file descriptor for as long
+// as the callback runs, and waits for readiness (still holding the
+// reference) if the callback returns false. Therefore, a callback which
+// returns false while nested in another callback is a violation.
+type fdscope struct {
+	held int
+}
+
+func (s *fdscope) read(rc syscall.RawConn, fn func(fd uintptr) bool) error {
+	return rc.Read(s.wrap("read", fn))
+}
+
+func (s *fdscope) write(rc syscall.RawConn, fn func(fd uintptr) bool) error {
+	return rc.Write(s.wrap("write", fn))
+}
+
+func (s *fdscope) wrap(op string, fn func(fd uintptr) bool) func(fd uintptr) bool {
+	return func(fd uintptr) bool {
+		s.held++
+		done := fn(fd)
+		if !done && s.held > 1 {
+			panic("zerocopy: invariant violation: wait for " + op +
+				" while holding a reference to another file descriptor")
+		}
+		s.held--
+		return done
+	}
+}
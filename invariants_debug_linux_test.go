@@ -0,0 +1,70 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build zerocopydebug
+
+package zerocopy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFDScopeNestedWait(t *testing.T) {
+	p, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	defer func() {
+		v := recover()
+		if v == nil {
+			t.Fatal("nested wait did not panic")
+		}
+		if s, ok := v.(string); !ok || !strings.Contains(s, "invariant violation") {
+			panic(v)
+		}
+	}()
+
+	// The pipe is empty, so waiting for it to become readable while
+	// holding a reference to the write side is exactly the bug the
+	// algorithm must avoid.
+	var s fdscope
+	s.write(p.wrc, func(uintptr) bool {
+		s.read(p.rrc, func(uintptr) bool {
+			return false
+		})
+		return true
+	})
+}
+
+func TestFDScopeOuterWait(t *testing.T) {
+	p, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var (
+		s     fdscope
+		calls int
+	)
+	err = s.write(p.wrc, func(uintptr) bool {
+		calls++
+		s.read(p.rrc, func(uintptr) bool {
+			return true
+		})
+		// The pipe is writable, so returning false once waits for
+		// write readiness, which is immediate.
+		return calls > 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("got %d calls, want 2", calls)
+	}
+}
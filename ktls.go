@@ -0,0 +1,27 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import "syscall"
+
+// KernelTLS reports whether conn is a socket on which kernel TLS (kTLS) is
+// in effect for sending (tx) and for receiving (rx).
+//
+// Package crypto/tls implements TLS in userspace, and a *tls.Conn hides
+// the file descriptor of the underlying connection, so Transfer always
+// copies data to and from it through a userspace buffer. Programs which
+// perform the TLS handshake, then hand the session keys to the kernel,
+// can pass the underlying *net.TCPConn to Transfer, SendFile or
+// ServeFileRanges instead: the kernel encrypts data as it sends it, so
+// plaintext can be spliced into the socket, and, if rx is set, decrypted
+// data can be spliced out of it. KernelTLS lets such programs check that
+// the handover took place, since writing plaintext to a socket without
+// kernel TLS sends it in the clear.
+//
+// On platforms other than Linux, KernelTLS always returns false, false.
+func KernelTLS(conn syscall.Conn) (tx, rx bool) {
+	return kernelTLS(conn)
+}
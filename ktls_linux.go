@@ -0,0 +1,40 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Socket options for kernel TLS, from include/uapi/linux/tls.h.
+const (
+	tlsTX = 1
+	tlsRX = 2
+)
+
+func kernelTLS(conn syscall.Conn) (tx, rx bool) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+	rc.Control(func(fd uintptr) {
+		tx = tlsConfigured(int(fd), tlsTX)
+		rx = tlsConfigured(int(fd), tlsRX)
+	})
+	return tx, rx
+}
+
+// tlsConfigured reports whether kernel TLS keys are installed on fd for
+// the specified direction. The kernel reports the header of the crypto
+// info, which is four bytes long, if they are, and fails with EBUSY
+// otherwise. Sockets without the TLS upper layer protocol fail with
+// ENOPROTOOPT, and other file descriptors with ENOTSOCK.
+func tlsConfigured(fd int, dir int) bool {
+	_, err := unix.GetsockoptInt(fd, unix.SOL_TLS, dir)
+	return err == nil
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"unsafe"
+
+	"acln.ro/zerocopy"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestKernelTLS(t *testing.T) {
+	client, server, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+
+	if tx, rx := zerocopy.KernelTLS(client.(*net.TCPConn)); tx || rx {
+		t.Fatalf("plain TCP socket: got tx = %t, rx = %t", tx, rx)
+	}
+
+	// Install the same keys for sending on client and receiving on
+	// server, so that server reads the plaintext which client sends.
+	if err := enableKernelTLS(client, 1); err != nil {
+		t.Skipf("kernel TLS not available: %v", err)
+	}
+	if err := enableKernelTLS(server, 2); err != nil {
+		t.Skipf("kernel TLS receive not available: %v", err)
+	}
+	if tx, rx := zerocopy.KernelTLS(client.(*net.TCPConn)); !tx || rx {
+		t.Errorf("client: got tx = %t, rx = %t, want true, false", tx, rx)
+	}
+	if tx, rx := zerocopy.KernelTLS(server.(*net.TCPConn)); tx || !rx {
+		t.Errorf("server: got tx = %t, rx = %t, want false, true", tx, rx)
+	}
+
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	src := newParitySource(t, content)
+	defer src.Close()
+	received := make(chan []byte)
+	go func() {
+		got := make([]byte, len(content))
+		n, _ := io.ReadFull(server, got)
+		received <- got[:n]
+	}()
+	if _, err := zerocopy.Transfer(client, src); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; !bytes.Equal(got, content) {
+		t.Errorf("received %d bytes, which do not match", len(got))
+	}
+}
+
+// tlsCryptoInfoAESGCM128 is struct tls12_crypto_info_aes_gcm_128, from
+// include/uapi/linux/tls.h.
+type tlsCryptoInfoAESGCM128 struct {
+	version    uint16
+	cipherType uint16
+	iv         [8]byte
+	key        [16]byte
+	salt       [4]byte
+	recSeq     [8]byte
+}
+
+// enableKernelTLS enables kernel TLS on conn in the specified direction
+// (1 for sending, 2 for receiving), with fixed keys.
+func enableKernelTLS(conn net.Conn, dir int) error {
+	rc, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptString(int(fd), unix.SOL_TCP, unix.TCP_ULP, "tls")
+		if serr != nil && serr != unix.EEXIST {
+			return
+		}
+		info := tlsCryptoInfoAESGCM128{
+			version:    0x0303, // TLS 1.2
+			cipherType: 51,     // AES-GCM-128
+			key:        [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		}
+		b := (*[unsafe.Sizeof(info)]byte)(unsafe.Pointer(&info))
+		serr = unix.SetsockoptString(int(fd), unix.SOL_TLS, dir, string(b[:]))
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyMessages copies from src, a socket of type typ, which preserves
+// message boundaries, to dst, with one call to dst.Write per message.
+// Unlike a copy through a buffer of fixed size, copyMessages sizes its
+// buffer to each message, so messages larger than the buffer are not
+// truncated. If buf is not nil, copyMessages starts out with it.
+//
+// If src is an *io.LimitedReader, copyMessages honors the limit, and
+// updates src.N. Bytes of a message beyond the limit are discarded, as
+// they would be by a read through the *io.LimitedReader.
+//
+// Sequenced-packet sockets report EOF by an empty read. Datagram sockets
+// have no EOF, so empty datagrams are skipped, and copyMessages runs until
+// an error occurs.
+func copyMessages(dst io.Writer, src io.Reader, typ int, buf []byte) (written int64, err error) {
+	var (
+		rd          = src
+		limit int64 = 1<<63 - 1
+	)
+	lr, ok := src.(*io.LimitedReader)
+	if ok {
+		rd = lr.R
+		limit = lr.N
+		defer func() {
+			lr.N = limit
+		}()
+	}
+	sc, ok := rd.(syscall.Conn)
+	if !ok {
+		return copyFallbackBuffer(dst, src, buf)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return copyFallbackBuffer(dst, src, buf)
+	}
+	count(&metrics.fallbacks, 1)
+	defer func() { count(&metrics.bytesGeneric, written) }()
+	if buf == nil {
+		bp := getFallbackBuffer()
+		defer fallbackPool.Put(bp)
+		buf = *bp
+	}
+	for limit > 0 {
+		var (
+			n     int
+			operr error
+		)
+		err := rc.Read(func(fd uintptr) bool {
+			// Learn the size of the next message first.
+			n, _, operr = unix.Recvfrom(int(fd), nil, unix.MSG_PEEK|unix.MSG_TRUNC)
+			if operr == unix.EAGAIN {
+				return false
+			}
+			if operr != nil {
+				operr = os.NewSyscallError("recvfrom", operr)
+				return true
+			}
+			if n > len(buf) {
+				buf = make([]byte, n)
+			}
+			n, operr = unix.Read(int(fd), buf[:n])
+			if operr != nil {
+				operr = os.NewSyscallError("read", operr)
+			}
+			return true
+		})
+		if err != nil {
+			return written, err
+		}
+		if operr != nil {
+			return written, operr
+		}
+		if n == 0 {
+			if typ == unix.SOCK_SEQPACKET {
+				return written, nil
+			}
+			continue
+		}
+		if int64(n) > limit {
+			n = int(limit)
+		}
+		nw, err := dst.Write(buf[:n])
+		written += int64(nw)
+		limit -= int64(n)
+		if err != nil {
+			return written, err
+		}
+		if nw != n {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import "sync/atomic"
+
+// Metrics holds aggregate counters for the package. See ReadMetrics.
+type Metrics struct {
+	// TransfersStarted and TransfersCompleted count the calls to
+	// Transfer and its variants, such as TransferN, Pipe.Transfer,
+	// CopyBuffer and TransferAccounted, which have started, and which
+	// have returned, successfully or not. Their difference is the number
+	// of transfers in progress.
+	TransfersStarted, TransfersCompleted int64
+
+	// BytesSplice, BytesCopyFileRange and BytesGeneric count the bytes
+	// moved by each strategy, by any function of the package. Bytes
+	// which the standard library moves on behalf of Transfer, such as
+	// between TCP connections, are counted as BytesSplice.
+	BytesSplice, BytesCopyFileRange, BytesGeneric int64
+
+	// Fallbacks counts the copies made through userspace, because no
+	// faster mechanism applied to the endpoints.
+	Fallbacks int64
+
+	// ActivePipes is the number of pipes taken from the internal pool
+	// which are in use, and have not been returned.
+	ActivePipes int64
+
+	// IdlePipes is the number of idle pipes in the pool, and
+	// PipePoolSize is its capacity. See SetPipePoolSize.
+	IdlePipes, PipePoolSize int
+}
+
+// metrics holds the counters behind Metrics. All fields are accessed
+// atomically.
+var metrics struct {
+	transfersStarted, transfersCompleted          int64
+	bytesSplice, bytesCopyFileRange, bytesGeneric int64
+	fallbacks                                     int64
+	activePipes                                   int64
+}
+
+// ReadMetrics returns a snapshot of the aggregate counters for the
+// package. The counters are always maintained, and cost nothing to read,
+// so they can be exported by any means. See packages
+// acln.ro/zerocopy/expvarmetrics and acln.ro/zerocopy/metrics. As with
+// Pipe.Stats, they are updated independently of each other.
+func ReadMetrics() Metrics {
+	pool, _ := pipePool.Load().(chan idlePipe)
+	return Metrics{
+		TransfersStarted:   atomic.LoadInt64(&metrics.transfersStarted),
+		TransfersCompleted: atomic.LoadInt64(&metrics.transfersCompleted),
+		BytesSplice:        atomic.LoadInt64(&metrics.bytesSplice),
+		BytesCopyFileRange: atomic.LoadInt64(&metrics.bytesCopyFileRange),
+		BytesGeneric:       atomic.LoadInt64(&metrics.bytesGeneric),
+		Fallbacks:          atomic.LoadInt64(&metrics.fallbacks),
+		ActivePipes:        atomic.LoadInt64(&metrics.activePipes),
+		IdlePipes:          len(pool),
+		PipePoolSize:       cap(pool),
+	}
+}
+
+// startTransfer records the start of a transfer. It returns a function
+// which records its completion.
+func startTransfer() (done func()) {
+	atomic.AddInt64(&metrics.transfersStarted, 1)
+	return endTransfer
+}
+
+func endTransfer() {
+	atomic.AddInt64(&metrics.transfersCompleted, 1)
+}
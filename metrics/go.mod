@@ -0,0 +1,8 @@
+module acln.ro/zerocopy/metrics
+
+go 1.12
+
+require (
+	acln.ro/zerocopy v0.0.0-20261015113501-05bd998a7d3f
+	github.com/prometheus/client_golang v1.0.0
+)
@@ -0,0 +1,133 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metrics exports the aggregate counters of package zerocopy to
+// Prometheus.
+//
+// Register a Collector to find out whether the fast path is hit in
+// production:
+//
+// 	prometheus.MustRegister(metrics.NewCollector())
+//
+// The share of bytes which were copied through userspace is exported
+// directly, as zerocopy_generic_bytes_ratio. Throughput is best computed
+// by the query, for example as rate(zerocopy_bytes_total[1m]), by
+// mechanism.
+package metrics
+
+import (
+	"acln.ro/zerocopy"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A Collector is a prometheus.Collector for the counters returned by
+// zerocopy.ReadMetrics. Since the counters are global, a program should
+// register at most one Collector with each registry.
+type Collector struct {
+	transfersStarted   *prometheus.Desc
+	transfersCompleted *prometheus.Desc
+	transfersActive    *prometheus.Desc
+	bytes              *prometheus.Desc
+	genericRatio       *prometheus.Desc
+	fallbacks          *prometheus.Desc
+	pipesActive        *prometheus.Desc
+	pipesIdle          *prometheus.Desc
+	pipePoolSize       *prometheus.Desc
+}
+
+// NewCollector returns a new Collector.
+func NewCollector() *Collector {
+	return &Collector{
+		transfersStarted: prometheus.NewDesc(
+			"zerocopy_transfers_started_total",
+			"Number of transfers started.",
+			nil, nil,
+		),
+		transfersCompleted: prometheus.NewDesc(
+			"zerocopy_transfers_completed_total",
+			"Number of transfers which returned, successfully or not.",
+			nil, nil,
+		),
+		transfersActive: prometheus.NewDesc(
+			"zerocopy_transfers_active",
+			"Number of transfers in progress.",
+			nil, nil,
+		),
+		bytes: prometheus.NewDesc(
+			"zerocopy_bytes_total",
+			"Number of bytes moved, by mechanism.",
+			[]string{"mechanism"}, nil,
+		),
+		genericRatio: prometheus.NewDesc(
+			"zerocopy_generic_bytes_ratio",
+			"Fraction of the bytes moved which were copied through userspace.",
+			nil, nil,
+		),
+		fallbacks: prometheus.NewDesc(
+			"zerocopy_fallbacks_total",
+			"Number of copies made through userspace.",
+			nil, nil,
+		),
+		pipesActive: prometheus.NewDesc(
+			"zerocopy_pipes_active",
+			"Number of pipes taken from the pool and in use.",
+			nil, nil,
+		),
+		pipesIdle: prometheus.NewDesc(
+			"zerocopy_pipes_idle",
+			"Number of idle pipes in the pool.",
+			nil, nil,
+		),
+		pipePoolSize: prometheus.NewDesc(
+			"zerocopy_pipe_pool_size",
+			"Maximum number of idle pipes the pool keeps.",
+			nil, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.transfersStarted
+	ch <- c.transfersCompleted
+	ch <- c.transfersActive
+	ch <- c.bytes
+	ch <- c.genericRatio
+	ch <- c.fallbacks
+	ch <- c.pipesActive
+	ch <- c.pipesIdle
+	ch <- c.pipePoolSize
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	m := zerocopy.ReadMetrics()
+
+	counter := func(d *prometheus.Desc, v int64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+
+	counter(c.transfersStarted, m.TransfersStarted)
+	counter(c.transfersCompleted, m.TransfersCompleted)
+	gauge(c.transfersActive, float64(m.TransfersStarted-m.TransfersCompleted))
+
+	counter(c.bytes, m.BytesSplice, zerocopy.StrategySplice.String())
+	counter(c.bytes, m.BytesCopyFileRange, zerocopy.StrategyCopyFileRange.String())
+	counter(c.bytes, m.BytesGeneric, zerocopy.StrategyGeneric.String())
+	ratio := 0.0
+	if total := m.BytesSplice + m.BytesCopyFileRange + m.BytesGeneric; total > 0 {
+		ratio = float64(m.BytesGeneric) / float64(total)
+	}
+	gauge(c.genericRatio, ratio)
+	counter(c.fallbacks, m.Fallbacks)
+
+	gauge(c.pipesActive, float64(m.ActivePipes))
+	gauge(c.pipesIdle, float64(m.IdlePipes))
+	gauge(c.pipePoolSize, float64(m.PipePoolSize))
+}
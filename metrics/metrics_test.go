@@ -0,0 +1,75 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"acln.ro/zerocopy"
+	"acln.ro/zerocopy/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	content := []byte("hello world")
+	var buf bytes.Buffer
+	if _, err := zerocopy.Transfer(&buf, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(metrics.NewCollector()); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			name := mf.GetName()
+			for _, lp := range m.GetLabel() {
+				name += "/" + lp.GetValue()
+			}
+			switch {
+			case m.GetCounter() != nil:
+				values[name] = m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				values[name] = m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	for _, name := range []string{
+		"zerocopy_transfers_started_total",
+		"zerocopy_transfers_completed_total",
+		"zerocopy_transfers_active",
+		"zerocopy_bytes_total/splice",
+		"zerocopy_bytes_total/copy_file_range",
+		"zerocopy_bytes_total/generic",
+		"zerocopy_generic_bytes_ratio",
+		"zerocopy_fallbacks_total",
+		"zerocopy_pipes_active",
+		"zerocopy_pipes_idle",
+		"zerocopy_pipe_pool_size",
+	} {
+		if _, ok := values[name]; !ok {
+			t.Errorf("%s not collected", name)
+		}
+	}
+	if got := values["zerocopy_transfers_started_total"]; got < 1 {
+		t.Errorf("started %v transfers, want at least 1", got)
+	}
+	if got := values["zerocopy_bytes_total/generic"]; got < float64(len(content)) {
+		t.Errorf("copied %v bytes, want at least %d", got, len(content))
+	}
+	if got := values["zerocopy_generic_bytes_ratio"]; got <= 0 || got > 1 {
+		t.Errorf("generic ratio is %v, want in (0, 1]", got)
+	}
+}
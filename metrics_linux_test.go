@@ -0,0 +1,64 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestReadMetrics(t *testing.T) {
+	t.Run("Splice", func(t *testing.T) {
+		content := make([]byte, 1<<20)
+		rand.Read(content)
+		src := newParitySource(t, content)
+		defer src.Close()
+		dst, received := newParitySink(t)
+
+		before := zerocopy.ReadMetrics()
+		if _, err := zerocopy.Transfer(dst, src); err != nil {
+			t.Fatal(err)
+		}
+		after := zerocopy.ReadMetrics()
+		dst.Close()
+		if got := received(); !bytes.Equal(got, content) {
+			t.Fatalf("received %d bytes, which do not match", len(got))
+		}
+
+		if got := after.TransfersStarted - before.TransfersStarted; got != 1 {
+			t.Errorf("started %d transfers, want 1", got)
+		}
+		if got := after.TransfersCompleted - before.TransfersCompleted; got != 1 {
+			t.Errorf("completed %d transfers, want 1", got)
+		}
+		if got := after.BytesSplice - before.BytesSplice; got < int64(len(content)) {
+			t.Errorf("spliced %d bytes, want at least %d", got, len(content))
+		}
+		if after.ActivePipes != before.ActivePipes {
+			t.Errorf("%d active pipes after Transfer, want %d", after.ActivePipes, before.ActivePipes)
+		}
+	})
+	t.Run("Generic", func(t *testing.T) {
+		content := []byte("hello world")
+		var buf bytes.Buffer
+
+		before := zerocopy.ReadMetrics()
+		if _, err := zerocopy.Transfer(&buf, bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		after := zerocopy.ReadMetrics()
+
+		if got := after.Fallbacks - before.Fallbacks; got != 1 {
+			t.Errorf("%d fallbacks, want 1", got)
+		}
+		if got := after.BytesGeneric - before.BytesGeneric; got != int64(len(content)) {
+			t.Errorf("copied %d bytes, want %d", got, len(content))
+		}
+	})
+}
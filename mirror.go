@@ -0,0 +1,149 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultShadowTimeout is the default value of MirrorOptions.ShadowTimeout.
+const defaultShadowTimeout = time.Second
+
+// MirrorOptions configures Mirror. The zero value is the default
+// configuration.
+type MirrorOptions struct {
+	// ShadowTimeout is how long the shadow may go without accepting
+	// data before mirroring to it stops. While the shadow is not
+	// accepting data, and the pipe to it is full, the primary waits.
+	// If ShadowTimeout is zero, a default of one second is used.
+	// ShadowTimeout is only enforced on Linux.
+	ShadowTimeout time.Duration
+
+	// ShadowFailed, if not nil, is called once if mirroring to the
+	// shadow stops before the end of the stream, with the error which
+	// caused it to stop.
+	ShadowFailed func(err error)
+}
+
+// Mirror copies src to primary, as Transfer does, and mirrors the data to
+// shadow, using tee(2) where possible, such that both receive the same
+// bytes, in the same order. Data read from shadow, such as the responses
+// of a shadow backend, is discarded, by way of splicing it to os.DevNull.
+// Mirror is meant for testing new versions of a service with production
+// traffic, without affecting the production path.
+//
+// Failures of the shadow do not affect the primary: if writing to the
+// shadow fails, or if the shadow stops accepting data for longer than
+// opts.ShadowTimeout, mirroring stops, and the primary carries on alone.
+// If opts is nil, the default options are used.
+//
+// Mirror returns the number of bytes written to primary, and the first
+// error encountered while reading from src or writing to primary. Mirror
+// does not wait for the shadow to finish, and does not close it, nor src.
+// Closing the shadow after Mirror returns stops the goroutines which serve
+// it. If writing to primary fails, Mirror returns without waiting for the
+// pending read from src, if any, which fails once src is closed.
+func Mirror(primary io.Writer, shadow io.ReadWriter, src io.Reader, opts *MirrorOptions) (int64, error) {
+	if opts == nil {
+		opts = new(MirrorOptions)
+	}
+	timeout := opts.ShadowTimeout
+	if timeout <= 0 {
+		timeout = defaultShadowTimeout
+	}
+	p, err := NewPipe()
+	if err != nil {
+		return 0, err
+	}
+	defer p.close()
+	sp, err := NewPipe()
+	if err != nil {
+		return 0, err
+	}
+
+	// If the shadow fails, sp is closed, so that the next tee(2) to it
+	// fails, and the tee is detached. The first error is the one which
+	// is reported.
+	var shadowOnce sync.Once
+	shadowFailed := func(err error) {
+		shadowOnce.Do(func() {
+			sp.close()
+			if opts.ShadowFailed != nil {
+				opts.ShadowFailed(err)
+			}
+		})
+	}
+
+	p.Tee(sp)
+	p.SetTeeCloseWrite(true)
+	p.SetTeePolicy(TeeDetach, shadowFailed)
+	sp.SetWriteDeadline(time.Now().Add(timeout))
+
+	// Serve the shadow: write mirrored data to it, and discard what
+	// it sends back.
+	go func() {
+		_, err := sp.WriteTo(shadow)
+		if err != nil {
+			shadowFailed(err)
+			return
+		}
+		sp.close()
+	}()
+	go discardShadow(shadow)
+
+	// Extend the deadline for the shadow as long as it makes progress,
+	// as observed through the amount of data buffered for it.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(timeout / 4)
+		defer ticker.Stop()
+		last := -1
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			n, err := sp.buffered()
+			if err != nil || n == 0 || n != last {
+				sp.SetWriteDeadline(time.Now().Add(timeout))
+			}
+			last = n
+		}
+	}()
+
+	readc := make(chan error, 1)
+	go func() {
+		_, err := p.ReadFrom(src)
+		p.closeWrite()
+		readc <- err
+	}()
+
+	var written int64
+	for {
+		n, err := p.WriteTo(primary)
+		written += n
+		if err == ErrTeeTimeout {
+			// The shadow fell behind. Detach it, and carry on.
+			p.teeFailed(err)
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+		break
+	}
+	return written, <-readc
+}
+
+// discardShadow reads from shadow until EOF or an error, and discards the
+// data.
+func discardShadow(shadow io.Reader) {
+	Transfer(discard(), shadow)
+}
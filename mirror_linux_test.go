@@ -0,0 +1,140 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+)
+
+func TestMirror(t *testing.T) {
+	content := make([]byte, 4<<20)
+	rand.Read(content)
+
+	t.Run("Mirrored", func(t *testing.T) {
+		src := newParitySource(t, content)
+		defer src.Close()
+		primary, received := newParitySink(t)
+		client, shadow, err := transferTestSocketPair("unix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer shadow.Close()
+		mirrorc := make(chan []byte)
+		go func() {
+			// The responses of the shadow are discarded.
+			go client.Write(make([]byte, 1<<20))
+			b := make([]byte, len(content))
+			n, _ := io.ReadFull(client, b)
+			client.Close()
+			mirrorc <- b[:n]
+		}()
+
+		failed := false
+		opts := &zerocopy.MirrorOptions{
+			ShadowFailed: func(error) { failed = true },
+		}
+		n, err := zerocopy.Mirror(primary, shadow, src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(content)) {
+			t.Errorf("wrote %d bytes to the primary, want %d", n, len(content))
+		}
+		if got := received(); !bytes.Equal(got, content) {
+			t.Errorf("primary received %d bytes, which do not match", len(got))
+		}
+		if got := <-mirrorc; !bytes.Equal(got, content) {
+			t.Errorf("shadow received %d bytes, which do not match", len(got))
+		}
+		if failed {
+			t.Error("shadow reported as failed")
+		}
+	})
+	t.Run("ShadowClosed", func(t *testing.T) {
+		testMirrorShadowFailure(t, content, func(client interface{ Close() error }) {
+			client.Close()
+		})
+	})
+	t.Run("ShadowStalled", func(t *testing.T) {
+		testMirrorShadowFailure(t, content, func(interface{ Close() error }) {})
+	})
+	t.Run("PrimaryFailed", testMirrorPrimaryFailed)
+}
+
+// testMirrorPrimaryFailed checks that the goroutine which reads from src
+// does not report misuse, which would panic under MisusePanic, when it
+// finishes after Mirror has returned, and closed the pipe.
+func testMirrorPrimaryFailed(t *testing.T) {
+	defer zerocopy.SetMisusePolicy(zerocopy.MisuseIgnore)
+	zerocopy.SetMisusePolicy(zerocopy.MisusePanic)
+
+	srcw, src, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	client, shadow, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shadow.Close()
+	defer client.Close()
+
+	if _, err := srcw.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	primary := &shortWriter{limit: 0}
+	if _, err := zerocopy.Mirror(primary, shadow, src, nil); err == nil {
+		t.Fatal("Mirror succeeded with a failing primary")
+	}
+	// Let the reader goroutine finish, after Mirror has returned.
+	srcw.Close()
+	time.Sleep(50 * time.Millisecond)
+}
+
+// testMirrorShadowFailure checks that the primary receives all of content
+// if the shadow fails. stop is called with the shadow's peer, which never
+// reads.
+func testMirrorShadowFailure(t *testing.T, content []byte, stop func(interface{ Close() error })) {
+	src := newParitySource(t, content)
+	defer src.Close()
+	primary, received := newParitySink(t)
+	client, shadow, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shadow.Close()
+	defer client.Close()
+	stop(client)
+
+	failedc := make(chan error, 1)
+	opts := &zerocopy.MirrorOptions{
+		ShadowTimeout: 50 * time.Millisecond,
+		ShadowFailed:  func(err error) { failedc <- err },
+	}
+	n, err := zerocopy.Mirror(primary, shadow, src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Errorf("wrote %d bytes to the primary, want %d", n, len(content))
+	}
+	if got := received(); !bytes.Equal(got, content) {
+		t.Errorf("primary received %d bytes, which do not match", len(got))
+	}
+	select {
+	case err := <-failedc:
+		t.Logf("shadow failed: %v", err)
+	default:
+		t.Error("shadow failure not reported")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import "sync/atomic"
+
+// A MisusePolicy specifies how package zerocopy reacts to misuse of its
+// API. The following are considered misuse:
+//
+//   - calling CloseRead on a Pipe whose read side is already closed
+//   - calling CloseWrite on a Pipe whose write side is already closed
+//   - calling Close on a Pipe whose read and write sides are both
+//     already closed
+//   - calling TeeAll with no writers
+//
+// Calling Tee or DetachTee concurrently with other methods, or after
+// reading from a Pipe, is not misuse.
+type MisusePolicy int
+
+const (
+	// MisuseIgnore ignores misuse: repeated calls to the close methods
+	// return nil, and TeeAll with no writers detaches the tee, as
+	// DetachTee does. This is the default.
+	MisuseIgnore MisusePolicy = iota
+
+	// MisuseReport makes methods which return an error return a
+	// *MisuseError. Methods which do not return an error handle
+	// misuse as under MisuseIgnore.
+	MisuseReport
+
+	// MisusePanic panics with a *MisuseError.
+	MisusePanic
+)
+
+var misusePolicy int32 // atomic; MisusePolicy
+
+// SetMisusePolicy sets the policy for handling misuse of the API, for all
+// pipes. It is meant to be called once, early in the life of a program,
+// by programs which want misuse to be reported, or to crash loudly.
+func SetMisusePolicy(policy MisusePolicy) {
+	atomic.StoreInt32(&misusePolicy, int32(policy))
+}
+
+// A MisuseError describes a misuse of the API. See MisusePolicy.
+type MisuseError struct {
+	// Op is the method which was misused, such as "CloseWrite".
+	Op string
+
+	// Reason describes the misuse.
+	Reason string
+}
+
+func (e *MisuseError) Error() string {
+	return "zerocopy: misuse of " + e.Op + ": " + e.Reason
+}
+
+// misuse reports a misuse of op according to the misuse policy. It returns
+// the error which the caller should return, if any.
+func misuse(op, reason string) error {
+	switch MisusePolicy(atomic.LoadInt32(&misusePolicy)) {
+	case MisuseReport:
+		return &MisuseError{Op: op, Reason: reason}
+	case MisusePanic:
+		panic(&MisuseError{Op: op, Reason: reason})
+	default:
+		return nil
+	}
+}
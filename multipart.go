@@ -0,0 +1,68 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// A MultipartWriter is a multipart.Writer which can also write parts whose
+// bodies are files. Boundaries and part headers are written in userspace by
+// package mime/multipart, while file contents are transferred using SendFile,
+// so that uploads of large files stay on the fast path.
+type MultipartWriter struct {
+	*multipart.Writer
+
+	w io.Writer
+}
+
+// NewMultipartWriter creates a new MultipartWriter writing to w.
+func NewMultipartWriter(w io.Writer) *MultipartWriter {
+	return &MultipartWriter{
+		Writer: multipart.NewWriter(w),
+		w:      w,
+	}
+}
+
+// WriteFile writes a part with the specified header, with the entire
+// contents of f as the body. The file offset of f is neither used nor
+// modified.
+func (mw *MultipartWriter) WriteFile(header textproto.MIMEHeader, f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// The part writer returned by CreatePart writes straight through
+	// to mw.w, so we can do the same, once the headers are out.
+	if _, err := mw.CreatePart(header); err != nil {
+		return err
+	}
+	_, err = ServeFileRanges(mw.w, f, []Range{{Off: 0, Len: fi.Size()}})
+	return err
+}
+
+// WriteFormFile is like WriteFile, but uses the same header as
+// multipart.Writer.CreateFormFile.
+func (mw *MultipartWriter) WriteFormFile(fieldname, filename string, f *os.File) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition",
+		fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			escapeQuotes(fieldname), escapeQuotes(filename)))
+	h.Set("Content-Type", "application/octet-stream")
+	return mw.WriteFile(h, f)
+}
+
+// quoteEscaper and escapeQuotes are copied from package mime/multipart.
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
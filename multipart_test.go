@@ -0,0 +1,80 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestMultipartWriter(t *testing.T) {
+	content := strings.Repeat("0123456789", 100000)
+	f, err := ioutil.TempFile("", "zerocopy-multipart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := io.WriteString(f, content); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.TempFile("", "zerocopy-multipart-body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(body.Name())
+	defer body.Close()
+
+	mw := zerocopy.NewMultipartWriter(body)
+	if err := mw.WriteField("description", "a big file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.WriteFormFile("upload", `big "file".txt`, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(body, mw.Boundary())
+	form, err := mr.ReadForm(1 << 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer form.RemoveAll()
+	if got := form.Value["description"]; len(got) != 1 || got[0] != "a big file" {
+		t.Fatalf("description = %q", got)
+	}
+	fhs := form.File["upload"]
+	if len(fhs) != 1 {
+		t.Fatalf("got %d files, want 1", len(fhs))
+	}
+	if fhs[0].Filename != `big "file".txt` {
+		t.Errorf("filename = %q", fhs[0].Filename)
+	}
+	uf, err := fhs[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uf.Close()
+	got, err := ioutil.ReadAll(uf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Fatalf("got %d bytes, want %d, or content mismatch", len(got), len(content))
+	}
+}
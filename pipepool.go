@@ -0,0 +1,230 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPipePoolSize is the default number of idle pipes kept by Transfer.
+const defaultPipePoolSize = 16
+
+// defaultPipeBufferSize is the size of the buffer Linux gives new pipes,
+// unless the user is over their pipe buffer quota.
+var defaultPipeBufferSize = 16 * os.Getpagesize()
+
+// pipePool holds a chan idlePipe, the pool of idle pipes used by Transfer,
+// or a nil channel if pipes are not reused.
+var pipePool atomic.Value
+
+// An idlePipe is a pipe in the pool, along with the time it was put there.
+type idlePipe struct {
+	p     *Pipe
+	since time.Time
+}
+
+var (
+	pipePoolTTL  int64  // atomic; time.Duration, or 0 if idle pipes are kept
+	pipeWait     int64  // atomic; time.Duration, or 0 if Transfer does not wait
+	fdExhaustion uint64 // atomic; see FDExhaustions
+
+	pipePoolPressureMu sync.Mutex
+	pipePoolPressureFn func()
+
+	// pipeReaper is the pending timer for closing expired idle pipes,
+	// or nil.
+	pipeReaperMu sync.Mutex
+	pipeReaper   *time.Timer
+)
+
+func init() {
+	pipePool.Store(make(chan idlePipe, defaultPipePoolSize))
+}
+
+// SetPipePoolSize sets the maximum number of idle pipes which Transfer and
+// TransferN keep for reuse by later calls. Each pipe holds two file
+// descriptors. If n is not positive, pipes are not reused, and each call
+// creates its own pipe. The default is 16. Pipes are only used on Linux.
+//
+// Reusing pipes saves two system calls to create a pipe, and two to close
+// it, for every call, and keeps the number of open file descriptors stable
+// under high connection churn. Pipes are only returned to the pool if they
+// were drained completely.
+//
+// Idle pipes held by the pool at the time of the call are closed. Calling
+// SetPipePoolSize with the current size is therefore a way to release
+// the kernel memory held by idle pipes, for example from a function
+// registered using SetPipePoolPressureFunc.
+func SetPipePoolSize(n int) {
+	var pool chan idlePipe
+	if n > 0 {
+		pool = make(chan idlePipe, n)
+	}
+	old, _ := pipePool.Load().(chan idlePipe)
+	pipePool.Store(pool)
+	drainPipePool(old)
+}
+
+// drainPipePool closes the pipes in pool, which is no longer in use.
+//
+// The new pool is published before the old one is drained, so a pipe put
+// in the old pool concurrently is either closed here, or by the caller
+// which put it there, which must check, once it has put a pipe in a pool,
+// whether the pool is still current, and drain it if not. See putPipe.
+func drainPipePool(pool chan idlePipe) {
+	for {
+		select {
+		case ip := <-pool:
+			ip.p.close()
+		default:
+			return
+		}
+	}
+}
+
+// checkPipePool drains pool, into which a pipe was just put, if it has
+// been replaced in the meantime. See drainPipePool.
+func checkPipePool(pool chan idlePipe) {
+	if cur, _ := pipePool.Load().(chan idlePipe); cur != pool {
+		drainPipePool(pool)
+	}
+}
+
+// SetPipePoolIdleTimeout arranges for pipes whose buffers were grown
+// beyond the default size by a transfer, and which then stay idle in the
+// pool for longer than d, to be shrunk back to the default size, so that
+// bursty workloads do not hold on to large pipe buffers indefinitely. The
+// pipes themselves are kept for reuse. If d is not positive, which is the
+// default, idle pipes keep their size until they are reused, or until
+// the pool is resized.
+func SetPipePoolIdleTimeout(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	atomic.StoreInt64(&pipePoolTTL, int64(d))
+	if d > 0 {
+		schedulePipeReaper(d)
+	}
+}
+
+// SetPipePoolPressureFunc registers a function to be called when Transfer
+// creates a pipe, and the operating system gives it a smaller buffer
+// than the default, or when Transfer fails to grow a pipe for the same
+// reason. On Linux, this happens once the pipe buffers held by the user
+// exceed the limit in /proc/sys/fs/pipe-user-pages-soft, and transfers
+// through such pipes need more system calls. fn may release resources in
+// response, for instance by calling SetPipePoolSize. fn is called
+// synchronously, and must not block for long. If fn is nil, which is the
+// default, pipe buffer sizes are not checked.
+func SetPipePoolPressureFunc(fn func()) {
+	pipePoolPressureMu.Lock()
+	pipePoolPressureFn = fn
+	pipePoolPressureMu.Unlock()
+}
+
+// SetPipeWait sets how long Transfer and TransferN wait for a pipe when
+// creating one fails because the process or the system is out of file
+// descriptors (EMFILE or ENFILE). While waiting, they take the first pipe
+// returned to the pool by another call, if any, and retry creating one
+// with jittered exponential backoff, starting at 1ms and capped at 100ms.
+// If d is not positive, which is the default, they do not wait.
+//
+// Whether or not they wait, if no pipe can be obtained, Transfer and
+// TransferN copy the data through a userspace buffer rather than fail,
+// so SetPipeWait trades latency for avoiding the generic copy during
+// transient spikes in file descriptor usage. See also FDExhaustions.
+func SetPipeWait(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	atomic.StoreInt64(&pipeWait, int64(d))
+}
+
+// FDExhaustions returns the number of times Transfer or TransferN failed
+// to create a pipe because the process or the system was out of file
+// descriptors. Every failed attempt counts, including retries made under
+// SetPipeWait.
+func FDExhaustions() uint64 {
+	return atomic.LoadUint64(&fdExhaustion)
+}
+
+// pipePoolPressureFunc returns the function registered using
+// SetPipePoolPressureFunc, or nil.
+func pipePoolPressureFunc() func() {
+	pipePoolPressureMu.Lock()
+	defer pipePoolPressureMu.Unlock()
+	return pipePoolPressureFn
+}
+
+// schedulePipeReaper arranges for reapIdlePipes to run after d, unless it
+// is already scheduled.
+func schedulePipeReaper(d time.Duration) {
+	pipeReaperMu.Lock()
+	defer pipeReaperMu.Unlock()
+	if pipeReaper == nil {
+		pipeReaper = time.AfterFunc(d, reapIdlePipes)
+	}
+}
+
+// reapIdlePipes shrinks the grown pipes which have been idle in the pool
+// for longer than the idle timeout, and reschedules itself for the others.
+func reapIdlePipes() {
+	pipeReaperMu.Lock()
+	pipeReaper = nil
+	pipeReaperMu.Unlock()
+
+	ttl := time.Duration(atomic.LoadInt64(&pipePoolTTL))
+	if ttl <= 0 {
+		return
+	}
+	pool, _ := pipePool.Load().(chan idlePipe)
+	now := time.Now()
+	next := ttl
+	pending := false
+	// Cycle through the pipes in the pool once, shrinking those which
+	// have expired. Concurrent calls to getPipe and putPipe may take or
+	// add pipes in the meantime, which is harmless.
+	for i := len(pool); i > 0; i-- {
+		var ip idlePipe
+		select {
+		case ip = <-pool:
+		default:
+			return
+		}
+		if pipeGrown(ip.p) {
+			idle := now.Sub(ip.since)
+			if idle >= ttl {
+				if err := ip.p.SetBufferSize(defaultPipeBufferSize); err != nil {
+					ip.p.close()
+					continue
+				}
+			} else {
+				pending = true
+				if left := ttl - idle; left < next {
+					next = left
+				}
+			}
+		}
+		select {
+		case pool <- ip:
+			checkPipePool(pool)
+		default:
+			ip.p.close()
+		}
+	}
+	if pending {
+		schedulePipeReaper(next)
+	}
+}
+
+// pipeGrown reports whether p has a larger buffer than new pipes get.
+func pipeGrown(p *Pipe) bool {
+	n, err := p.BufferSize()
+	return err == nil && n > defaultPipeBufferSize
+}
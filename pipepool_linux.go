@@ -0,0 +1,164 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// errPipeWait is returned by waitPipe if no pipe could be obtained in time.
+var errPipeWait = errors.New("zerocopy: timed out waiting for a pipe")
+
+// getPipe returns an idle pipe from the pool, or a new pipe if the pool
+// is empty.
+func getPipe() (*Pipe, error) {
+	pool, _ := pipePool.Load().(chan idlePipe)
+	select {
+	case ip := <-pool:
+		count(&metrics.activePipes, 1)
+		return ip.p, nil
+	default:
+	}
+	p, err := NewPipe()
+	if isFDExhaustion(err) {
+		atomic.AddUint64(&fdExhaustion, 1)
+		if d := atomic.LoadInt64(&pipeWait); d > 0 {
+			p, err = waitPipe(pool, time.Duration(d))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fn := pipePoolPressureFunc(); fn != nil {
+		if n, err := p.BufferSize(); err == nil && n < defaultPipeBufferSize {
+			fn()
+		}
+	}
+	count(&metrics.activePipes, 1)
+	return p, nil
+}
+
+// getPipes returns at least n pipes from the pool, as by getPipe, and more
+// if needed for their buffers to add up to size bytes. Pipes which are
+// smaller than the remaining size are grown if possible. If any pipe
+// cannot be obtained, getPipes returns the others to the pool, and
+// returns the error.
+func getPipes(n int, size int) ([]*Pipe, error) {
+	var pipes []*Pipe
+	for len(pipes) < n || size > 0 {
+		p, err := getPipe()
+		if err != nil {
+			putPipes(pipes)
+			return nil, err
+		}
+		pipes = append(pipes, p)
+		if size <= 0 {
+			continue
+		}
+		want := size
+		if max := pipeMaxSize(); max > 0 && want > max {
+			want = max
+		}
+		if want > maxSpliceSize {
+			want = maxSpliceSize
+		}
+		growPipe(p, want)
+		cur, err := p.BufferSize()
+		if err != nil {
+			cur = defaultPipeBufferSize
+		}
+		size -= cur
+	}
+	return pipes, nil
+}
+
+// putPipes returns pipes to the pool, as by putPipe.
+func putPipes(pipes []*Pipe) {
+	for _, p := range pipes {
+		putPipe(p)
+	}
+}
+
+// waitPipe waits for up to d for a pipe to be returned to pool, and
+// retries creating a pipe in the meantime, while it fails because there
+// are no file descriptors available. See SetPipeWait.
+func waitPipe(pool chan idlePipe, d time.Duration) (*Pipe, error) {
+	deadline := time.Now().Add(d)
+	backoff := time.Millisecond
+	for {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, errPipeWait
+		}
+		// Sleep for between half and all of backoff.
+		if j := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)); j < wait {
+			wait = j
+		}
+		t := time.NewTimer(wait)
+		select {
+		case ip := <-pool:
+			t.Stop()
+			return ip.p, nil
+		case <-t.C:
+		}
+		p, err := NewPipe()
+		if !isFDExhaustion(err) {
+			return p, err
+		}
+		atomic.AddUint64(&fdExhaustion, 1)
+		if backoff *= 2; backoff > 100*time.Millisecond {
+			backoff = 100 * time.Millisecond
+		}
+	}
+}
+
+// isFDExhaustion reports whether err, as returned by NewPipe, means that
+// the process or the system is out of file descriptors.
+func isFDExhaustion(err error) bool {
+	se, ok := err.(*os.SyscallError)
+	return ok && (se.Err == syscall.EMFILE || se.Err == syscall.ENFILE)
+}
+
+// putPipe returns p to the pool, if it is empty, and if the pool has room.
+// Otherwise, putPipe closes p.
+func putPipe(p *Pipe) {
+	count(&metrics.activePipes, -1)
+	if n, err := p.buffered(); err != nil || n != 0 {
+		p.close()
+		return
+	}
+	pool, _ := pipePool.Load().(chan idlePipe)
+	select {
+	case pool <- idlePipe{p: p, since: time.Now()}:
+		checkPipePool(pool)
+		if ttl := atomic.LoadInt64(&pipePoolTTL); ttl > 0 && pipeGrown(p) {
+			schedulePipeReaper(time.Duration(ttl))
+		}
+	default:
+		p.close()
+	}
+}
+
+// growPipe grows p to want bytes, if it is smaller. If the operating
+// system refuses, because the user is over their pipe buffer quota, the
+// function registered using SetPipePoolPressureFunc is called, as it is
+// for new pipes which get a smaller buffer than the default.
+func growPipe(p *Pipe, want int) {
+	if cur, err := p.BufferSize(); err != nil || cur >= want {
+		return
+	}
+	err := p.SetBufferSize(want)
+	if se, ok := err.(*os.SyscallError); ok && se.Err == syscall.EPERM {
+		if fn := pipePoolPressureFunc(); fn != nil {
+			fn()
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+)
+
+func TestPipePool(t *testing.T) {
+	defer zerocopy.SetPipePoolSize(16)
+
+	transfer := func(t *testing.T) {
+		t.Helper()
+		src := newParitySource(t, make([]byte, 1<<16))
+		dst, received := newParitySink(t)
+		if _, err := zerocopy.Transfer(dst, src); err != nil {
+			t.Fatal(err)
+		}
+		src.Close()
+		received()
+	}
+
+	zerocopy.SetPipePoolSize(0)
+	base := countOpenFiles(t)
+	transfer(t)
+	if n := countOpenFiles(t); n != base {
+		t.Fatalf("without a pool: %d open files after Transfer, want %d", n, base)
+	}
+
+	// With a pool, the first Transfer leaves an idle pipe behind, and
+	// later ones reuse it.
+	zerocopy.SetPipePoolSize(1)
+	for i := 0; i < 3; i++ {
+		transfer(t)
+		if n := countOpenFiles(t); n != base+2 {
+			t.Fatalf("with a pool: %d open files after Transfer, want %d", n, base+2)
+		}
+	}
+
+	// Shrinking the pool closes the idle pipe.
+	zerocopy.SetPipePoolSize(0)
+	if n := countOpenFiles(t); n != base {
+		t.Fatalf("after disabling the pool: %d open files, want %d", n, base)
+	}
+}
+
+func TestPipePoolIdleTimeout(t *testing.T) {
+	defer zerocopy.SetPipePoolSize(16)
+	defer zerocopy.SetPipePoolIdleTimeout(0)
+
+	zerocopy.SetPipePoolSize(0)
+	base := countOpenFiles(t)
+	zerocopy.SetPipePoolSize(1)
+	zerocopy.SetPipePoolIdleTimeout(50 * time.Millisecond)
+
+	src := newParitySource(t, make([]byte, 1<<16))
+	dst, received := newParitySink(t)
+	if _, err := zerocopy.Transfer(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	src.Close()
+	received()
+	if n := countOpenFiles(t); n != base+2 {
+		t.Fatalf("%d open files after Transfer, want %d", n, base+2)
+	}
+
+	// The idle pipe has the default size, so it is kept open, for
+	// reuse, after the timeout.
+	time.Sleep(100 * time.Millisecond)
+	if n := countOpenFiles(t); n != base+2 {
+		t.Fatalf("%d open files after the idle timeout, want %d", n, base+2)
+	}
+}
+
+func TestPipeWait(t *testing.T) {
+	defer zerocopy.SetPipePoolSize(16)
+	defer zerocopy.SetPipeWait(0)
+
+	zerocopy.SetPipePoolSize(1)
+	zerocopy.SetPipeWait(10 * time.Second)
+
+	// The first transfer holds a pipe until its source is closed.
+	client, server, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+	dst1, received1 := newParitySink(t)
+	base := countOpenFiles(t)
+	first := make(chan error, 1)
+	go func() {
+		_, err := zerocopy.Transfer(dst1, server)
+		first <- err
+	}()
+	if _, err := client.Write([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	for countOpenFiles(t) != base+2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	content := bytes.Repeat([]byte("second"), 10000)
+	client2, src2, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src2.Close()
+	written := make(chan struct{})
+	go func() {
+		client2.Write(content)
+		client2.Close()
+		close(written)
+	}()
+	dst2, received2 := newParitySink(t)
+
+	// Run out of file descriptors, so that the second transfer cannot
+	// create a pipe, and must wait for the first one's.
+	release := exhaustFDs(t)
+	defer release()
+	exhaustions := zerocopy.FDExhaustions()
+	second := make(chan error, 1)
+	go func() {
+		_, err := zerocopy.Transfer(dst2, src2)
+		second <- err
+	}()
+	for zerocopy.FDExhaustions() == exhaustions {
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case err := <-second:
+		t.Fatalf("second transfer did not wait for a pipe: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client.Close()
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+	release()
+	<-written
+	if got := received1(); string(got) != "first" {
+		t.Errorf("first transfer: got %q, want %q", got, "first")
+	}
+	if got := received2(); !bytes.Equal(got, content) {
+		t.Errorf("second transfer: got %d bytes, which do not match", len(got))
+	}
+}
+
+// exhaustFDs lowers the file descriptor limit of the process, and opens
+// files until no more can be opened. It returns a function which closes
+// the files and restores the limit.
+func exhaustFDs(t *testing.T) (release func()) {
+	t.Helper()
+	var old syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &old); err != nil {
+		t.Skip(err)
+	}
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	lim := old
+	lim.Cur = uint64(len(fds)) + 64
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		t.Skip(err)
+	}
+	var files []*os.File
+	for {
+		f, err := os.Open(os.DevNull)
+		if err != nil {
+			break
+		}
+		files = append(files, f)
+	}
+	released := false
+	return func() {
+		if released {
+			return
+		}
+		released = true
+		for _, f := range files {
+			f.Close()
+		}
+		syscall.Setrlimit(syscall.RLIMIT_NOFILE, &old)
+	}
+}
+
+func countOpenFiles(t *testing.T) int {
+	t.Helper()
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	return len(fds)
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSizePipeForSockets(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			defer c.Close()
+		}
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	tc := c.(*net.TCPConn)
+	const bufsize = 512 << 10
+	if err := tc.SetReadBuffer(bufsize); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SetWriteBuffer(bufsize); err != nil {
+		t.Fatal(err)
+	}
+	rc := rawConnOf(t, tc)
+
+	p, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	before, err := p.BufferSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := socketBufferSize(rc, syscall.SO_SNDBUF)
+	if r := socketBufferSize(rc, syscall.SO_RCVBUF); r < want {
+		want = r
+	}
+	if max := pipeMaxSize(); max > 0 && want > max {
+		want = max
+	}
+	if want <= before {
+		t.Skipf("socket buffers (%d bytes) not larger than the pipe (%d bytes)", want, before)
+	}
+
+	sizePipeForSockets(p, rc, rc)
+	got, err := p.BufferSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got < want {
+		t.Fatalf("pipe has %d bytes of buffer, want at least %d", got, want)
+	}
+
+	// Neither end is a socket: the pipe keeps its size.
+	q, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	sizePipeForSockets(q, q.rrc, q.wrc)
+	if n, err := q.BufferSize(); err != nil || n != before {
+		t.Fatalf("pipe resized to %d (%v), want %d", n, err, before)
+	}
+}
+
+func TestPipePoolShrinkIdle(t *testing.T) {
+	defer SetPipePoolSize(defaultPipePoolSize)
+	defer SetPipePoolIdleTimeout(0)
+
+	SetPipePoolSize(1)
+	p, err := getPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	grown := 4 * defaultPipeBufferSize
+	if max := pipeMaxSize(); max > 0 && grown > max {
+		t.Skipf("pipe-max-size %d too small", max)
+	}
+	if err := p.SetBufferSize(grown); err != nil {
+		p.Close()
+		t.Skip(err)
+	}
+	SetPipePoolIdleTimeout(20 * time.Millisecond)
+	putPipe(p)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for pipeGrown(p) {
+		if time.Now().After(deadline) {
+			t.Fatal("idle pipe not shrunk after the idle timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	q, err := getPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer putPipe(q)
+	if q != p {
+		t.Fatal("shrunk pipe not kept in the pool")
+	}
+	if n, err := p.BufferSize(); err != nil || n != defaultPipeBufferSize {
+		t.Fatalf("idle pipe has %d bytes of buffer (%v), want %d", n, err, defaultPipeBufferSize)
+	}
+}
+
+func rawConnOf(t *testing.T, sc syscall.Conn) syscall.RawConn {
+	t.Helper()
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc
+}
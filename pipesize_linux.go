@@ -0,0 +1,89 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	pipeMaxOnce sync.Once
+	pipeMax     int
+)
+
+// pipeMaxSize returns the largest buffer size an unprivileged process may
+// give a pipe, as set by /proc/sys/fs/pipe-max-size, or 0 if it cannot be
+// determined.
+func pipeMaxSize() int {
+	pipeMaxOnce.Do(func() {
+		b, err := ioutil.ReadFile("/proc/sys/fs/pipe-max-size")
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err == nil && n > 0 {
+			pipeMax = n
+		}
+	})
+	return pipeMax
+}
+
+// socketBufferSize returns the value of the socket option opt, such as
+// SO_RCVBUF, for the socket rc refers to, or 0 if rc does not refer to a
+// socket.
+func socketBufferSize(rc syscall.RawConn, opt int) int {
+	var (
+		n     int
+		operr error
+	)
+	err := rc.Control(func(fd uintptr) {
+		n, operr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, opt)
+	})
+	if err != nil || operr != nil {
+		return 0
+	}
+	return n
+}
+
+// sizePipeForSockets grows p, a pipe taken from the pool for a transfer
+// from the socket rrc refers to, to the socket wrc refers to, so that it
+// can hold what the source socket has buffered, and the destination socket
+// can accept, in one round: a pipe smaller than the socket buffers makes
+// the transfer go back and forth between the two sockets more often than
+// necessary. The size is capped by maxSpliceSize, and by the system limit.
+//
+// The pipe size is cached, and a socket buffer option is only queried if
+// the options queried so far call for a larger pipe, so in the common
+// case, where the pipe is large enough already, sizePipeForSockets costs
+// one system call, and no more than three. Grown pipes are shrunk back
+// when they stay idle in the pool; see SetPipePoolIdleTimeout. Errors are
+// ignored: the pipe keeps its size, which only costs performance.
+func sizePipeForSockets(p *Pipe, rrc, wrc syscall.RawConn) {
+	cur, err := p.BufferSize()
+	if err != nil {
+		return
+	}
+	want := socketBufferSize(rrc, unix.SO_RCVBUF)
+	if want <= cur {
+		return
+	}
+	if n := socketBufferSize(wrc, unix.SO_SNDBUF); n < want {
+		want = n
+	}
+	if want > maxSpliceSize {
+		want = maxSpliceSize
+	}
+	if max := pipeMaxSize(); max > 0 && want > max {
+		want = max
+	}
+	growPipe(p, want)
+}
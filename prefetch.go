@@ -0,0 +1,35 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+)
+
+// TransferPrefetch is like Transfer from a regular file, from its current
+// offset to its end, but reads ahead: while the data in one pipe is being
+// written to dst, the next chunk of the file is read into a second pipe.
+// This hides storage latency on volumes where reads are slow, but which
+// can serve them concurrently with the writes to dst, such as network
+// block storage. On fast local storage, it rarely pays off.
+//
+// If src is not a regular file, or the data cannot be spliced, for example
+// because dst does not implement syscall.Conn, TransferPrefetch behaves
+// like Transfer.
+//
+// Since data may have been read ahead, the file offset of src can be past
+// the data written to dst when writing to dst fails. In that case,
+// TransferPrefetch moves the offset back to just past the last byte
+// written to dst before it returns, so that the transfer can be resumed.
+// Staged data is therefore never reported as a *StagedError. If the
+// offset cannot be moved back, TransferPrefetch returns the error from
+// Seek instead of the write error, since the transfer cannot be resumed
+// from the offset of src.
+func TransferPrefetch(dst io.Writer, src *os.File) (int64, error) {
+	defer startTransfer()()
+	return transferPrefetch(dst, src)
+}
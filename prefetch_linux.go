@@ -0,0 +1,56 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+func transferPrefetch(dst io.Writer, src *os.File) (int64, error) {
+	if !isRegularFile(src) {
+		return transfer(dst, src)
+	}
+	wsc, ok := dst.(syscall.Conn)
+	if !ok {
+		return transfer(dst, src)
+	}
+	wrc, err := wsc.SyscallConn()
+	if err != nil {
+		return transfer(dst, src)
+	}
+	rrc, err := src.SyscallConn()
+	if err != nil {
+		return transfer(dst, src)
+	}
+	pipes, err := getPipes(2, 0)
+	if err != nil {
+		return transfer(dst, src)
+	}
+	defer putPipes(pipes)
+
+	// Reads from src never block indefinitely, so there is no need to
+	// interrupt them.
+	moved, unsent, fallback, err := relay(dst, wrc, rrc, true, pipes, nil)
+	if err != nil {
+		var n int64
+		for _, c := range unsent {
+			n += int64(c.n)
+		}
+		if n > 0 {
+			if _, serr := src.Seek(-n, io.SeekCurrent); serr != nil {
+				return moved, serr
+			}
+		}
+		return moved, err
+	}
+	if fallback {
+		n, err := copyFallback(dst, src)
+		return moved + n, err
+	}
+	return moved, nil
+}
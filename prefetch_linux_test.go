@@ -0,0 +1,100 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+)
+
+func TestTransferPrefetch(t *testing.T) {
+	content := make([]byte, 4<<20)
+	rand.Read(content)
+
+	t.Run("Socket", func(t *testing.T) {
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+		dst, received := newParitySink(t)
+
+		n, err := zerocopy.TransferPrefetch(dst, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(content)) {
+			t.Fatalf("transferred %d bytes, want %d", n, len(content))
+		}
+		if got := received(); !bytes.Equal(got, content) {
+			t.Errorf("received %d bytes, which do not match", len(got))
+		}
+	})
+	t.Run("Buffer", func(t *testing.T) {
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+
+		var dst bytes.Buffer
+		n, err := zerocopy.TransferPrefetch(&dst, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(content)) || !bytes.Equal(dst.Bytes(), content) {
+			t.Fatalf("transferred %d bytes, want %d, matching", n, len(content))
+		}
+	})
+	t.Run("Metrics", func(t *testing.T) {
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+		dst, received := newParitySink(t)
+		defer received()
+
+		before := zerocopy.ReadMetrics()
+		if _, err := zerocopy.TransferPrefetch(dst, src); err != nil {
+			t.Fatal(err)
+		}
+		after := zerocopy.ReadMetrics()
+		if got := after.TransfersStarted - before.TransfersStarted; got != 1 {
+			t.Errorf("started %d transfers, want 1", got)
+		}
+		if got := after.TransfersCompleted - before.TransfersCompleted; got != 1 {
+			t.Errorf("completed %d transfers, want 1", got)
+		}
+	})
+	t.Run("WriteError", func(t *testing.T) {
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+		client, server, err := transferTestSocketPair("unix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		defer server.Close()
+
+		// Nobody reads from client, so the write deadline expires
+		// once the socket buffers are full. The file offset must
+		// then match what was written.
+		server.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+		n, err := zerocopy.TransferPrefetch(server, src)
+		if !os.IsTimeout(err) {
+			t.Fatalf("got %v, want a timeout", err)
+		}
+		off, err := src.Seek(0, io.SeekCurrent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off != n {
+			t.Fatalf("file offset is %d, but %d bytes were written", off, n)
+		}
+	})
+}
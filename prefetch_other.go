@@ -0,0 +1,17 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+)
+
+func transferPrefetch(dst io.Writer, src *os.File) (int64, error) {
+	return transfer(dst, src)
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"syscall"
+)
+
+// A Strategy is a mechanism Transfer or CopyFile uses to move data.
+type Strategy int
+
+// Strategies, as reported by Probe, and in CopyStats.
+const (
+	// StrategyGeneric copies the data through a userspace buffer, as
+	// io.Copy does.
+	StrategyGeneric Strategy = iota + 1
+
+	// StrategySplice moves the data through a pipe, using splice(2).
+	StrategySplice
+
+	// StrategyCopyFileRange copies the data between regular files using
+	// copy_file_range(2).
+	StrategyCopyFileRange
+
+	// StrategyAdaptive copies the data through a userspace buffer, as
+	// StrategyGeneric does, but the buffer grows from 32 KiB to 1 MiB
+	// as long as reads from the source fill it, so that fast streams
+	// take fewer system calls. It is the best available strategy on
+	// platforms other than Linux, which have no splice(2).
+	StrategyAdaptive
+
+	// StrategyGenericTLS copies the data through a userspace buffer, as
+	// StrategyGeneric does, because dst or src is a TLS connection, such
+	// as a *tls.Conn, which hides its file descriptor. See KernelTLS for
+	// a way around this.
+	StrategyGenericTLS
+
+	// StrategyGenericDatagram copies the data through a userspace
+	// buffer, as StrategyGeneric does, because dst or src is a socket
+	// which preserves message boundaries, such as a *net.UDPConn, or a
+	// *net.UnixConn on the "unixgram" or "unixpacket" networks, which
+	// splice(2) cannot serve without losing or truncating messages.
+	// Messages from src are copied whole, one at a time. Protocols
+	// which run over UDP, such as QUIC and DTLS, also frame and encrypt
+	// data in userspace, so no data path through the kernel applies to
+	// them. To reduce the cost of sending large datagrams, see
+	// SocketWriter, which uses MSG_ZEROCOPY for UDP sockets on Linux
+	// 5.0 and later. In strict mode, such transfers fail with
+	// ErrDatagramFallback.
+	StrategyGenericDatagram
+
+	// StrategyReflink makes the destination file share the data
+	// blocks of the source, on file systems which support it, such as
+	// Btrfs and XFS. No data is copied until either file is modified.
+	// Only CopyFile and CopyFileData use it.
+	StrategyReflink
+
+	// StrategySendfile copies the data between regular files using
+	// sendfile(2), within the kernel. Only CopyFile and CopyFileData
+	// use it.
+	StrategySendfile
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case StrategyGeneric:
+		return "generic"
+	case StrategySplice:
+		return "splice"
+	case StrategyCopyFileRange:
+		return "copy_file_range"
+	case StrategyAdaptive:
+		return "adaptive"
+	case StrategyGenericTLS:
+		return "generic (TLS)"
+	case StrategyGenericDatagram:
+		return "generic (datagram)"
+	case StrategyReflink:
+		return "reflink"
+	case StrategySendfile:
+		return "sendfile"
+	default:
+		return "unknown"
+	}
+}
+
+// Probe reports which strategy Transfer(dst, src) would use, without
+// moving any data. It is meant for verifying that a deployment is on the
+// fast path, for example at startup, or in a health check.
+//
+// Probe inspects the types of dst and src, and, for *os.File values, the
+// kinds of files they refer to, as Transfer does before moving data. The
+// kernel may still refuse the chosen mechanism at run time, for example
+// if a file system does not support splice(2), in which case Transfer
+// falls back to StrategyGeneric, or, from StrategyCopyFileRange, to
+// StrategySplice first.
+//
+// On Linux, system calls which are blocked for the process, as they are
+// by the seccomp filters of some Android devices, are never chosen: Probe
+// and Transfer skip them, and report and use the next strategy instead.
+//
+// If src or dst is an *os.File which cannot be inspected, for example
+// because it is closed, Probe returns an error.
+//
+// On platforms other than Linux, Transfer copies through a buffer, and
+// Probe reports StrategyAdaptive. If src implements io.WriterTo, or dst
+// implements io.ReaderFrom, the copy is left to them, as io.Copy would
+// leave it, and Probe reports StrategyGeneric, even though they may use
+// mechanisms such as sendfile(2) by themselves.
+func Probe(dst io.Writer, src io.Reader) (Strategy, error) {
+	return probe(dst, src)
+}
+
+// isTLSConn reports whether v looks like a TLS connection implemented in
+// userspace, such as a *tls.Conn: it has a Handshake method, and does
+// not expose its file descriptor. Such connections always force a generic
+// copy. Duck typing spares importing crypto/tls, and also recognizes
+// other TLS implementations.
+func isTLSConn(v interface{}) bool {
+	if _, ok := v.(syscall.Conn); ok {
+		return false
+	}
+	_, ok := v.(interface{ Handshake() error })
+	return ok
+}
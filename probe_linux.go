@@ -0,0 +1,133 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// probe mirrors the decisions transferPipe makes before moving data.
+func probe(dst io.Writer, src io.Reader) (Strategy, error) {
+	rd := src
+	if lr, ok := src.(*io.LimitedReader); ok {
+		rd = lr.R
+	}
+	for _, v := range []interface{}{rd, dst} {
+		if f, ok := v.(*os.File); ok {
+			if _, err := f.Stat(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if isTLSConn(rd) || isTLSConn(dst) {
+		return StrategyGenericTLS, nil
+	}
+	if isDatagramSocket(rd) || isDatagramSocket(dst) {
+		return StrategyGenericDatagram, nil
+	}
+	if _, ok := dst.(*net.TCPConn); ok && delegateToStdlib(rd) {
+		// The standard library falls back by itself if splice(2)
+		// is not available, so report what it would do.
+		if !spliceAllowed() {
+			return StrategyGeneric, nil
+		}
+		return StrategySplice, nil
+	}
+	if isRegularFile(rd) && isRegularFile(dst) && copyFileRangeAllowed() {
+		if copyFileRangeCrossFS() || onSameDevice(rd.(*os.File), dst.(*os.File)) {
+			return StrategyCopyFileRange, nil
+		}
+	}
+	if !hasRawConn(rd) || !hasRawConn(dst) || !spliceAllowed() {
+		return StrategyGeneric, nil
+	}
+	return StrategySplice, nil
+}
+
+// isDatagramSocket reports whether v is a syscall.Conn which refers to a
+// socket which preserves message boundaries, such as a *net.UDPConn, or a
+// *net.UnixConn on the "unixgram" or "unixpacket" networks. splice(2)
+// serves such sockets poorly: it cannot write a message in one piece, and
+// it truncates messages read from sequenced-packet sockets to the space
+// left in the pipe.
+func isDatagramSocket(v interface{}) bool {
+	return isMessageSocketType(socketType(v))
+}
+
+// isMessageSocketType reports whether sockets of type typ preserve message
+// boundaries.
+func isMessageSocketType(typ int) bool {
+	return typ == unix.SOCK_DGRAM || typ == unix.SOCK_SEQPACKET
+}
+
+// socketType returns the type of the socket v refers to, such as
+// unix.SOCK_STREAM, or 0 if v is not a syscall.Conn which refers to
+// a socket.
+func socketType(v interface{}) int {
+	switch v.(type) {
+	case *net.UDPConn, *net.IPConn:
+		return unix.SOCK_DGRAM
+	case *net.TCPConn:
+		return unix.SOCK_STREAM
+	case *os.File:
+		// Avoid the system call for the common case. Files may
+		// refer to sockets, but rarely do.
+		return 0
+	}
+	sc, ok := v.(syscall.Conn)
+	if !ok {
+		return 0
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var (
+		typ   int
+		operr error
+	)
+	err = rc.Control(func(fd uintptr) {
+		typ, operr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_TYPE)
+	})
+	if err != nil || operr != nil {
+		return 0
+	}
+	return typ
+}
+
+// hasRawConn reports whether v implements syscall.Conn, and provides a
+// syscall.RawConn.
+func hasRawConn(v interface{}) bool {
+	sc, ok := v.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	_, err := sc.SyscallConn()
+	return err == nil
+}
+
+// onSameDevice reports whether the files a and b live on the same device.
+func onSameDevice(a, b *os.File) bool {
+	afi, err := a.Stat()
+	if err != nil {
+		return false
+	}
+	bfi, err := b.Stat()
+	return err == nil && sameDevice(afi, bfi)
+}
+
+// teeMechanism names the mechanism Pipe.Tee uses for *Pipe targets.
+func teeMechanism() string {
+	if !teeAllowed() {
+		return StrategyGeneric.String()
+	}
+	return "tee"
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestProbe(t *testing.T) {
+	client, server, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+	uclient, userver, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uclient.Close()
+	defer userver.Close()
+	src := newSendFileTestFile(t, []byte("hello"))
+	defer os.Remove(src.Name())
+	defer src.Close()
+	dst := newCopyFileRangeDst(t, 0)
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+
+	tests := []struct {
+		name string
+		dst  io.Writer
+		src  io.Reader
+		want zerocopy.Strategy
+	}{
+		{"TCPToTCP", client, server, zerocopy.StrategySplice},
+		{"UnixToTCP", client, userver, zerocopy.StrategySplice},
+		{"TCPToUnix", uclient, server, zerocopy.StrategySplice},
+		{"FileToTCP", client, src, zerocopy.StrategySplice},
+		{"FileToFile", dst, src, zerocopy.StrategyCopyFileRange},
+		{"Limited", dst, &io.LimitedReader{R: src, N: 1}, zerocopy.StrategyCopyFileRange},
+		{"BufferToTCP", client, bytes.NewReader(nil), zerocopy.StrategyGeneric},
+		{"TCPToBuffer", ioutil.Discard, server, zerocopy.StrategyGeneric},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := zerocopy.Probe(tt.dst, tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	t.Run("Closed", func(t *testing.T) {
+		f := newSendFileTestFile(t, nil)
+		os.Remove(f.Name())
+		f.Close()
+		if _, err := zerocopy.Probe(client, f); err == nil {
+			t.Fatal("probed a closed file")
+		}
+	})
+}
+
+func TestProbeTLS(t *testing.T) {
+	client, server, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	tlsClient := tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+	tlsServer := tls.Server(server, ts.TLS)
+
+	for _, tt := range []struct {
+		name string
+		dst  io.Writer
+		src  io.Reader
+	}{
+		{"ToTLS", tlsClient, bytes.NewReader(nil)},
+		{"FromTLS", ioutil.Discard, tlsServer},
+	} {
+		got, err := zerocopy.Probe(tt.dst, tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != zerocopy.StrategyGenericTLS {
+			t.Errorf("%s: got %v, want %v", tt.name, got, zerocopy.StrategyGenericTLS)
+		}
+	}
+
+	// Transfer copies to and from TLS connections.
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	src := newParitySource(t, content)
+	defer src.Close()
+	received := make(chan []byte)
+	go func() {
+		got := make([]byte, len(content))
+		n, _ := io.ReadFull(tlsServer, got)
+		received <- got[:n]
+	}()
+	if _, err := zerocopy.Transfer(tlsClient, src); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; !bytes.Equal(got, content) {
+		t.Errorf("received %d bytes, which do not match", len(got))
+	}
+}
+
+func TestProbeDatagram(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	dir, err := ioutil.TempDir("", "zerocopy-probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	unixgram, err := net.ListenPacket("unixgram", dir+"/sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixgram.Close()
+
+	for _, tt := range []struct {
+		name string
+		dst  io.Writer
+		src  io.Reader
+	}{
+		{"ToUDP", udp.(io.Writer), bytes.NewReader(nil)},
+		{"FromUDP", ioutil.Discard, udp.(io.Reader)},
+		{"FromUnixgram", ioutil.Discard, unixgram.(io.Reader)},
+	} {
+		got, err := zerocopy.Probe(tt.dst, tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != zerocopy.StrategyGenericDatagram {
+			t.Errorf("%s: got %v, want %v", tt.name, got, zerocopy.StrategyGenericDatagram)
+		}
+	}
+}
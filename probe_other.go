@@ -0,0 +1,35 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+)
+
+func probe(dst io.Writer, src io.Reader) (Strategy, error) {
+	rd := src
+	if lr, ok := src.(*io.LimitedReader); ok {
+		rd = lr.R
+	}
+	for _, v := range []interface{}{rd, dst} {
+		if f, ok := v.(*os.File); ok {
+			if _, err := f.Stat(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if usesAdaptive(dst, src) {
+		return StrategyAdaptive, nil
+	}
+	return StrategyGeneric, nil
+}
+
+func teeMechanism() string {
+	return StrategyGeneric.String()
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import "syscall"
+
+// SpliceRawConn moves up to max bytes from src to dst using a single
+// successful splice(2) call, and returns the number of bytes moved. At
+// least one of src and dst must refer to a pipe. A return value of 0 with
+// a nil error means that src is at EOF.
+//
+// The file descriptors of src and dst must be in non-blocking mode, and
+// registered with the runtime network poller, as those of *os.File values
+// obtained from os.Pipe are. SpliceRawConn waits for them using a
+// TwoFDWaiter, so a concurrent Close of either is never blocked by a wait
+// for the other. flags is passed to splice(2), together with
+// SPLICE_F_NONBLOCK. Errors from splice(2) are of type *os.SyscallError.
+// If splice(2) is blocked for the process, for example by a seccomp
+// filter, SpliceRawConn reports EINVAL, as splice(2) itself does for file
+// descriptors which do not support it.
+//
+// SpliceRawConn is meant for callers with file descriptor types of their
+// own, which do not fit the Pipe API. It is only supported on Linux.
+func SpliceRawConn(dst, src syscall.RawConn, max int, flags int) (int64, error) {
+	return spliceRawConn(dst, src, max, flags)
+}
+
+// TeeRawConn is like SpliceRawConn, but duplicates up to max bytes from
+// src to dst using tee(2), without consuming them from src. Both src and
+// dst must refer to pipes.
+func TeeRawConn(dst, src syscall.RawConn, max int) (int64, error) {
+	return teeRawConn(dst, src, max)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"os"
+	"syscall"
+)
+
+func spliceRawConn(dst, src syscall.RawConn, max int, flags int) (int64, error) {
+	var moved int64
+	w := TwoFDWaiter{R: src, W: dst}
+	err, rerr, werr := w.do(func(rfd, wfd uintptr) error {
+		n, err := spliceFlags(rfd, wfd, max, flags)
+		if err != nil {
+			return err
+		}
+		moved = int64(n)
+		return nil
+	})
+	if rerr != nil {
+		return 0, rerr
+	}
+	if werr != nil {
+		return 0, werr
+	}
+	if err != nil {
+		return 0, os.NewSyscallError("splice", err)
+	}
+	return moved, nil
+}
+
+func teeRawConn(dst, src syscall.RawConn, max int) (int64, error) {
+	var moved int64
+	w := TwoFDWaiter{R: src, W: dst}
+	err, rerr, werr := w.do(func(rfd, wfd uintptr) error {
+		n, err := tee(rfd, wfd, max)
+		if err != nil {
+			return err
+		}
+		moved = n
+		return nil
+	})
+	if rerr != nil {
+		return 0, rerr
+	}
+	if werr != nil {
+		return 0, werr
+	}
+	if err != nil {
+		return 0, os.NewSyscallError("tee", err)
+	}
+	return moved, nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+)
+
+func TestRawConn(t *testing.T) {
+	ar, aw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+	defer aw.Close()
+	br, bw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer br.Close()
+	defer bw.Close()
+	src := rawConn(t, ar)
+	dst := rawConn(t, bw)
+
+	if _, err := aw.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := zerocopy.TeeRawConn(dst, src, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRawConnRead(t, br, "hello"[:n])
+	n, err = zerocopy.SpliceRawConn(dst, src, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRawConnRead(t, br, "hello"[:n])
+
+	// The source is empty now, so the next call waits for data.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		aw.Write([]byte("world"))
+		aw.Close()
+	}()
+	n, err = zerocopy.SpliceRawConn(dst, src, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRawConnRead(t, br, "world"[:n])
+	n, err = zerocopy.SpliceRawConn(dst, src, 100, 0)
+	if n != 0 || err != nil {
+		t.Fatalf("at EOF: got (%d, %v), want (0, <nil>)", n, err)
+	}
+}
+
+func TestRawConnDeadline(t *testing.T) {
+	ar, aw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+	defer aw.Close()
+	br, bw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer br.Close()
+	defer bw.Close()
+
+	ar.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = zerocopy.TeeRawConn(rawConn(t, bw), rawConn(t, ar), 100)
+	if !os.IsTimeout(err) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+}
+
+func rawConn(t *testing.T, f *os.File) syscall.RawConn {
+	t.Helper()
+	rc, err := f.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc
+}
+
+func expectRawConnRead(t *testing.T, f *os.File, want string) {
+	t.Helper()
+	b := make([]byte, len(want))
+	if _, err := f.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != want {
+		t.Fatalf("got %q, want %q", b, want)
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package zerocopy
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errRawConnNotSupported = errors.New("zerocopy: splicing raw connections not supported on this platform")
+
+func spliceRawConn(dst, src syscall.RawConn, max int, flags int) (int64, error) {
+	return 0, errRawConnNotSupported
+}
+
+func teeRawConn(dst, src syscall.RawConn, max int) (int64, error) {
+	return 0, errRawConnNotSupported
+}
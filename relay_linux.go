@@ -0,0 +1,120 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"syscall"
+)
+
+// A relayChunk is the result of filling a pipe from the source of a relay.
+type relayChunk struct {
+	p        *Pipe
+	n        int   // bytes in p; 0 at EOF
+	fallback bool  // src does not support splice(2)
+	err      error // error reading from src
+}
+
+// relay moves data from the file descriptor rrc refers to, to dst, whose
+// file descriptor wrc refers to, through pipes. A separate goroutine fills
+// the pipes from rrc, and hands them to the calling
+// goroutine, which empties them into dst, in order, so the source can be
+// read ahead of the destination by up to the capacity of the pipes. The
+// pipes are internal, and each is only ever used by one goroutine at a
+// time, so they may be waited for without the precautions described at
+// the top of zerocopy_linux.go.
+//
+// If file is true, rrc refers to a regular file, and each splice(2) call
+// which reads from it holds a slot from SetMaxFileIO.
+//
+// If dst does not support splice(2), relay copies the data through
+// userspace instead. If src does not, relay returns fallback == true once
+// dst has received everything read so far, and the caller must copy the
+// rest.
+//
+// If writing to dst fails, relay calls interrupt, if it is not nil, to
+// unblock a wait for src, and waits for the reader goroutine to exit.
+// The chunks which were read but not written are returned as unsent,
+// with n adjusted to the number of bytes left in the pipe.
+func relay(dst io.Writer, wrc, rrc syscall.RawConn, file bool, pipes []*Pipe, interrupt func()) (moved int64, unsent []relayChunk, fallback bool, err error) {
+	empty := make(chan *Pipe, len(pipes))
+	full := make(chan relayChunk, len(pipes))
+	done := make(chan struct{})
+	for _, p := range pipes {
+		empty <- p
+	}
+	go func() {
+		defer close(full)
+		for {
+			var p *Pipe
+			select {
+			case p = <-empty:
+			case <-done:
+				return
+			}
+			max, err := p.BufferSize()
+			if err != nil || max > maxSpliceSize {
+				max = maxSpliceSize
+			}
+			release := acquireFileIOIf(file)
+			n, fallback, err := spliceDrain(p, rrc, max)
+			release()
+			full <- relayChunk{p: p, n: n, fallback: fallback, err: err}
+			if n == 0 || fallback || err != nil {
+				return
+			}
+		}
+	}()
+
+	generic := false // dst does not support splice(2)
+	for c := range full {
+		if c.err != nil {
+			err = c.err
+			break
+		}
+		if c.fallback {
+			fallback = true
+			break
+		}
+		if c.n == 0 {
+			break
+		}
+		var n int
+		if !generic {
+			var werr error
+			n, generic, werr = splicePump(wrc, c.p, c.n)
+			moved += int64(n)
+			if werr != nil {
+				err = werr
+				c.n -= n
+				unsent = append(unsent, c)
+				break
+			}
+		}
+		if generic && n < c.n {
+			n1, werr := io.CopyN(dst, c.p.r, int64(c.n-n))
+			moved += n1
+			if werr != nil {
+				err = werr
+				c.n -= n + int(n1)
+				unsent = append(unsent, c)
+				break
+			}
+		}
+		empty <- c.p
+	}
+	close(done)
+	if err != nil && interrupt != nil {
+		interrupt()
+	}
+	// Once full is closed, the reader is gone.
+	for c := range full {
+		if c.err == nil && !c.fallback && c.n > 0 {
+			unsent = append(unsent, c)
+		}
+	}
+	return moved, unsent, fallback, err
+}
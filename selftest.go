@@ -0,0 +1,306 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+)
+
+// selfTestSize is the amount of data each SelfTest check moves.
+const selfTestSize = 4 << 20
+
+// A SelfTestResult is the outcome of one of the checks SelfTest runs.
+type SelfTestResult struct {
+	// Name describes the check, for example "Transfer tcp to tcp".
+	Name string
+
+	// Mechanism names the mechanism package zerocopy chose for the
+	// check, such as "splice", or "generic" if it copies through
+	// userspace. As with Probe, the kernel may still refuse the
+	// mechanism at run time, in which case the check succeeds, but
+	// its throughput is usually lower.
+	Mechanism string
+
+	// Bytes is the number of bytes the check moved.
+	Bytes int64
+
+	// Duration is the time it took to move them.
+	Duration time.Duration
+
+	// Err is the reason the check failed, or nil if it succeeded.
+	Err error
+}
+
+// String formats r as a line of a report.
+func (r SelfTestResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: %s: FAIL: %v", r.Name, r.Mechanism, r.Err)
+	}
+	mbps := float64(r.Bytes) / 1e6 / r.Duration.Seconds()
+	return fmt.Sprintf("%s: %s: %d bytes in %v (%.0f MB/s)", r.Name, r.Mechanism, r.Bytes, r.Duration, mbps)
+}
+
+// SelfTest runs quick end-to-end checks of the mechanisms package zerocopy
+// uses, on the running system, and reports the results. It is meant for
+// support diagnostics, for example behind a command line flag:
+//
+//	for _, r := range zerocopy.SelfTest("") {
+//		fmt.Println(r)
+//	}
+//
+// SelfTest moves a few megabytes of data using Transfer between TCP
+// connections, using SendFile from a file to a TCP connection, through a
+// Pipe with a tee to a second Pipe, and using CopyFileRange between files,
+// and checks that the data arrives intact. The connections use the
+// loopback interface. The files are created in dir, and removed when
+// SelfTest returns; if dir is empty, the default directory for temporary
+// files is used. Since support for copy_file_range(2) depends on the file
+// system, dir should be on the file system of interest.
+func SelfTest(dir string) []SelfTestResult {
+	payload := make([]byte, selfTestSize)
+	if _, err := rand.Read(payload); err != nil {
+		return []SelfTestResult{{Name: "setup", Err: err}}
+	}
+	return []SelfTestResult{
+		selfTestTransfer(payload),
+		selfTestSendFile(dir, payload),
+		selfTestTee(payload),
+		selfTestCopyFileRange(dir, payload),
+	}
+}
+
+var errSelfTestCorrupt = errors.New("data corrupted in transit")
+
+func selfTestTransfer(payload []byte) SelfTestResult {
+	res := SelfTestResult{Name: "Transfer tcp to tcp"}
+	srcw, src, err := selfTestConns()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer srcw.Close()
+	defer src.Close()
+	dst, dstr, err := selfTestConns()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer dst.Close()
+	defer dstr.Close()
+	res.Mechanism = selfTestMechanism(dst, src)
+
+	go func() {
+		srcw.Write(payload)
+		srcw.Close()
+	}()
+	got := selfTestReadAll(dstr)
+	start := time.Now()
+	res.Bytes, res.Err = Transfer(dst, src)
+	dst.CloseWrite()
+	res.check(payload, <-got)
+	res.Duration = time.Since(start)
+	return res
+}
+
+func selfTestSendFile(dir string, payload []byte) SelfTestResult {
+	res := SelfTestResult{Name: "SendFile file to tcp", Mechanism: sendFileMechanism}
+	src, err := selfTestFile(dir, payload)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+	dst, dstr, err := selfTestConns()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer dst.Close()
+	defer dstr.Close()
+
+	got := selfTestReadAll(dstr)
+	start := time.Now()
+	res.Bytes, res.Err = SendFile(dst, src, 0, int64(len(payload)))
+	dst.CloseWrite()
+	res.check(payload, <-got)
+	res.Duration = time.Since(start)
+	return res
+}
+
+func selfTestTee(payload []byte) SelfTestResult {
+	res := SelfTestResult{Name: "Tee pipe to pipe", Mechanism: teeMechanism()}
+	srcw, src, err := selfTestConns()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer srcw.Close()
+	defer src.Close()
+	dst, dstr, err := selfTestConns()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer dst.Close()
+	defer dstr.Close()
+	p, err := NewPipe()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer p.close()
+	mirror, err := NewPipe()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer mirror.close()
+	p.Tee(mirror)
+	p.SetTeeCloseWrite(true)
+
+	go func() {
+		srcw.Write(payload)
+		srcw.Close()
+	}()
+	go func() {
+		p.ReadFrom(src)
+		p.closeWrite()
+	}()
+	got := selfTestReadAll(dstr)
+	mirrored := selfTestReadAll(mirror)
+	start := time.Now()
+	res.Bytes, res.Err = p.WriteTo(dst)
+	if res.Err != nil {
+		// Unblock the readers of the mirror.
+		mirror.close()
+	}
+	dst.CloseWrite()
+	res.check(payload, <-got)
+	res.check(payload, <-mirrored)
+	res.Duration = time.Since(start)
+	return res
+}
+
+func selfTestCopyFileRange(dir string, payload []byte) SelfTestResult {
+	res := SelfTestResult{Name: "CopyFileRange file to file"}
+	src, err := selfTestFile(dir, payload)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+	dst, err := selfTestFile(dir, nil)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+	res.Mechanism = selfTestMechanism(dst, src)
+
+	start := time.Now()
+	res.Bytes, res.Err = CopyFileRange(dst, src, -1)
+	res.Duration = time.Since(start)
+	got, err := ioutil.ReadFile(dst.Name())
+	res.check(payload, selfTestRead{got, err})
+	return res
+}
+
+// check records the outcome of a check which should have produced want,
+// unless it already failed.
+func (r *SelfTestResult) check(want []byte, got selfTestRead) {
+	switch {
+	case r.Err != nil:
+	case got.err != nil:
+		r.Err = got.err
+	case !bytes.Equal(got.b, want):
+		r.Err = errSelfTestCorrupt
+	}
+}
+
+// selfTestMechanism returns the name of the strategy Probe reports for
+// dst and src.
+func selfTestMechanism(dst io.Writer, src io.Reader) string {
+	s, err := Probe(dst, src)
+	if err != nil {
+		return Strategy(0).String()
+	}
+	return s.String()
+}
+
+type selfTestRead struct {
+	b   []byte
+	err error
+}
+
+// selfTestReadAll reads r until EOF in a separate goroutine, and sends
+// the result on the returned channel.
+func selfTestReadAll(r io.Reader) <-chan selfTestRead {
+	ch := make(chan selfTestRead, 1)
+	go func() {
+		b, err := ioutil.ReadAll(r)
+		ch <- selfTestRead{b, err}
+	}()
+	return ch
+}
+
+// selfTestConns returns both ends of a TCP connection on the loopback
+// interface.
+func selfTestConns() (client, server *net.TCPConn, err error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ln.Close()
+	type accepted struct {
+		c   net.Conn
+		err error
+	}
+	ch := make(chan accepted, 1)
+	go func() {
+		c, err := ln.Accept()
+		ch <- accepted{c, err}
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+	a := <-ch
+	if a.err != nil {
+		c.Close()
+		return nil, nil, a.err
+	}
+	return c.(*net.TCPConn), a.c.(*net.TCPConn), nil
+}
+
+// selfTestFile creates a temporary file in dir, holding b.
+func selfTestFile(dir string, b []byte) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, "zerocopy-selftest")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestSelfTest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zerocopy-selftest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	results := zerocopy.SelfTest(dir)
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want 4", len(results))
+	}
+	for _, r := range results {
+		t.Log(r)
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Name, r.Err)
+		}
+		if r.Bytes != 4<<20 {
+			t.Errorf("%s: moved %d bytes, want %d", r.Name, r.Bytes, 4<<20)
+		}
+	}
+	if got := results[0].Mechanism; got != "splice" {
+		t.Errorf("transfer: got mechanism %q, want splice", got)
+	}
+	if got := results[1].Mechanism; got != "sendfile" {
+		t.Errorf("sendfile: got mechanism %q, want sendfile", got)
+	}
+	if infos, _ := ioutil.ReadDir(dir); len(infos) != 0 {
+		t.Errorf("SelfTest left %d files behind", len(infos))
+	}
+}
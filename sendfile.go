@@ -0,0 +1,114 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// SendFile transfers n bytes starting at offset off in src to dst. It does
+// not use or modify the file offset of src, so concurrent calls on the same
+// file are safe. SendFile stops early, without an error, if it reaches the
+// end of src.
+//
+// If dst implements syscall.Conn, SendFile tries to use sendfile(2) for the
+// data transfer. If that is not possible, SendFile falls back to a generic
+// copy. sendfile(2) is used on Linux, macOS, FreeBSD and DragonFly BSD.
+// On all but Linux, dst must be a stream socket. NetBSD has no
+// sendfile(2), so SendFile always uses the generic copy there.
+//
+// SendFile is meant for servers which respond to requests for byte ranges
+// of a backing file, such as network block device servers. Protocol
+// parsing is left to the caller.
+func SendFile(dst io.Writer, src *os.File, off, n int64) (int64, error) {
+	return sendFile(dst, src, off, n)
+}
+
+func sendFileGeneric(dst io.Writer, src *os.File, off, n int64) (int64, error) {
+	return copyFallback(dst, io.NewSectionReader(src, off, n))
+}
+
+// A Range is a contiguous extent of a file.
+type Range struct {
+	Off int64 // offset of the first byte
+	Len int64 // length of the extent, in bytes
+}
+
+// ServeFileRanges transfers the specified ranges of src to dst, in order,
+// as if by consecutive calls to SendFile, and returns the total number of
+// bytes transferred. Each range is sent using a single sendfile(2) call
+// if possible.
+//
+// Unlike SendFile, ServeFileRanges returns io.ErrUnexpectedEOF if one of
+// the ranges extends past the end of src, since callers usually announce
+// the lengths of the ranges to their peers ahead of time.
+func ServeFileRanges(dst io.Writer, src *os.File, ranges []Range) (int64, error) {
+	var total int64
+	for _, r := range ranges {
+		n, err := sendFile(dst, src, r.Off, r.Len)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < r.Len {
+			return total, io.ErrUnexpectedEOF
+		}
+	}
+	return total, nil
+}
+
+// SplitRange splits r into n contiguous parts of nearly equal length, for
+// use with SendFileParts. If r.Len is not a multiple of n, the first
+// r.Len % n parts are one byte longer than the others. SplitRange panics
+// if n is not positive.
+func SplitRange(r Range, n int) []Range {
+	if n <= 0 {
+		panic("zerocopy: SplitRange with non-positive part count")
+	}
+	parts := make([]Range, n)
+	size, rem := r.Len/int64(n), r.Len%int64(n)
+	off := r.Off
+	for i := range parts {
+		l := size
+		if int64(i) < rem {
+			l++
+		}
+		parts[i] = Range{Off: off, Len: l}
+		off += l
+	}
+	return parts
+}
+
+// SendFileParts sends parts[i] of src to dsts[i], for every i, concurrently,
+// as if by ServeFileRanges. It waits for all the transfers to complete, and
+// returns the first error encountered, if any.
+//
+// SendFileParts is meant for parallel multipart uploads of large files,
+// where each part is sent on its own connection.
+func SendFileParts(dsts []io.Writer, src *os.File, parts []Range) error {
+	if len(dsts) != len(parts) {
+		return errors.New("zerocopy: SendFileParts: mismatched destination and part counts")
+	}
+	errs := make([]error, len(parts))
+	var wg sync.WaitGroup
+	wg.Add(len(parts))
+	for i := range parts {
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = ServeFileRanges(dsts[i], src, parts[i:i+1])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
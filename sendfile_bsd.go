@@ -0,0 +1,32 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd
+
+package zerocopy
+
+import "golang.org/x/sys/unix"
+
+// maxSendFileSize is the maximum number of bytes we ask sendfile(2) to
+// send in one call, so that a single call does not hold a file I/O slot
+// for too long.
+const maxSendFileSize = 4 << 20
+
+// errSendFileNotSupported is ENOTSUP, which sendfile(2) reports if the
+// file system of the source does not support it. On macOS and the BSDs,
+// it is distinct from EOPNOTSUPP.
+const errSendFileNotSupported = unix.ENOTSUP
+
+// sendfile calls sendfile(2) on the specified range. On macOS and the
+// BSDs, if the socket is non-blocking, sendfile(2) may send part of the
+// range and then fail with EAGAIN, so written is meaningful even if err
+// is not nil.
+func sendfile(wfd, rfd uintptr, off, n int64) (int, error) {
+	max := maxSendFileSize
+	if int64(max) > n {
+		max = int(n)
+	}
+	return unix.Sendfile(int(wfd), int(rfd), &off, max)
+}
@@ -0,0 +1,21 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import "golang.org/x/sys/unix"
+
+// errSendFileNotSupported is ENOTSUP, which is EOPNOTSUPP on Linux, and
+// is therefore handled as such.
+const errSendFileNotSupported = unix.EOPNOTSUPP
+
+// sendfile calls sendfile(2) on the specified range.
+func sendfile(wfd, rfd uintptr, off, n int64) (int, error) {
+	max := maxSpliceSize
+	if int64(max) > n {
+		max = int(n)
+	}
+	return unix.Sendfile(int(wfd), int(rfd), &off, max)
+}
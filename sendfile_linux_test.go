@@ -0,0 +1,266 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+)
+
+func TestSendFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	f := newSendFileTestFile(t, content)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	tests := []struct {
+		name    string
+		off, n  int64
+		wantLen int64
+	}{
+		{"all", 0, int64(len(content)), int64(len(content))},
+		{"middle", 1234, 56789, 56789},
+		{"pastEOF", int64(len(content)) - 10, 100, 10},
+		{"atEOF", int64(len(content)), 100, 0},
+	}
+	for _, tt := range tests {
+		want := content[tt.off : tt.off+tt.wantLen]
+		t.Run(tt.name+"/socket", func(t *testing.T) {
+			client, server, err := transferTestSocketPair("unix")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			errc := make(chan error, 1)
+			go func() {
+				n, err := zerocopy.SendFile(server, f, tt.off, tt.n)
+				if err == nil && n != tt.wantLen {
+					err = fmt.Errorf("sent %d bytes, want %d", n, tt.wantLen)
+				}
+				server.Close()
+				errc <- err
+			}()
+			got, err := ioutil.ReadAll(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("got %d bytes, want %d, or content mismatch", len(got), len(want))
+			}
+		})
+		t.Run(tt.name+"/generic", func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := zerocopy.SendFile(&buf, f, tt.off, tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.wantLen || !bytes.Equal(buf.Bytes(), want) {
+				t.Fatalf("got %d bytes, want %d, or content mismatch", n, len(want))
+			}
+		})
+	}
+
+	// The file offset must not have moved.
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != 0 {
+		t.Fatalf("file offset is %d, want 0", off)
+	}
+}
+
+func newSendFileTestFile(t *testing.T, content []byte) *os.File {
+	t.Helper()
+	f, err := ioutil.TempFile("", "zerocopy-sendfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestServeFileRanges(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	f := newSendFileTestFile(t, content)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	ranges := []zerocopy.Range{
+		{Off: 500000, Len: 100000},
+		{Off: 0, Len: 10},
+		{Off: 10, Len: 0},
+		{Off: 123456, Len: 654321},
+	}
+	var want []byte
+	for _, r := range ranges {
+		want = append(want, content[r.Off:r.Off+r.Len]...)
+	}
+
+	client, server, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := zerocopy.ServeFileRanges(server, f, ranges)
+		server.Close()
+		errc <- err
+	}()
+	got, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d, or content mismatch", len(got), len(want))
+	}
+
+	short := []zerocopy.Range{{Off: int64(len(content)) - 5, Len: 10}}
+	n, err := zerocopy.ServeFileRanges(ioutil.Discard, f, short)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("got error %v, want io.ErrUnexpectedEOF", err)
+	}
+	if n != 5 {
+		t.Fatalf("sent %d bytes, want 5", n)
+	}
+}
+
+func TestSplitRange(t *testing.T) {
+	parts := zerocopy.SplitRange(zerocopy.Range{Off: 10, Len: 11}, 3)
+	want := []zerocopy.Range{{10, 4}, {14, 4}, {18, 3}}
+	if len(parts) != len(want) {
+		t.Fatalf("got %v, want %v", parts, want)
+	}
+	for i := range parts {
+		if parts[i] != want[i] {
+			t.Fatalf("got %v, want %v", parts, want)
+		}
+	}
+}
+
+func TestSendFileParts(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100001)
+	f := newSendFileTestFile(t, content)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	const n = 4
+	parts := zerocopy.SplitRange(zerocopy.Range{Len: int64(len(content))}, n)
+	dsts := make([]io.Writer, n)
+	results := make([]chan []byte, n)
+	for i := 0; i < n; i++ {
+		client, server, err := transferTestSocketPair("tcp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		defer server.Close()
+		dsts[i] = server
+		results[i] = make(chan []byte, 1)
+		go func(i int) {
+			got, _ := ioutil.ReadAll(client)
+			results[i] <- got
+		}(i)
+	}
+	if err := zerocopy.SendFileParts(dsts, f, parts); err != nil {
+		t.Fatal(err)
+	}
+	var all []byte
+	for i := 0; i < n; i++ {
+		dsts[i].(io.Closer).Close()
+		all = append(all, <-results[i]...)
+	}
+	if !bytes.Equal(all, content) {
+		t.Fatalf("got %d bytes, want %d, or content mismatch", len(all), len(content))
+	}
+}
+
+func TestSendFilePartsMaxFileIO(t *testing.T) {
+	zerocopy.SetMaxFileIO(1)
+	defer zerocopy.SetMaxFileIO(0)
+	TestSendFileParts(t)
+}
+
+func TestSendFileMaxFileIOSlowClient(t *testing.T) {
+	zerocopy.SetMaxFileIO(1)
+	defer zerocopy.SetMaxFileIO(0)
+
+	content := bytes.Repeat([]byte("0123456789"), 1000000)
+	f := newSendFileTestFile(t, content)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// The first call fills the socket buffers, and waits for the peer,
+	// which never reads. It must not hold the only slot meanwhile.
+	client1, server1, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client1.Close()
+	errc1 := make(chan error, 1)
+	go func() {
+		_, err := zerocopy.SendFile(server1, f, 0, int64(len(content)))
+		errc1 <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The second call has a peer which reads, so it completes.
+	client2, server2, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+	errc2 := make(chan error, 1)
+	go func() {
+		_, err := zerocopy.SendFile(server2, f, 0, int64(len(content)))
+		server2.Close()
+		errc2 <- err
+	}()
+	readc := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(ioutil.Discard, client2)
+		readc <- n
+	}()
+	select {
+	case err := <-errc2:
+		if err != nil {
+			t.Error(err)
+		}
+		if n := <-readc; n != int64(len(content)) {
+			t.Errorf("read %d bytes, want %d", n, len(content))
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("SendFile starved by a slow client holding the file I/O slot")
+	}
+
+	server1.Close()
+	<-errc1
+}
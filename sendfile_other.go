@@ -0,0 +1,20 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !darwin,!dragonfly,!freebsd,!linux
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+)
+
+// sendFileMechanism names the mechanism SendFile uses for sockets.
+const sendFileMechanism = "generic"
+
+func sendFile(dst io.Writer, src *os.File, off, n int64) (int64, error) {
+	return sendFileGeneric(dst, src, off, n)
+}
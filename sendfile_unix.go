@@ -0,0 +1,120 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sendFileMechanism names the mechanism SendFile uses for sockets.
+const sendFileMechanism = "sendfile"
+
+func sendFile(dst io.Writer, src *os.File, off, n int64) (int64, error) {
+	sc, ok := dst.(syscall.Conn)
+	if !ok {
+		return sendFileGeneric(dst, src, off, n)
+	}
+	wrc, err := sc.SyscallConn()
+	if err != nil {
+		return sendFileGeneric(dst, src, off, n)
+	}
+	rrc, err := src.SyscallConn()
+	if err != nil {
+		return sendFileGeneric(dst, src, off, n)
+	}
+
+	// Only one of the file descriptors can ever block: src is a file,
+	// so it is always ready, and we only wait for dst. We must not hold
+	// a reference to src while waiting, however, so we use Control for
+	// the read side, and return from it before waiting for dst. See the
+	// comment at the top of zerocopy_linux.go.
+	//
+	// The file I/O slot is held for each sendfile(2) call only, never
+	// while waiting for dst, so that a slow client cannot keep it. If no
+	// slot is free, we return from wrc.Write, wait for one without
+	// holding any references, and try again.
+	var (
+		moved    int64
+		operr    error
+		rrcerr   error
+		wrcerr   error
+		fallback = false
+		release  func() // non-nil while a slot is held
+	)
+	for {
+		needSlot := false
+		wrcerr = wrc.Write(func(wfd uintptr) bool {
+			for moved < n {
+				if release == nil {
+					var ok bool
+					if release, ok = tryAcquireFileIO(); !ok {
+						needSlot = true
+						return true
+					}
+				}
+				var written int
+				rrcerr = rrc.Control(func(rfd uintptr) {
+					written, operr = sendfile(wfd, rfd, off+moved, n-moved)
+				})
+				release()
+				release = nil
+				if rrcerr != nil {
+					return true
+				}
+				if written > 0 {
+					moved += int64(written)
+				}
+				switch {
+				case operr == unix.EAGAIN:
+					return false
+				case operr == unix.EINVAL || operr == unix.ENOSYS || operr == unix.ENOTSOCK || operr == unix.EOPNOTSUPP || operr == errSendFileNotSupported:
+					// On macOS and the BSDs, dst must be a
+					// stream socket (ENOTSOCK, EOPNOTSUPP), src
+					// a regular file (EINVAL, EOPNOTSUPP), and
+					// the file system of src must support
+					// sendfile(2) (ENOTSUP).
+					fallback = moved == 0
+					if fallback {
+						operr = nil
+					} else {
+						operr = os.NewSyscallError("sendfile", operr)
+					}
+					return true
+				case operr != nil:
+					operr = os.NewSyscallError("sendfile", operr)
+					return true
+				case written == 0:
+					// End of file.
+					return true
+				}
+			}
+			return true
+		})
+		if !needSlot || wrcerr != nil {
+			break
+		}
+		release = acquireFileIO()
+	}
+	if release != nil {
+		release()
+	}
+	if fallback {
+		return sendFileGeneric(dst, src, off, n)
+	}
+	if wrcerr != nil {
+		return moved, wrcerr
+	}
+	if rrcerr != nil {
+		return moved, rrcerr
+	}
+	return moved, operr
+}
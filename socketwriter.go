@@ -0,0 +1,83 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"sync"
+	"syscall"
+)
+
+// minZeroCopyWrite is the size below which a SocketWriter uses regular
+// writes. Setting up page pinning and completion notifications costs more
+// than copying small buffers.
+const minZeroCopyWrite = 16 << 10
+
+// A SocketWriter writes to a socket from Go memory without copying the
+// data into the kernel, using MSG_ZEROCOPY. It complements splice(2),
+// which only helps if the data comes from a file descriptor.
+//
+// The kernel sends the data straight from the caller's buffer, and
+// reports when it is done with it on the error queue of the socket.
+// Write waits for these completion notifications before returning, so
+// the buffer may be reused as soon as Write returns. For TCP, this means
+// that Write returns once the peer has acknowledged the data, rather than
+// once the data is in the socket send buffer. MSG_ZEROCOPY therefore pays
+// off for large writes on high-bandwidth connections, and writes smaller
+// than 16 KiB are made as regular writes.
+//
+// If the kernel reports that it copied the data anyway, as it does for
+// loopback connections, and for devices which cannot transmit from
+// arbitrary memory, the SocketWriter uses regular writes from then on.
+//
+// The kernel signals completion notifications the same way as socket
+// errors. Depending on the version of the Go runtime, a concurrent Read
+// from the connection which is waiting for data at the time may fail
+// spuriously, so a SocketWriter is best used on connections which are
+// read from and written to in turns, or only written to.
+//
+// MSG_ZEROCOPY is only supported for TCP and UDP sockets on Linux 4.14 and
+// later. In all other cases, a SocketWriter makes regular writes.
+type SocketWriter struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	rc      syscall.RawConn // nil if not using MSG_ZEROCOPY
+	pending uint32          // sends not yet reported complete
+	copied  bool            // the kernel copied data sent with MSG_ZEROCOPY
+}
+
+// NewSocketWriter creates a SocketWriter which writes to conn. If conn is
+// a TCP or UDP socket, NewSocketWriter enables SO_ZEROCOPY on it. Reads
+// from conn are not affected.
+func NewSocketWriter(conn io.Writer) *SocketWriter {
+	s := &SocketWriter{w: conn}
+	s.init()
+	return s
+}
+
+// Write writes b to the underlying connection, and waits until the
+// kernel is done with b.
+//
+// If Write returns an error, the kernel may still hold on to parts of b,
+// and transmit them later. In that case, b should not be modified until
+// the connection is closed.
+func (s *SocketWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rc == nil || len(b) < minZeroCopyWrite {
+		return s.w.Write(b)
+	}
+	return s.writeZeroCopy(b)
+}
+
+// UsesZeroCopy reports whether the next large write uses MSG_ZEROCOPY.
+func (s *SocketWriter) UsesZeroCopy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rc != nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// init enables SO_ZEROCOPY on the underlying socket, if possible.
+func (s *SocketWriter) init() {
+	sc, ok := s.w.(syscall.Conn)
+	if !ok {
+		return
+	}
+	if _, udp := s.w.(*net.UDPConn); !zeroCopySendAllowed(udp) {
+		return
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ZEROCOPY, 1)
+	})
+	if err != nil || serr != nil {
+		return
+	}
+	s.rc = rc
+}
+
+// writeZeroCopy writes b using MSG_ZEROCOPY, and waits for the kernel to
+// report that it is done with b. It must be called with s.mu held.
+func (s *SocketWriter) writeZeroCopy(b []byte) (int, error) {
+	var (
+		written int
+		err     error
+	)
+	for written < len(b) && err == nil {
+		var (
+			n    int
+			serr error
+		)
+		err = s.rc.Write(func(fd uintptr) bool {
+			n, serr = unix.SendmsgN(int(fd), b[written:], nil, nil, unix.MSG_ZEROCOPY)
+			return serr != unix.EAGAIN
+		})
+		switch {
+		case err != nil:
+		case serr == unix.ENOBUFS && s.pending > 0:
+			// The socket ran out of memory for tracking sends.
+			// Wait for the outstanding ones, and try again.
+			err = s.wait()
+		case serr == unix.ENOBUFS:
+			n, err = s.w.Write(b[written:])
+			written += n
+		case serr != nil:
+			err = os.NewSyscallError("sendmsg", serr)
+		default:
+			written += n
+			s.pending++
+		}
+	}
+	// Even if sending failed, wait for the sends which did go out, so
+	// that b is no longer in use when we return, if at all possible.
+	if werr := s.wait(); err == nil {
+		err = werr
+	}
+	if s.copied {
+		s.rc = nil
+	}
+	return written, err
+}
+
+// wait waits until all sends are reported complete.
+func (s *SocketWriter) wait() error {
+	if s.pending == 0 {
+		return nil
+	}
+	// Notifications are queued on the error queue of the socket, which
+	// the runtime network poller reports as both readable and writable.
+	// Wait for the latter, since a reader may be waiting for the former.
+	var rerr error
+	err := s.rc.Write(func(fd uintptr) bool {
+		for s.pending > 0 {
+			rerr = s.readCompletions(int(fd))
+			if rerr == unix.EAGAIN {
+				rerr = nil
+				return false
+			}
+			if rerr != nil {
+				return true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if rerr != nil {
+		return os.NewSyscallError("recvmsg", rerr)
+	}
+	return nil
+}
+
+// readCompletions reads one message from the error queue of the socket,
+// and accounts for the completion notifications it carries.
+func (s *SocketWriter) readCompletions(fd int) error {
+	var (
+		p   [1]byte
+		oob [128]byte
+	)
+	_, oobn, _, _, err := unix.Recvmsg(fd, p[:], oob[:], unix.MSG_ERRQUEUE)
+	if err != nil {
+		return err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		ipv4 := m.Header.Level == unix.SOL_IP && m.Header.Type == unix.IP_RECVERR
+		ipv6 := m.Header.Level == unix.SOL_IPV6 && m.Header.Type == unix.IPV6_RECVERR
+		if !ipv4 && !ipv6 {
+			continue
+		}
+		var ee unix.SockExtendedErr
+		eeb := (*[unsafe.Sizeof(ee)]byte)(unsafe.Pointer(&ee))
+		if copy(eeb[:], m.Data) < len(eeb) {
+			continue
+		}
+		if ee.Origin != unix.SO_EE_ORIGIN_ZEROCOPY || ee.Errno != 0 {
+			continue
+		}
+		// Notifications cover the range of sends [ee.Info, ee.Data].
+		s.pending -= ee.Data - ee.Info + 1
+		if ee.Code&unix.SO_EE_CODE_ZEROCOPY_COPIED != 0 {
+			s.copied = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestSocketWriter(t *testing.T) {
+	t.Run("TCP", func(t *testing.T) {
+		client, server, err := transferTestSocketPair("tcp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		sw := zerocopy.NewSocketWriter(server)
+		if !sw.UsesZeroCopy() {
+			t.Skip("MSG_ZEROCOPY not supported")
+		}
+		testSocketWriter(t, sw, server, client)
+
+		// The kernel copies data sent over loopback, so
+		// the SocketWriter stops using MSG_ZEROCOPY.
+		if sw.UsesZeroCopy() {
+			t.Errorf("still using MSG_ZEROCOPY after the kernel copied data")
+		}
+	})
+	t.Run("Unix", func(t *testing.T) {
+		client, server, err := transferTestSocketPair("unix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		sw := zerocopy.NewSocketWriter(server)
+		if sw.UsesZeroCopy() {
+			t.Errorf("using MSG_ZEROCOPY on a Unix socket")
+		}
+		testSocketWriter(t, sw, server, client)
+	})
+}
+
+func testSocketWriter(t *testing.T, sw *zerocopy.SocketWriter, server, client net.Conn) {
+	t.Helper()
+	received := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(client)
+		received <- b
+	}()
+	var want []byte
+	for _, size := range []int{1 << 20, 100, 256 << 10} {
+		b := make([]byte, size)
+		rand.Read(b)
+		n, err := sw.Write(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != size {
+			t.Fatalf("wrote %d bytes, want %d", n, size)
+		}
+		want = append(want, b...)
+	}
+	server.Close()
+	if got := <-received; !bytes.Equal(got, want) {
+		t.Errorf("received %d bytes, which do not match", len(got))
+	}
+}
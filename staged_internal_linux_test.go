@@ -0,0 +1,43 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStagedErrorShortPipe(t *testing.T) {
+	p, err := NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if _, err := p.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pipe holds fewer bytes than reported, and its write side is
+	// still open, so a blocking read would never return.
+	errWrite := errors.New("write failed")
+	done := make(chan error, 1)
+	go func() {
+		done <- stagedError(p, errWrite, 10)
+	}()
+	select {
+	case err := <-done:
+		serr, ok := err.(*StagedError)
+		if !ok {
+			t.Fatalf("got %v, want *StagedError", err)
+		}
+		if serr.Err != errWrite || serr.Staged != 10 || string(serr.Data) != "abc" {
+			t.Fatalf("got %+v, want errWrite, 10 bytes staged, and data %q", serr, "abc")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stagedError blocked on the pipe")
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import "sync/atomic"
+
+// PipeStats holds cumulative statistics for a Pipe. See Pipe.Stats.
+type PipeStats struct {
+	// BytesIn and BytesOut count the bytes written to the pipe, by
+	// Write and ReadFrom, and read from it, by Read, WriteTo and
+	// Salvage, by any means. Transfer through the pipe counts both
+	// ways.
+	BytesIn, BytesOut int64
+
+	// SplicedIn and SplicedOut count the part of BytesIn and BytesOut
+	// which was moved using splice(2).
+	SplicedIn, SplicedOut int64
+
+	// Teed counts the bytes mirrored to the tee target, whether by
+	// tee(2), or through userspace.
+	Teed int64
+
+	// Splices and Tees count the splice(2) and tee(2) system calls
+	// which moved data, or reached EOF.
+	Splices, Tees int64
+
+	// Waits counts the splice(2) and tee(2) calls which failed with
+	// EAGAIN, because a file descriptor was not ready.
+	Waits int64
+
+	// Fallbacks counts the ReadFrom and WriteTo calls which copied
+	// data through userspace, because splice(2) could not be used.
+	Fallbacks int64
+}
+
+// pipeCounters holds the counters behind PipeStats. All fields are
+// accessed atomically.
+type pipeCounters struct {
+	bytesIn, bytesOut     int64
+	splicedIn, splicedOut int64
+	teed                  int64
+	splices, tees         int64
+	waits                 int64
+	fallbacks             int64
+}
+
+// Stats returns cumulative statistics for p, which may be used for
+// per-stream accounting, without wrapping the endpoints of the pipe. The
+// counters are updated atomically, but independently of each other, so a
+// concurrent snapshot may be inconsistent, such as SplicedIn exceeding
+// BytesIn for a short while.
+func (p *Pipe) Stats() PipeStats {
+	c := &p.stats
+	return PipeStats{
+		BytesIn:    atomic.LoadInt64(&c.bytesIn),
+		BytesOut:   atomic.LoadInt64(&c.bytesOut),
+		SplicedIn:  atomic.LoadInt64(&c.splicedIn),
+		SplicedOut: atomic.LoadInt64(&c.splicedOut),
+		Teed:       atomic.LoadInt64(&c.teed),
+		Splices:    atomic.LoadInt64(&c.splices),
+		Tees:       atomic.LoadInt64(&c.tees),
+		Waits:      atomic.LoadInt64(&c.waits),
+		Fallbacks:  atomic.LoadInt64(&c.fallbacks),
+	}
+}
+
+// count adds n to the counter at c, one of the fields of p.stats.
+func count(c *int64, n int64) {
+	if n != 0 {
+		atomic.AddInt64(c, n)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import "golang.org/x/sys/unix"
+
+// countSplice records the result of a splice(2) call which moved n bytes
+// into p, or out of p if out is true.
+func (p *Pipe) countSplice(n int, err error, out bool) {
+	switch err {
+	case nil:
+		count(&p.stats.splices, 1)
+		if out {
+			count(&p.stats.splicedOut, int64(n))
+			count(&metrics.bytesSplice, int64(n))
+		} else {
+			count(&p.stats.splicedIn, int64(n))
+		}
+	case unix.EAGAIN:
+		count(&p.stats.waits, 1)
+	}
+}
+
+// countTee records the result of a tee(2) call which mirrored n bytes
+// from p to its tee target.
+func (p *Pipe) countTee(n int64, err error) {
+	switch err {
+	case nil:
+		count(&p.stats.tees, 1)
+		count(&p.stats.teed, n)
+	case unix.EAGAIN:
+		count(&p.stats.waits, 1)
+	}
+}
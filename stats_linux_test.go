@@ -0,0 +1,95 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestPipeStats(t *testing.T) {
+	t.Run("Splice", func(t *testing.T) {
+		content := make([]byte, 1<<20)
+		rand.Read(content)
+		src := newParitySource(t, content)
+		defer src.Close()
+		dst, received := newParitySink(t)
+
+		p, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		mirror, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mirror.Close()
+		p.Tee(mirror)
+		p.SetTeeCloseWrite(true)
+		go func() {
+			p.ReadFrom(src)
+			p.CloseWrite()
+		}()
+		go ioutil.ReadAll(mirror)
+		if _, err := p.WriteTo(dst); err != nil {
+			t.Fatal(err)
+		}
+		dst.Close()
+		if got := received(); !bytes.Equal(got, content) {
+			t.Fatalf("received %d bytes, which do not match", len(got))
+		}
+
+		st := p.Stats()
+		size := int64(len(content))
+		if st.BytesIn != size || st.SplicedIn != size {
+			t.Errorf("in: got %d bytes, %d spliced, want %d", st.BytesIn, st.SplicedIn, size)
+		}
+		if st.BytesOut != size || st.SplicedOut != size {
+			t.Errorf("out: got %d bytes, %d spliced, want %d", st.BytesOut, st.SplicedOut, size)
+		}
+		if st.Teed != size {
+			t.Errorf("teed %d bytes, want %d", st.Teed, size)
+		}
+		if st.Splices == 0 || st.Tees == 0 {
+			t.Errorf("got %d splices and %d tees", st.Splices, st.Tees)
+		}
+		if st.Fallbacks != 0 {
+			t.Errorf("got %d fallbacks", st.Fallbacks)
+		}
+	})
+	t.Run("Fallback", func(t *testing.T) {
+		p, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		var mirror bytes.Buffer
+		p.Tee(&mirror)
+		go func() {
+			p.Write([]byte("hello"))
+			p.CloseWrite()
+		}()
+		var dst bytes.Buffer
+		if _, err := p.WriteTo(&dst); err != nil {
+			t.Fatal(err)
+		}
+
+		want := zerocopy.PipeStats{
+			BytesIn:   5,
+			BytesOut:  5,
+			Teed:      5,
+			Fallbacks: 1,
+		}
+		if st := p.Stats(); st != want {
+			t.Errorf("got %+v, want %+v", st, want)
+		}
+	})
+}
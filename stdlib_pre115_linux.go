@@ -0,0 +1,12 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !go1.15
+
+package zerocopy
+
+// stdlibSplicesFromUnix is true if *net.TCPConn.ReadFrom splices from Unix
+// stream sockets, which it does since Go 1.15.
+const stdlibSplicesFromUnix = false
@@ -0,0 +1,40 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrStepNotSupported is returned by TransferStep if the data transfer
+// cannot be carried out without blocking. Callers may fall back to
+// Transfer, or to io.Copy, in a separate goroutine.
+var ErrStepNotSupported = errors.New("zerocopy: TransferStep not supported for the given source and destination")
+
+// TransferStep attempts one round of the data transfer from src to dst,
+// through p, without blocking. It is meant for frameworks which run their
+// own event loops, and schedule the rest of the transfer themselves.
+//
+// TransferStep returns the number of bytes written to dst. If wantRead is
+// true, the caller should call TransferStep again once src is readable.
+// If wantWrite is true, the caller should call TransferStep again once
+// dst is writable. If neither is set and err is nil, progress was made,
+// and the caller may call TransferStep again right away. TransferStep
+// returns io.EOF once src reaches EOF and p holds no more data.
+//
+// Data which was read from src, but which could not yet be written to dst,
+// is held in p, so the same source, destination and pipe must be used
+// for all steps of a transfer. p must not be used for anything else in
+// the meantime. TransferStep does not mirror data to a tee set on p.
+//
+// Both src and dst must implement syscall.Conn, and refer to file
+// descriptors in non-blocking mode, such as network connections. If
+// that is not the case, or on platforms other than Linux, TransferStep
+// returns ErrStepNotSupported.
+func (p *Pipe) TransferStep(dst io.Writer, src io.Reader) (n int64, wantRead, wantWrite bool, err error) {
+	return p.transferStep(dst, src)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// transferStep never waits for readiness: all system calls are issued
+// from within Control, so the rules laid out in zerocopy_linux.go are
+// trivially upheld.
+func (p *Pipe) transferStep(dst io.Writer, src io.Reader) (int64, bool, bool, error) {
+	srcrc, ok := stepConn(src)
+	if !ok {
+		return 0, false, false, ErrStepNotSupported
+	}
+	dstrc, ok := stepConn(dst)
+	if !ok {
+		return 0, false, false, ErrStepNotSupported
+	}
+	inpipe, err := p.buffered()
+	if err != nil {
+		return 0, false, false, err
+	}
+	if inpipe == 0 {
+		n, err := stepSplice(srcrc, p.wrc, maxSpliceSize)
+		p.countSplice(n, err, false)
+		count(&p.stats.bytesIn, int64(n))
+		if err == unix.EAGAIN {
+			return 0, true, false, nil
+		}
+		if err != nil {
+			return 0, false, false, err
+		}
+		if n == 0 {
+			return 0, false, false, io.EOF
+		}
+		inpipe = n
+	}
+	n, err := stepSplice(p.rrc, dstrc, inpipe)
+	p.countSplice(n, err, true)
+	count(&p.stats.bytesOut, int64(n))
+	if err == unix.EAGAIN {
+		return 0, false, true, nil
+	}
+	return int64(n), false, false, err
+}
+
+// buffered returns the number of bytes in the pipe.
+func (p *Pipe) buffered() (int, error) {
+	var (
+		n     int
+		ioerr error
+	)
+	err := p.rrc.Control(func(fd uintptr) {
+		n, ioerr = pipeBuffered(fd)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, ioerr
+}
+
+// stepConn returns the syscall.RawConn associated with v, if v refers to
+// a file descriptor TransferStep can operate on without blocking.
+func stepConn(v interface{}) (syscall.RawConn, bool) {
+	if isRegularFile(v) {
+		return nil, false
+	}
+	sc, ok := v.(syscall.Conn)
+	if !ok {
+		return nil, false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, false
+	}
+	return rc, true
+}
+
+// stepSplice splices at most max bytes from rrc to wrc. It returns
+// unix.EAGAIN unwrapped, if either side is not ready.
+func stepSplice(rrc, wrc syscall.RawConn, max int) (int, error) {
+	var (
+		n      int
+		serr   error
+		wrcerr error
+	)
+	rrcerr := rrc.Control(func(rfd uintptr) {
+		wrcerr = wrc.Control(func(wfd uintptr) {
+			n, serr = splice(rfd, wfd, max)
+		})
+	})
+	if rrcerr != nil {
+		return 0, rrcerr
+	}
+	if wrcerr != nil {
+		return 0, wrcerr
+	}
+	switch serr {
+	case nil, unix.EAGAIN:
+		return n, serr
+	case unix.EINVAL:
+		return 0, ErrStepNotSupported
+	default:
+		return 0, os.NewSyscallError("splice", serr)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+
+	"acln.ro/zerocopy"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestTransferStep(t *testing.T) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	upClient, upServer, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upClient.Close()
+	defer upServer.Close()
+	downClient, downServer, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer downClient.Close()
+	defer downServer.Close()
+
+	msg := make([]byte, 1<<20)
+	rand.Read(msg)
+	go func() {
+		upClient.Write(msg)
+		upClient.(*net.TCPConn).CloseWrite()
+	}()
+	gotc := make(chan []byte)
+	go func() {
+		got, _ := ioutil.ReadAll(downClient)
+		gotc <- got
+	}()
+
+	// A minimal event loop.
+	var total int64
+	for {
+		n, wantRead, wantWrite, err := p.TransferStep(downServer, upServer)
+		total += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case wantRead:
+			pollConn(t, upServer.(syscall.Conn), unix.POLLIN)
+		case wantWrite:
+			pollConn(t, downServer.(syscall.Conn), unix.POLLOUT)
+		}
+	}
+	downServer.(*net.TCPConn).CloseWrite()
+
+	if total != int64(len(msg)) {
+		t.Errorf("TransferStep moved %d bytes, want %d", total, len(msg))
+	}
+	if got := <-gotc; !bytes.Equal(got, msg) {
+		t.Fatalf("got %d bytes, want %d bytes, matching", len(got), len(msg))
+	}
+}
+
+func TestTransferStepNotSupported(t *testing.T) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	_, _, _, err = p.TransferStep(ioutil.Discard, strings.NewReader("hello"))
+	if err != zerocopy.ErrStepNotSupported {
+		t.Fatalf("got %v, want ErrStepNotSupported", err)
+	}
+}
+
+func pollConn(t *testing.T, c syscall.Conn, events int16) {
+	t.Helper()
+	rc, err := c.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var perr error
+	err = rc.Control(func(fd uintptr) {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: events}}
+		for {
+			_, perr = unix.Poll(fds, 1000)
+			if perr != unix.EINTR {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perr != nil {
+		t.Fatal(perr)
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package zerocopy
+
+import "io"
+
+func (p *Pipe) transferStep(dst io.Writer, src io.Reader) (int64, bool, bool, error) {
+	return 0, false, false, ErrStepNotSupported
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrFallbackRequired is returned by TransferStrict, and by the ReadFrom
+// and WriteTo methods of a Pipe in strict mode, if moving the data would
+// require a generic copy through userspace.
+//
+// Transfers involving message sockets return ErrDatagramFallback, which
+// wraps ErrFallbackRequired. errors.Is(err, ErrFallbackRequired) reports
+// true for both errors, but err == ErrFallbackRequired does not match
+// ErrDatagramFallback.
+var ErrFallbackRequired = errors.New("zerocopy: transfer would fall back to a generic copy")
+
+// ErrDatagramFallback is returned in strict mode if dst or src is a
+// socket which preserves message boundaries, such as the UDP socket
+// underneath a QUIC or DTLS connection, or a "unixgram" or "unixpacket"
+// socket. splice(2) cannot preserve message boundaries, and protocols
+// such as QUIC encrypt data in userspace, so the kernel has no way to move
+// it. Senders of large datagrams should use a SocketWriter, which avoids
+// copies using MSG_ZEROCOPY, instead.
+//
+// The Unwrap method of ErrDatagramFallback returns ErrFallbackRequired,
+// so code which detects refused fallbacks using errors.Is matches both.
+var ErrDatagramFallback error = &fallbackRequiredError{
+	msg: "zerocopy: datagram sockets cannot be spliced; see SocketWriter",
+}
+
+// fallbackRequiredError is a more specific form of ErrFallbackRequired.
+type fallbackRequiredError struct {
+	msg string
+}
+
+func (e *fallbackRequiredError) Error() string { return e.msg }
+
+func (e *fallbackRequiredError) Unwrap() error { return ErrFallbackRequired }
+
+// SetStrict sets whether the ReadFrom and WriteTo methods of p refuse to
+// fall back to generic copies. In strict mode, instead of copying the data
+// through userspace, they return ErrFallbackRequired, along with the
+// number of bytes moved by the kernel up to that point. Read and Write are
+// not affected.
+//
+// SetStrict must be called before any calls to ReadFrom or WriteTo.
+func (p *Pipe) SetStrict(strict bool) {
+	p.strict = strict
+}
+
+// TransferStrict is like Transfer, but never degrades to a generic copy.
+// If the data cannot be moved by the kernel, TransferStrict returns
+// ErrFallbackRequired. This includes the case where src is found to
+// support splice(2) only after reading from it: the data staged in the
+// internal pipe is then reported by a *StagedError whose Err field is
+// ErrFallbackRequired, but it is not recovered, and Data is nil.
+//
+// Because the standard library silently falls back to generic copies,
+// TransferStrict does not delegate transfers to *net.TCPConn.ReadFrom.
+func TransferStrict(dst io.Writer, src io.Reader) (int64, error) {
+	return transferStrict(dst, src)
+}
+
+// isStrict reports whether p is in strict mode. p may be nil.
+func (p *Pipe) isStrict() bool {
+	return p != nil && p.strict
+}
+
+// copyGeneric is like copyFallback, unless p is in strict mode, in which
+// case it returns ErrFallbackRequired without copying anything. p may
+// be nil.
+func (p *Pipe) copyGeneric(dst io.Writer, src io.Reader) (int64, error) {
+	if p.isStrict() {
+		return 0, ErrFallbackRequired
+	}
+	return copyFallback(dst, src)
+}
+
+// copyGenericBuffer is like copyGeneric, but copies through buf, if it
+// is not nil.
+func (p *Pipe) copyGenericBuffer(dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	if p.isStrict() {
+		return 0, ErrFallbackRequired
+	}
+	return copyFallbackBuffer(dst, src, buf)
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestTransferStrict(t *testing.T) {
+	t.Run("Splice", testTransferStrictSplice)
+	t.Run("Fallback", testTransferStrictFallback)
+	t.Run("PumpFallback", testTransferStrictPumpFallback)
+}
+
+func testTransferStrictSplice(t *testing.T) {
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	src := newParitySource(t, content)
+	defer src.Close()
+	dst, received := newParitySink(t)
+
+	n, err := zerocopy.TransferStrict(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Errorf("transferred %d bytes, want %d", n, len(content))
+	}
+	if got := received(); !bytes.Equal(got, content) {
+		t.Errorf("received %d bytes, which do not match", len(got))
+	}
+}
+
+func testTransferStrictFallback(t *testing.T) {
+	var dst bytes.Buffer
+	n, err := zerocopy.TransferStrict(&dst, bytes.NewReader([]byte("hello")))
+	if err != zerocopy.ErrFallbackRequired {
+		t.Fatalf("got error %v, want ErrFallbackRequired", err)
+	}
+	if n != 0 || dst.Len() != 0 {
+		t.Errorf("transferred %d bytes (%d written), want 0", n, dst.Len())
+	}
+}
+
+func testTransferStrictPumpFallback(t *testing.T) {
+	content := make([]byte, 1<<16)
+	rand.Read(content)
+	src := newParitySource(t, content)
+	defer src.Close()
+
+	// See testParityPumpFallback. The data is read into the pipe
+	// before the destination turns out not to support splice(2).
+	f, err := ioutil.TempFile("", "zerocopy-strict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	dst, err := os.OpenFile(f.Name(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	n, err := zerocopy.TransferStrict(dst, src)
+	serr, ok := err.(*zerocopy.StagedError)
+	if !ok {
+		t.Fatalf("got error %v, want *StagedError", err)
+	}
+	if serr.Err != zerocopy.ErrFallbackRequired {
+		t.Errorf("got staged error %v, want ErrFallbackRequired", serr.Err)
+	}
+	if n != 0 || serr.Staged == 0 || serr.Data != nil {
+		t.Errorf("got n = %d, Staged = %d, %d bytes of Data; want 0, > 0, none", n, serr.Staged, len(serr.Data))
+	}
+}
+
+func TestPipeStrict(t *testing.T) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.SetStrict(true)
+
+	if _, err := p.ReadFrom(bytes.NewReader([]byte("hello"))); err != zerocopy.ErrFallbackRequired {
+		t.Fatalf("ReadFrom: got error %v, want ErrFallbackRequired", err)
+	}
+	msg := []byte("hello")
+	if _, err := p.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	var dst bytes.Buffer
+	if _, err := p.WriteTo(&dst); err != zerocopy.ErrFallbackRequired {
+		t.Fatalf("WriteTo: got error %v, want ErrFallbackRequired", err)
+	}
+	if dst.Len() != 0 {
+		t.Fatalf("WriteTo wrote %d bytes", dst.Len())
+	}
+
+	// The data is still in the pipe.
+	got := make([]byte, len(msg))
+	if _, err := p.Read(got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("read %q, want %q", got, msg)
+	}
+}
+
+func TestTransferStrictDatagram(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	var dst bytes.Buffer
+	n, err := zerocopy.TransferStrict(&dst, udp.(io.Reader))
+	if err != zerocopy.ErrDatagramFallback {
+		t.Fatalf("got error %v, want ErrDatagramFallback", err)
+	}
+	if n != 0 {
+		t.Errorf("transferred %d bytes, want 0", n)
+	}
+	uerr, ok := err.(interface{ Unwrap() error })
+	if !ok || uerr.Unwrap() != zerocopy.ErrFallbackRequired {
+		t.Errorf("ErrDatagramFallback does not unwrap to ErrFallbackRequired")
+	}
+}
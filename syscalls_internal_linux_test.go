@@ -0,0 +1,28 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import "testing"
+
+func TestParseKernelRelease(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want [2]int
+	}{
+		{"5.4.0-42-generic", [2]int{5, 4}},
+		{"4.14.253-207.474.amzn2.x86_64", [2]int{4, 14}},
+		{"6.1\x00\x00\x00", [2]int{6, 1}},
+		{"3.10", [2]int{3, 10}},
+		{"5", [2]int{}},
+		{"5.x", [2]int{}},
+		{"", [2]int{}},
+	}
+	for _, tt := range tests {
+		if got := parseKernelRelease([]byte(tt.rel)); got != tt.want {
+			t.Errorf("parseKernelRelease(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
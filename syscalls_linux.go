@@ -0,0 +1,145 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// This file is the one place where we find out what the kernel can do.
+// Code which needs a system call or kernel feature that might be missing
+// consults the functions below, rather than trying, and falling back on
+// failure, every time.
+
+// Some environments, most notably Android, run processes under seccomp
+// filters which make some of the system calls we use fail with ENOSYS or
+// EPERM, depending on the vendor kernel. The same errors come from old
+// kernels which lack a system call altogether. We find out once, by
+// calling each system call with invalid file descriptors: if the call is
+// allowed, the kernel reports EBADF, and moves no data.
+//
+// Filters which kill the process, rather than returning an error, cannot
+// be detected in this way, or at all.
+var (
+	syscallsOnce sync.Once
+
+	haveSplice        bool
+	haveTee           bool
+	haveCopyFileRange bool
+)
+
+func probeSyscalls() {
+	_, err := unix.Splice(-1, nil, -1, nil, 1, unix.SPLICE_F_NONBLOCK)
+	haveSplice = syscallAllowed(err)
+	_, err = unix.Tee(-1, -1, 1, unix.SPLICE_F_NONBLOCK)
+	haveTee = syscallAllowed(err)
+	_, err = unix.CopyFileRange(-1, nil, -1, nil, 1, 0)
+	haveCopyFileRange = syscallAllowed(err)
+}
+
+// syscallAllowed reports whether err, the result of a system call made
+// with invalid file descriptors, shows that the system call is available.
+func syscallAllowed(err error) bool {
+	return err != unix.ENOSYS && err != unix.EPERM
+}
+
+// spliceAllowed reports whether splice(2) is available to the process.
+func spliceAllowed() bool {
+	syscallsOnce.Do(probeSyscalls)
+	return haveSplice
+}
+
+// teeAllowed reports whether tee(2) is available to the process.
+func teeAllowed() bool {
+	syscallsOnce.Do(probeSyscalls)
+	return haveTee
+}
+
+// copyFileRangeAllowed reports whether copy_file_range(2) is available to
+// the process.
+func copyFileRangeAllowed() bool {
+	syscallsOnce.Do(probeSyscalls)
+	return haveCopyFileRange
+}
+
+// Other features depend on the version of the kernel rather than on the
+// environment, and probing for them directly would need particular kinds
+// of files or sockets, so we go by the release reported by uname(2).
+// Vendor kernels which backport features may support more than their
+// version suggests, in which case we miss out on an optimization, but
+// never fail. If the release cannot be parsed, we assume a recent kernel,
+// and rely on falling back at run time.
+var (
+	kernelOnce    sync.Once
+	kernelVersion [2]int // major, minor; zero if unknown
+)
+
+// kernelAtLeast reports whether the kernel is at least version
+// major.minor, or of an unknown version.
+func kernelAtLeast(major, minor int) bool {
+	kernelOnce.Do(func() {
+		var uts unix.Utsname
+		if err := unix.Uname(&uts); err == nil {
+			kernelVersion = parseKernelRelease(uts.Release[:])
+		}
+	})
+	v := kernelVersion
+	if v[0] == 0 {
+		return true
+	}
+	return v[0] > major || v[0] == major && v[1] >= minor
+}
+
+// parseKernelRelease parses the major and minor version numbers from a
+// kernel release string, such as "5.4.0-42-generic". It returns zeros if
+// rel is not of that form.
+func parseKernelRelease(rel []byte) [2]int {
+	if i := bytes.IndexByte(rel, 0); i >= 0 {
+		rel = rel[:i]
+	}
+	var v [2]int
+	for i := range v {
+		end := 0
+		for end < len(rel) && '0' <= rel[end] && rel[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(string(rel[:end]))
+		if err != nil {
+			return [2]int{}
+		}
+		v[i] = n
+		rel = rel[end:]
+		if i == 0 {
+			if len(rel) == 0 || rel[0] != '.' {
+				return [2]int{}
+			}
+			rel = rel[1:]
+		}
+	}
+	return v
+}
+
+// copyFileRangeCrossFS reports whether copy_file_range(2) may work between
+// files on different file systems, which it never does before Linux 5.3.
+// Since Linux 5.19, it only does between file systems of the same type,
+// which we do not check: callers still fall back on EXDEV.
+func copyFileRangeCrossFS() bool {
+	return kernelAtLeast(5, 3)
+}
+
+// zeroCopySendAllowed reports whether SO_ZEROCOPY is supported for TCP
+// sockets, since Linux 4.14, or for UDP sockets, if udp is true, since
+// Linux 5.0.
+func zeroCopySendAllowed(udp bool) bool {
+	if udp {
+		return kernelAtLeast(5, 0)
+	}
+	return kernelAtLeast(4, 14)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"unsafe"
+
+	"acln.ro/zerocopy"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestSyscallsBlocked runs itself in a subprocess, under a seccomp filter
+// which makes splice(2), tee(2) and copy_file_range(2) fail with ENOSYS,
+// as some Android vendor kernels do.
+func TestSyscallsBlocked(t *testing.T) {
+	if os.Getenv("ZEROCOPY_SECCOMP_TEST") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSyscallsBlocked$", "-test.v")
+		cmd.Env = append(os.Environ(), "ZEROCOPY_SECCOMP_TEST=1")
+		out, err := cmd.CombinedOutput()
+		t.Logf("%s", out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if err := blockSyscalls(unix.SYS_SPLICE, unix.SYS_TEE, unix.SYS_COPY_FILE_RANGE); err != nil {
+		t.Skipf("installing seccomp filter: %v", err)
+	}
+	content := bytes.Repeat([]byte("blocked "), 8192)
+
+	t.Run("Transfer", func(t *testing.T) {
+		client, server, err := transferTestSocketPair("unix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		defer server.Close()
+		strategy, err := zerocopy.Probe(client, server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strategy != zerocopy.StrategyGeneric {
+			t.Errorf("Probe reported %v, want %v", strategy, zerocopy.StrategyGeneric)
+		}
+		src := newParitySource(t, content)
+		dst, recv := newParitySink(t)
+		if _, err := zerocopy.Transfer(dst, src); err != nil {
+			t.Fatal(err)
+		}
+		dst.Close()
+		if got := recv(); !bytes.Equal(got, content) {
+			t.Fatalf("got %d bytes, want %d bytes, matching", len(got), len(content))
+		}
+	})
+	t.Run("Files", func(t *testing.T) {
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+		dst := newCopyFileRangeDst(t, 0)
+		defer os.Remove(dst.Name())
+		defer dst.Close()
+		strategy, err := zerocopy.Probe(dst, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strategy != zerocopy.StrategyGeneric {
+			t.Errorf("Probe reported %v, want %v", strategy, zerocopy.StrategyGeneric)
+		}
+		if _, err := zerocopy.Transfer(dst, src); err != nil {
+			t.Fatal(err)
+		}
+		expectFileContent(t, dst, content)
+	})
+	t.Run("Tee", func(t *testing.T) {
+		primary, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer primary.Close()
+		secondary, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer secondary.Close()
+		primary.Tee(secondary)
+		primary.SetTeeCloseWrite(true)
+
+		go func() {
+			primary.Write(content)
+			primary.CloseWrite()
+		}()
+		mirrored := make(chan []byte)
+		go func() {
+			b, _ := ioutil.ReadAll(secondary)
+			mirrored <- b
+		}()
+		got, err := ioutil.ReadAll(primary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("primary: got %d bytes, want %d bytes, matching", len(got), len(content))
+		}
+		if got := <-mirrored; !bytes.Equal(got, content) {
+			t.Fatalf("secondary: got %d bytes, want %d bytes, matching", len(got), len(content))
+		}
+	})
+	t.Run("SpliceRawConn", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		defer w.Close()
+		_, err = zerocopy.SpliceRawConn(rawConn(t, w), rawConn(t, r), 1, 0)
+		serr, ok := err.(*os.SyscallError)
+		if !ok || serr.Err != unix.EINVAL {
+			t.Fatalf("got error %v, want EINVAL", err)
+		}
+	})
+}
+
+// blockSyscalls installs a seccomp filter on all threads of the process,
+// which makes the specified system calls fail with ENOSYS.
+func blockSyscalls(nrs ...uint32) error {
+	const (
+		seccompSetModeFilter   = 1
+		seccompFilterFlagTSync = 1
+		seccompRetAllow        = 0x7fff0000
+		seccompRetErrno        = 0x00050000
+	)
+	filter := []unix.SockFilter{
+		// Load the system call number from struct seccomp_data.
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 0},
+	}
+	for i, nr := range nrs {
+		filter = append(filter, unix.SockFilter{
+			Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K,
+			Jt:   uint8(len(nrs) - i),
+			K:    nr,
+		})
+	}
+	filter = append(filter,
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetAllow},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetErrno | uint32(unix.ENOSYS)},
+	)
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTSync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+const tarBlockSize = 512
+
+// A TarWriter writes tar archives. Headers are encoded in userspace by
+// package archive/tar, while file contents are transferred using SendFile,
+// so that archives can be streamed to sockets at disk speed.
+type TarWriter struct {
+	w      io.Writer
+	hdrbuf bytes.Buffer
+	err    error
+}
+
+// NewTarWriter creates a new TarWriter writing to w.
+func NewTarWriter(w io.Writer) *TarWriter {
+	return &TarWriter{w: w}
+}
+
+// WriteHeader writes an entry with no contents, such as a directory or
+// a symbolic link. hdr.Size must be zero.
+func (tw *TarWriter) WriteHeader(hdr *tar.Header) error {
+	if hdr.Size != 0 {
+		return errors.New("zerocopy: TarWriter.WriteHeader with non-zero size")
+	}
+	return tw.writeHeader(hdr)
+}
+
+// WriteFile writes hdr, followed by the first hdr.Size bytes of f. The file
+// offset of f is neither used nor modified. If f is shorter than hdr.Size,
+// WriteFile returns io.ErrUnexpectedEOF, and the archive is left in an
+// inconsistent state.
+func (tw *TarWriter) WriteFile(hdr *tar.Header, f *os.File) error {
+	if err := tw.writeHeader(hdr); err != nil {
+		return err
+	}
+	r := []Range{{Off: 0, Len: hdr.Size}}
+	if _, err := ServeFileRanges(tw.w, f, r); err != nil {
+		tw.err = err
+		return err
+	}
+	return tw.pad(hdr.Size)
+}
+
+// Close writes the tar trailer. It does not close the underlying writer.
+func (tw *TarWriter) Close() error {
+	if tw.err != nil {
+		return tw.err
+	}
+	_, tw.err = tw.w.Write(make([]byte, 2*tarBlockSize))
+	return tw.err
+}
+
+func (tw *TarWriter) writeHeader(hdr *tar.Header) error {
+	if tw.err != nil {
+		return tw.err
+	}
+	// tar.Writer writes the header blocks (including any PAX or GNU
+	// extension records) to its underlying writer immediately. We
+	// never write the contents through it, and never close it.
+	tw.hdrbuf.Reset()
+	if err := tar.NewWriter(&tw.hdrbuf).WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, tw.err = tw.w.Write(tw.hdrbuf.Bytes())
+	return tw.err
+}
+
+func (tw *TarWriter) pad(size int64) error {
+	if rem := size % tarBlockSize; rem != 0 {
+		_, tw.err = tw.w.Write(make([]byte, tarBlockSize-rem))
+	}
+	return tw.err
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestTarWriter(t *testing.T) {
+	files := map[string]string{
+		"small":                  "hello world",
+		"block":                  strings.Repeat("x", 512),
+		"big":                    strings.Repeat("0123456789", 100000),
+		strings.Repeat("a", 200): "long names need a PAX record",
+	}
+	names := []string{"small", "block", "big", strings.Repeat("a", 200)}
+
+	archive, err := ioutil.TempFile("", "zerocopy-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	tw := zerocopy.NewTarWriter(archive)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     "dir/",
+		Mode:     0755,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		f, err := ioutil.TempFile("", "zerocopy-tar-entry")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := io.WriteString(f, files[name]); err != nil {
+			t.Fatal(err)
+		}
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+		}
+		if err := tw.WriteFile(hdr, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(archive)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "dir/" || hdr.Typeflag != tar.TypeDir {
+		t.Fatalf("got %q (type %c), want directory", hdr.Name, hdr.Typeflag)
+	}
+	for _, name := range names {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name {
+			t.Fatalf("got entry %q, want %q", hdr.Name, name)
+		}
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, tr); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != files[name] {
+			t.Fatalf("%s: content mismatch", name)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("got %v after last entry, want io.EOF", err)
+	}
+}
+
+func TestTarWriterShortFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "zerocopy-tar-entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	io.WriteString(f, "short")
+
+	tw := zerocopy.NewTarWriter(ioutil.Discard)
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: "f", Size: 100}
+	if err := tw.WriteFile(hdr, f); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if err := tw.Close(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Close: got %v, want sticky io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// A TeePolicy specifies what a Pipe does when its tee target fails, for
+// example because it was closed.
+type TeePolicy int
+
+const (
+	// TeeFailPrimary reports the failure of the tee target as an error
+	// on the Pipe itself, as io.TeeReader does. This is the default.
+	TeeFailPrimary TeePolicy = iota
+
+	// TeeDetach detaches the tee target, and carries on as if Tee had
+	// never been called.
+	TeeDetach
+
+	// TeeStall blocks Read and WriteTo until a new target is attached
+	// using Tee, or the Pipe is closed, in which case they return
+	// ErrClosedPipe. Data which was not mirrored to the failed target
+	// is mirrored to the new one.
+	TeeStall
+)
+
+// SetTeePolicy sets the policy for handling failures of the tee target.
+// If fn is not nil, it is called with the error from the tee target,
+// under policies other than TeeFailPrimary, before the policy is applied.
+// fn is called from the goroutine which calls Read or WriteTo. Under
+// TeeStall, fn may call Tee to attach a new target.
+//
+// SetTeePolicy must be called before any calls to Read or WriteTo.
+func (p *Pipe) SetTeePolicy(policy TeePolicy, fn func(err error)) {
+	p.teepolicy = policy
+	p.teefn = fn
+}
+
+// teeFailed applies the tee policy of p, after the tee target failed with
+// err. It returns nil if the caller should carry on.
+func (p *Pipe) teeFailed(err error) error {
+	p.trace("tee failed", 0, err)
+	switch p.teepolicy {
+	case TeeDetach:
+		if p.teefn != nil {
+			p.teefn(err)
+		}
+		p.teemu.Lock()
+		p.teew = nil
+		p.tee(nil)
+		atomic.AddUint32(&p.teegen, 1)
+		p.teemu.Unlock()
+		return nil
+	case TeeStall:
+		// Make the channel before calling p.teefn, so that we don't
+		// miss a call to Tee made from there.
+		p.teemu.Lock()
+		if p.teestall == nil {
+			p.teestall = make(chan struct{})
+		}
+		attached := p.teestall
+		p.teemu.Unlock()
+		if p.teefn != nil {
+			p.teefn(err)
+		}
+		select {
+		case <-attached:
+			return nil
+		case <-p.closec:
+			return ErrClosedPipe
+		}
+	default:
+		return err
+	}
+}
+
+// errTeeChanged is returned by internal I/O functions which notice that the
+// tee target of p changed while they were running. Callers start over.
+var errTeeChanged = errors.New("zerocopy: tee target changed")
+
+// teeState returns the current tee configuration of p, and its generation.
+func (p *Pipe) teeState() (teerd io.Reader, teepipes []*Pipe, gen uint32) {
+	p.teemu.Lock()
+	defer p.teemu.Unlock()
+	return p.teerd, p.teepipes, atomic.LoadUint32(&p.teegen)
+}
+
+// teeChanged reports whether the tee target of p changed since generation
+// gen, as returned by teeState.
+func (p *Pipe) teeChanged(gen uint32) bool {
+	return atomic.LoadUint32(&p.teegen) != gen
+}
+
+// readTee reads from teerd, which is p.teerd as of generation gen. If the
+// tee target fails, but was detached or replaced in the meantime, the
+// failure is not reported.
+func (p *Pipe) readTee(teerd io.Reader, gen uint32, b []byte) (int, error) {
+	n, err := teerd.Read(b)
+	_, failed := err.(teeError)
+	if failed && p.teeChanged(gen) {
+		err = nil
+	}
+	if _, ok := teerd.(teeReader); ok && !failed {
+		count(&p.stats.teed, int64(n))
+	}
+	return n, err
+}
+
+// mirror writes b, which was consumed from p, but not mirrored, to the
+// tee target, subject to the tee policy.
+func (p *Pipe) mirror(b []byte) error {
+	for p.teepolicy == TeeStall {
+		_, err := p.teew.Write(b)
+		if err == nil {
+			count(&p.stats.teed, int64(len(b)))
+			return nil
+		}
+		if err := p.teeFailed(err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A teeError wraps an error from the tee target, so that it can be told
+// apart from errors concerning the Pipe itself.
+type teeError struct {
+	err error
+}
+
+func (e teeError) Error() string { return e.err.Error() }
+
+// Unwrap returns the error from the tee target.
+func (e teeError) Unwrap() error { return e.err }
+
+// teeReader is like io.TeeReader, but it reports the number of bytes
+// read from r even if the write to w fails, and wraps errors from w.
+type teeReader struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (t teeReader) Read(b []byte) (int, error) {
+	n, err := t.r.Read(b)
+	if n > 0 {
+		if _, err := t.w.Write(b[:n]); err != nil {
+			return n, teeError{err}
+		}
+	}
+	return n, err
+}
+
+// multiTee is the tee target set by TeeAll. It is like the writer returned
+// by io.MultiWriter, but it also forwards CloseWrite, for SetTeeCloseWrite.
+type multiTee []io.Writer
+
+func (mt multiTee) Write(b []byte) (int, error) {
+	for _, w := range mt {
+		n, err := w.Write(b)
+		if err != nil {
+			return n, err
+		}
+		if n != len(b) {
+			return n, io.ErrShortWrite
+		}
+	}
+	return len(b), nil
+}
+
+func (mt multiTee) CloseWrite() error {
+	var first error
+	for _, w := range mt {
+		if err := closeWriteTarget(w); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// teeTargetPipes returns the pipes which make up the tee target w, if w is
+// a *Pipe, or was set by TeeAll with writers which are all pipes, or nil
+// otherwise.
+func teeTargetPipes(w io.Writer) []*Pipe {
+	switch w := w.(type) {
+	case *Pipe:
+		return []*Pipe{w}
+	case multiTee:
+		pipes := make([]*Pipe, len(w))
+		for i, w := range w {
+			tp, ok := w.(*Pipe)
+			if !ok {
+				return nil
+			}
+			pipes[i] = tp
+		}
+		return pipes
+	default:
+		return nil
+	}
+}
+
+// closeWriteTarget closes the write side of the tee target w, if it has
+// one. The owner of a *Pipe target may have closed it already, so doing
+// so again is not reported as misuse.
+func closeWriteTarget(w io.Writer) error {
+	if tp, ok := w.(*Pipe); ok {
+		_, err := tp.closeWrite()
+		return err
+	}
+	if cw, ok := w.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestTeePolicy(t *testing.T) {
+	t.Run("FailPrimary", testTeePolicyFailPrimary)
+	t.Run("Detach", testTeePolicyDetach)
+	t.Run("DetachPipe", testTeePolicyDetachPipe)
+	t.Run("Stall", testTeePolicyStall)
+	t.Run("StallPipe", testTeePolicyStallPipe)
+	t.Run("StallClose", testTeePolicyStallClose)
+}
+
+var teePolicyMsg = strings.Repeat("0123456789", 100)
+
+func testTeePolicyFailPrimary(t *testing.T) {
+	p := newTeePolicyPipe(t)
+	defer p.Close()
+
+	p.Tee(&failingWriter{limit: 10})
+	_, err := ioutil.ReadAll(p)
+	if err != errTargetFailed {
+		t.Fatalf("got %v, want %v", err, errTargetFailed)
+	}
+}
+
+func testTeePolicyDetach(t *testing.T) {
+	p := newTeePolicyPipe(t)
+	defer p.Close()
+
+	fw := &failingWriter{limit: 10}
+	p.Tee(fw)
+	calls := 0
+	p.SetTeePolicy(zerocopy.TeeDetach, func(err error) {
+		calls++
+		if err != errTargetFailed {
+			t.Errorf("got %v, want %v", err, errTargetFailed)
+		}
+	})
+	expectTeePolicyMsg(t, p)
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+	if !strings.HasPrefix(teePolicyMsg, fw.buf.String()) {
+		t.Errorf("target got %q, which is not a prefix", fw.buf.String())
+	}
+}
+
+func testTeePolicyDetachPipe(t *testing.T) {
+	p := newTeePolicyPipe(t)
+	defer p.Close()
+
+	secondary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Tee(secondary)
+	secondary.Close()
+	calls := 0
+	p.SetTeePolicy(zerocopy.TeeDetach, func(error) { calls++ })
+	expectTeePolicyMsg(t, p)
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}
+
+func testTeePolicyStall(t *testing.T) {
+	p := newTeePolicyPipe(t)
+	defer p.Close()
+
+	fw := &failingWriter{limit: 10}
+	p.Tee(fw)
+	var replacement bytes.Buffer
+	p.SetTeePolicy(zerocopy.TeeStall, func(error) {
+		p.Tee(&replacement)
+	})
+	expectTeePolicyMsg(t, p)
+	if got := fw.buf.String() + replacement.String(); got != teePolicyMsg {
+		t.Errorf("targets got %q, want %q", got, teePolicyMsg)
+	}
+}
+
+func testTeePolicyStallPipe(t *testing.T) {
+	p := newTeePolicyPipe(t)
+	defer p.Close()
+
+	secondary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Tee(secondary)
+	secondary.Close()
+	replacement, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replacement.Close()
+	p.SetTeePolicy(zerocopy.TeeStall, func(error) {
+		go p.Tee(replacement)
+	})
+	expectTeePolicyMsg(t, p)
+	replacement.CloseWrite()
+	mirror, err := ioutil.ReadAll(replacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mirror) != teePolicyMsg {
+		t.Errorf("replacement got %q, want %q", mirror, teePolicyMsg)
+	}
+}
+
+func testTeePolicyStallClose(t *testing.T) {
+	p := newTeePolicyPipe(t)
+	defer p.Close()
+
+	p.Tee(&failingWriter{limit: 10})
+	p.SetTeePolicy(zerocopy.TeeStall, func(error) {
+		go p.CloseRead()
+	})
+	_, err := ioutil.ReadAll(p)
+	if err != zerocopy.ErrClosedPipe {
+		t.Fatalf("got %v, want ErrClosedPipe", err)
+	}
+}
+
+// newTeePolicyPipe returns a pipe which holds teePolicyMsg, and has its
+// write side closed.
+func newTeePolicyPipe(t *testing.T) *zerocopy.Pipe {
+	t.Helper()
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(p, teePolicyMsg); err != nil {
+		p.Close()
+		t.Fatal(err)
+	}
+	p.CloseWrite()
+	return p
+}
+
+func expectTeePolicyMsg(t *testing.T, p *zerocopy.Pipe) {
+	t.Helper()
+	got, err := ioutil.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != teePolicyMsg {
+		t.Fatalf("got %q, want %q", got, teePolicyMsg)
+	}
+}
+
+var errTargetFailed = errors.New("target failed")
+
+// failingWriter fails once more than limit bytes are written to it.
+type failingWriter struct {
+	limit int
+	buf   bytes.Buffer
+}
+
+func (fw *failingWriter) Write(b []byte) (int, error) {
+	if fw.buf.Len()+len(b) > fw.limit {
+		return 0, errTargetFailed
+	}
+	return fw.buf.Write(b)
+}
+
+func TestTeeLive(t *testing.T) {
+	t.Run("WriteTo/Pipe", func(t *testing.T) { testTeeLive(t, true, true) })
+	t.Run("WriteTo/Writer", func(t *testing.T) { testTeeLive(t, true, false) })
+	t.Run("Read/Pipe", func(t *testing.T) { testTeeLive(t, false, true) })
+	t.Run("Read/Writer", func(t *testing.T) { testTeeLive(t, false, false) })
+}
+
+// pipeWriter hides the concrete type of a *zerocopy.Pipe, so that it is
+// used as a tee target through its Write method.
+type pipeWriter struct {
+	*zerocopy.Pipe
+}
+
+// testTeeLive attaches and detaches a tee target while p is in use, and
+// checks that the target sees exactly the data written to p while it was
+// attached.
+func testTeeLive(t *testing.T, writeTo, teePipe bool) {
+	p, client, server, cleanup := newSpliceTest(t)
+	defer cleanup()
+
+	consumed := make(chan error, 1)
+	go func() {
+		var err error
+		if writeTo {
+			_, err = p.WriteTo(client)
+		} else {
+			_, err = io.Copy(client, struct{ io.Reader }{p})
+		}
+		client.Close()
+		consumed <- err
+	}()
+
+	// send writes msg to p, and waits for it to reach the other side,
+	// so that we know it has been read from p.
+	send := func(msg []byte) {
+		t.Helper()
+		if _, err := p.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(server, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatal("primary destination got corrupted data")
+		}
+	}
+	before := []byte(strings.Repeat("before ", 1000))
+	during := []byte(strings.Repeat("during ", 1000))
+	after := []byte(strings.Repeat("after ", 1000))
+
+	send(before)
+
+	target, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	mirrored := make(chan []byte, 1)
+	go func() {
+		b, _ := ioutil.ReadAll(target)
+		mirrored <- b
+	}()
+	p.SetTeeCloseWrite(true)
+	if teePipe {
+		p.Tee(target)
+	} else {
+		p.Tee(pipeWriter{target})
+	}
+
+	send(during)
+
+	if err := p.DetachTee(); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-mirrored; !bytes.Equal(got, during) {
+		t.Fatalf("tee target got %q, want %q", got, during)
+	}
+
+	send(after)
+
+	if err := p.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-consumed; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A TraceEvent records something which happened to a Pipe. See SetTrace.
+type TraceEvent struct {
+	Time time.Time
+
+	// Op is what happened: "read", "write", "readfrom" or "writeto" for
+	// calls to the corresponding methods, "fallback" when a transfer
+	// switches to a generic copy because splice(2) failed, "tee" and
+	// "detach tee" for changes of the tee target, "tee failed" when
+	// the tee target fails, and "close read" or "close write" when
+	// either side of the pipe is closed.
+	Op string
+
+	// N is the number of bytes moved, if any. For "fallback", it is the
+	// number of bytes moved by splice(2) before the switch.
+	N int64
+
+	// Err is the error reported, if any.
+	Err error
+}
+
+func (e TraceEvent) String() string {
+	s := e.Time.Format("15:04:05.000000") + " " + e.Op
+	if e.N != 0 {
+		s += " n=" + strconv.FormatInt(e.N, 10)
+	}
+	if e.Err != nil {
+		s += " err=" + strconv.Quote(e.Err.Error())
+	}
+	return s
+}
+
+// SetTrace makes p keep a record of its last n events, such as the
+// results of I/O calls, errors, and state changes, for debugging problems
+// which are too intermittent to catch with logging, and too costly to log
+// all the time. The record can be retrieved using Trace, for example
+// when an I/O call fails. If n is not positive, which is the default,
+// p keeps no record, and discards any record it kept. Events are not
+// recorded for pipes used internally by Transfer and other functions.
+func (p *Pipe) SetTrace(n int) {
+	var tr *traceRing
+	if n > 0 {
+		tr = &traceRing{events: make([]TraceEvent, n)}
+	}
+	p.tracer.Store(tr)
+}
+
+// Trace returns the events recorded for p, oldest first, or nil if p does
+// not keep a record. See SetTrace.
+func (p *Pipe) Trace() []TraceEvent {
+	tr, _ := p.tracer.Load().(*traceRing)
+	if tr == nil {
+		return nil
+	}
+	return tr.snapshot()
+}
+
+// trace records an event for p, if p keeps a record.
+func (p *Pipe) trace(op string, n int64, err error) {
+	tr, _ := p.tracer.Load().(*traceRing)
+	if tr == nil {
+		return
+	}
+	tr.add(TraceEvent{Time: time.Now(), Op: op, N: n, Err: err})
+}
+
+// A traceRing is a fixed-size ring of events.
+type traceRing struct {
+	mu     sync.Mutex
+	events []TraceEvent
+	next   int  // index of the next event to overwrite
+	full   bool // all the slots in events have been written
+}
+
+func (tr *traceRing) add(e TraceEvent) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.events[tr.next] = e
+	tr.next++
+	if tr.next == len(tr.events) {
+		tr.next = 0
+		tr.full = true
+	}
+}
+
+func (tr *traceRing) snapshot() []TraceEvent {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if !tr.full {
+		return append([]TraceEvent(nil), tr.events[:tr.next]...)
+	}
+	events := make([]TraceEvent, 0, len(tr.events))
+	events = append(events, tr.events[tr.next:]...)
+	return append(events, tr.events[:tr.next]...)
+}
@@ -0,0 +1,763 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+)
+
+// The tests in this file check that Transfer returns the same results as
+// io.Copy, on both the splice path and the fallback paths.
+
+var parityCopies = []struct {
+	name string
+	copy copyFunc
+}{
+	{"io.Copy", io.Copy},
+	{"Transfer", zerocopy.Transfer},
+}
+
+func TestTransferParity(t *testing.T) {
+	t.Run("unix", func(t *testing.T) { testParityNetwork(t, "unix") })
+	t.Run("tcp", func(t *testing.T) { testParityNetwork(t, "tcp") })
+	t.Run("tcp/Delegated", func(t *testing.T) {
+		zerocopy.SetStdlibDelegation(true)
+		defer zerocopy.SetStdlibDelegation(false)
+		testParityNetwork(t, "tcp")
+	})
+}
+
+func testParityNetwork(t *testing.T, network string) {
+	t.Run("EOF", func(t *testing.T) { testParity(t, network, testParityEOF) })
+	t.Run("LimitedReader", func(t *testing.T) { testParity(t, network, testParityLimitedReader) })
+	t.Run("PumpFallback", func(t *testing.T) { testParity(t, network, testParityPumpFallback) })
+	t.Run("Fallback", func(t *testing.T) { testParity(t, network, testParityFallback) })
+	t.Run("ReadError", func(t *testing.T) { testParity(t, network, testParityReadError) })
+	t.Run("WriteError", func(t *testing.T) { testParity(t, network, testParityWriteError) })
+}
+
+func testParity(t *testing.T, network string, fn func(*testing.T, string, copyFunc)) {
+	for _, pc := range parityCopies {
+		copy := pc.copy
+		t.Run(pc.name, func(t *testing.T) { fn(t, network, copy) })
+	}
+}
+
+// newParitySource returns the server end of a Unix socket pair, from which
+// content can be read, followed by EOF.
+func newParitySource(t *testing.T, content []byte) net.Conn {
+	t.Helper()
+	return newParitySourceNetwork(t, "unix", content)
+}
+
+// newParitySourceNetwork is like newParitySource, but uses a socket pair
+// on the specified network.
+func newParitySourceNetwork(t *testing.T, network string, content []byte) net.Conn {
+	t.Helper()
+	client, server, err := transferTestSocketPair(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		client.Write(content)
+		client.Close()
+	}()
+	return server
+}
+
+// newParitySink returns the server end of a Unix socket pair, and a
+// function which returns everything read from the client end, after the
+// server end is closed.
+func newParitySink(t *testing.T) (net.Conn, func() []byte) {
+	t.Helper()
+	return newParitySinkNetwork(t, "unix")
+}
+
+// newParitySinkNetwork is like newParitySink, but uses a socket pair on
+// the specified network.
+func newParitySinkNetwork(t *testing.T, network string) (net.Conn, func() []byte) {
+	t.Helper()
+	client, server, err := transferTestSocketPair(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(client)
+		client.Close()
+		done <- b
+	}()
+	return server, func() []byte {
+		server.Close()
+		return <-done
+	}
+}
+
+func testParityEOF(t *testing.T, network string, copy copyFunc) {
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	src := newParitySourceNetwork(t, network, content)
+	defer src.Close()
+	dst, received := newParitySinkNetwork(t, network)
+
+	n, err := copy(dst, src)
+	if err != nil {
+		t.Fatalf("got error %v at EOF, want nil", err)
+	}
+	if n != int64(len(content)) {
+		t.Errorf("copied %d bytes, want %d", n, len(content))
+	}
+	if got := received(); !bytes.Equal(got, content) {
+		t.Errorf("received %d bytes, which do not match", len(got))
+	}
+}
+
+func testParityLimitedReader(t *testing.T, network string, copy copyFunc) {
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	src := newParitySourceNetwork(t, network, content)
+	defer src.Close()
+	dst, received := newParitySinkNetwork(t, network)
+
+	const limit = 100000
+	lr := &io.LimitedReader{R: src, N: limit}
+	n, err := copy(dst, lr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != limit || lr.N != 0 {
+		t.Errorf("copied %d bytes with N = %d, want %d with N = 0", n, lr.N, limit)
+	}
+	if got := received(); !bytes.Equal(got, content[:limit]) {
+		t.Errorf("received %d bytes, which do not match", len(got))
+	}
+}
+
+func testParityPumpFallback(t *testing.T, network string, copy copyFunc) {
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	src := newParitySourceNetwork(t, network, content)
+	defer src.Close()
+
+	// splice(2) returns EINVAL for files opened with O_APPEND, so
+	// Transfer must move the data it has already read into the pipe
+	// using a generic copy.
+	f, err := ioutil.TempFile("", "zerocopy-parity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	dst, err := os.OpenFile(f.Name(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	n, err := copy(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Errorf("copied %d bytes, want %d", n, len(content))
+	}
+	expectFileContent(t, f, content)
+}
+
+func testParityFallback(t *testing.T, network string, copy copyFunc) {
+	content := make([]byte, 1<<16)
+	rand.Read(content)
+	var dst bytes.Buffer
+	lr := &io.LimitedReader{R: bytes.NewReader(content), N: 1000}
+	n, err := copy(&dst, lr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1000 || lr.N != 0 {
+		t.Errorf("copied %d bytes with N = %d, want 1000 with N = 0", n, lr.N)
+	}
+	if !bytes.Equal(dst.Bytes(), content[:1000]) {
+		t.Error("copied data does not match")
+	}
+}
+
+func testParityReadError(t *testing.T, network string, copy copyFunc) {
+	client, src, err := transferTestSocketPair(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer src.Close()
+	dst, received := newParitySinkNetwork(t, network)
+	defer received()
+
+	src.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = copy(dst, src)
+	if !isNetTimeout(err) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+}
+
+func testParityWriteError(t *testing.T, network string, copy copyFunc) {
+	content := make([]byte, 1<<20)
+	src := newParitySourceNetwork(t, network, content)
+	defer src.Close()
+	client, dst, err := transferTestSocketPair(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	client.Close()
+
+	n, err := copy(dst, src)
+	if err == nil || err == io.EOF {
+		t.Fatalf("got %v, want a write error", err)
+	}
+	// A TCP peer only learns that the other end is closed when it
+	// answers the first write with a reset, so the first write may
+	// succeed.
+	if network == "unix" && n != 0 {
+		t.Errorf("copied %d bytes to a closed peer, want 0", n)
+	}
+}
+
+func TestTransferErrorSide(t *testing.T) {
+	t.Run("Source", func(t *testing.T) {
+		client, src, err := transferTestSocketPair("unix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		defer src.Close()
+		dst, received := newParitySink(t)
+		defer received()
+
+		src.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		_, err = zerocopy.Transfer(dst, src)
+		terr, ok := err.(*zerocopy.TransferError)
+		if !ok {
+			t.Fatalf("got %v, want a *TransferError", err)
+		}
+		if terr.Side != zerocopy.SideSource {
+			t.Errorf("failed on the %v, want the source", terr.Side)
+		}
+		if !terr.Timeout() {
+			t.Errorf("got %v, want a timeout", terr.Err)
+		}
+	})
+	t.Run("Destination", func(t *testing.T) {
+		content := make([]byte, 1<<20)
+		src := newParitySource(t, content)
+		defer src.Close()
+		client, dst, err := transferTestSocketPair("unix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dst.Close()
+		client.Close()
+
+		_, err = zerocopy.Transfer(dst, src)
+		if serr, ok := err.(*zerocopy.StagedError); ok {
+			err = serr.Unwrap()
+		}
+		terr, ok := err.(*zerocopy.TransferError)
+		if !ok {
+			t.Fatalf("got %v, want a *TransferError", err)
+		}
+		if terr.Side != zerocopy.SideDestination {
+			t.Errorf("failed on the %v, want the destination", terr.Side)
+		}
+		if _, ok := terr.Unwrap().(*os.SyscallError); !ok {
+			t.Errorf("got %v, want an *os.SyscallError", terr.Err)
+		}
+	})
+}
+
+func TestTransferStaged(t *testing.T) {
+	t.Run("unix", func(t *testing.T) { testTransferStaged(t, "unix") })
+	t.Run("tcp", func(t *testing.T) { testTransferStaged(t, "tcp") })
+	t.Run("tcp/Delegated", testTransferStagedDelegated)
+}
+
+// newStagedSink returns both ends of a socket pair on the specified
+// network. Nothing is read from the client end, and the buffers of TCP
+// sockets are kept small, so that writes to the server end stall early.
+func newStagedSink(t *testing.T, network string) (client, server net.Conn) {
+	t.Helper()
+	client, server, err := transferTestSocketPair(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network == "tcp" {
+		client.(*net.TCPConn).SetReadBuffer(64 << 10)
+		server.(*net.TCPConn).SetWriteBuffer(64 << 10)
+	}
+	return client, server
+}
+
+func testTransferStaged(t *testing.T, network string) {
+	content := make([]byte, 8<<20)
+	rand.Read(content)
+	src := newParitySourceNetwork(t, network, content)
+	defer src.Close()
+	client, dst := newStagedSink(t, network)
+	defer client.Close()
+	defer dst.Close()
+
+	// Nothing is read from the destination until the transfer fails,
+	// so the write deadline expires with data in the pipe.
+	dst.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := zerocopy.Transfer(dst, src)
+	serr, ok := err.(*zerocopy.StagedError)
+	if !ok {
+		t.Fatalf("got %v, want a *StagedError", err)
+	}
+	if !serr.Timeout() {
+		t.Errorf("got %v, want a timeout", serr.Err)
+	}
+	if serr.Staged == 0 || len(serr.Data) != serr.Staged {
+		t.Fatalf("got %d bytes of staged data, want %d > 0", len(serr.Data), serr.Staged)
+	}
+
+	dst.Close()
+	received, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(received)) != n {
+		t.Fatalf("destination received %d bytes, Transfer reported %d", len(received), n)
+	}
+	got := append(received, serr.Data...)
+	if !bytes.Equal(got, content[:len(got)]) {
+		t.Error("received and staged data do not match the source")
+	}
+}
+
+func testTransferStagedDelegated(t *testing.T) {
+	zerocopy.SetStdlibDelegation(true)
+	defer zerocopy.SetStdlibDelegation(false)
+
+	content := make([]byte, 8<<20)
+	rand.Read(content)
+	src := newParitySourceNetwork(t, "tcp", content)
+	defer src.Close()
+	client, dst := newStagedSink(t, "tcp")
+	defer client.Close()
+	defer dst.Close()
+
+	// The standard library splices through a pipe of its own, so any
+	// data staged in it is lost, and no *StagedError is returned.
+	dst.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := zerocopy.Transfer(dst, src)
+	if serr, ok := err.(*zerocopy.StagedError); ok {
+		t.Fatalf("got a *StagedError with %d bytes from a delegated transfer", serr.Staged)
+	}
+	// The standard library does not always say which side of the
+	// transfer timed out, in which case its error is returned as is.
+	if terr, ok := err.(*zerocopy.TransferError); ok && terr.Side != zerocopy.SideDestination {
+		t.Errorf("failed on the %v, want the destination", terr.Side)
+	}
+	if !isNetTimeout(err) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+
+	dst.Close()
+	received, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(received)) != n {
+		t.Fatalf("destination received %d bytes, Transfer reported %d", len(received), n)
+	}
+	if !bytes.Equal(received, content[:len(received)]) {
+		t.Error("received data does not match the source")
+	}
+}
+
+func TestTransferContext(t *testing.T) {
+	t.Run("Splice", func(t *testing.T) {
+		client, src, err := transferTestSocketPair("unix")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		defer src.Close()
+		dst, received := newParitySink(t)
+		defer received()
+
+		// Nothing is ever written to src.
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = zerocopy.TransferContext(ctx, dst, src)
+		if err != context.DeadlineExceeded {
+			t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+	t.Run("Fallback", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		dst := &cancelingWriter{cancel: cancel, after: 1 << 20}
+		_, err := zerocopy.TransferContext(ctx, dst, zeroReader{})
+		if err != context.Canceled {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+	})
+	t.Run("Done", func(t *testing.T) {
+		content := make([]byte, 1<<20)
+		rand.Read(content)
+		src := newParitySource(t, content)
+		defer src.Close()
+		dst, received := newParitySink(t)
+
+		n, err := zerocopy.TransferContext(context.Background(), dst, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(content)) {
+			t.Errorf("copied %d bytes, want %d", n, len(content))
+		}
+		if got := received(); !bytes.Equal(got, content) {
+			t.Errorf("received %d bytes, which do not match", len(got))
+		}
+	})
+}
+
+// cancelingWriter discards data, and calls cancel after receiving the
+// specified number of bytes.
+type cancelingWriter struct {
+	cancel func()
+	after  int
+}
+
+func (cw *cancelingWriter) Write(b []byte) (int, error) {
+	cw.after -= len(b)
+	if cw.after <= 0 {
+		cw.cancel()
+	}
+	return len(b), nil
+}
+
+func TestTransferN(t *testing.T) {
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	copies := []struct {
+		name string
+		copy func(dst io.Writer, src io.Reader, n int64) (int64, error)
+	}{
+		{"io.CopyN", io.CopyN},
+		{"TransferN", zerocopy.TransferN},
+	}
+	for _, c := range copies {
+		copyN := c.copy
+		t.Run(c.name, func(t *testing.T) {
+			t.Run("Exact", func(t *testing.T) {
+				src := newParitySource(t, content)
+				defer src.Close()
+				dst, received := newParitySink(t)
+
+				n, err := copyN(dst, src, 1000)
+				if n != 1000 || err != nil {
+					t.Fatalf("got (%d, %v), want (1000, nil)", n, err)
+				}
+				if got := received(); !bytes.Equal(got, content[:1000]) {
+					t.Errorf("received %d bytes, which do not match", len(got))
+				}
+			})
+			t.Run("ShortSource", func(t *testing.T) {
+				src := newParitySource(t, content)
+				defer src.Close()
+				dst, received := newParitySink(t)
+
+				want := int64(len(content))
+				n, err := copyN(dst, src, want+1)
+				if n != want || err != io.EOF {
+					t.Fatalf("got (%d, %v), want (%d, io.EOF)", n, err, want)
+				}
+				if got := received(); !bytes.Equal(got, content) {
+					t.Errorf("received %d bytes, which do not match", len(got))
+				}
+			})
+		})
+	}
+}
+
+func TestTransferExactly(t *testing.T) {
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	tests := []struct {
+		name    string
+		content []byte
+		n       int64
+		want    error
+	}{
+		{"Exact", content, 1000, nil},
+		{"ShortSource", content, int64(len(content)) + 1, io.ErrUnexpectedEOF},
+		{"EmptySource", nil, 1000, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := newParitySource(t, tt.content)
+			defer src.Close()
+			dst, received := newParitySink(t)
+
+			want := tt.n
+			if int64(len(tt.content)) < want {
+				want = int64(len(tt.content))
+			}
+			n, err := zerocopy.TransferExactly(dst, src, tt.n)
+			if n != want || err != tt.want {
+				t.Fatalf("got (%d, %v), want (%d, %v)", n, err, want, tt.want)
+			}
+			if got := received(); !bytes.Equal(got, tt.content[:want]) {
+				t.Errorf("received %d bytes, which do not match", len(got))
+			}
+		})
+	}
+}
+
+func TestPipeTransfer(t *testing.T) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.SetBufferSize(1 << 16); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pipe must be reusable once a transfer completes.
+	for i := 0; i < 2; i++ {
+		content := make([]byte, 1<<20)
+		rand.Read(content)
+		src := newParitySource(t, content)
+		dst, received := newParitySink(t)
+
+		n, err := p.Transfer(dst, src)
+		src.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(content)) {
+			t.Fatalf("transferred %d bytes, want %d", n, len(content))
+		}
+		if got := received(); !bytes.Equal(got, content) {
+			t.Fatalf("transfer %d: received %d bytes, which do not match", i, len(got))
+		}
+	}
+}
+
+func TestPipeTransferTCP(t *testing.T) {
+	// Transfer may delegate TCP to TCP transfers to the standard
+	// library, but Pipe.Transfer must use the pipe it is given.
+	zerocopy.SetStdlibDelegation(true)
+	defer zerocopy.SetStdlibDelegation(false)
+
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	clientUp, serverUp, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverUp.Close()
+	clientDown, serverDown, err := transferTestSocketPair("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientDown.Close()
+
+	content := make([]byte, 1<<16)
+	rand.Read(content)
+	go func() {
+		clientUp.Write(content)
+		clientUp.Close()
+	}()
+	gotc := make(chan []byte, 1)
+	go func() {
+		got, _ := ioutil.ReadAll(clientDown)
+		gotc <- got
+	}()
+	n, err := p.Transfer(serverDown, serverUp)
+	serverDown.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-gotc; n != int64(len(content)) || !bytes.Equal(got, content) {
+		t.Fatalf("transferred %d bytes, received %d, want %d, matching", n, len(got), len(content))
+	}
+	if stats := p.Stats(); stats.BytesIn != n || stats.BytesOut != n {
+		t.Fatalf("pipe moved %d bytes in, %d out, want %d", stats.BytesIn, stats.BytesOut, n)
+	}
+}
+
+func TestCopyBufferSplice(t *testing.T) {
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	src := newParitySource(t, content)
+	defer src.Close()
+	dst, received := newParitySink(t)
+
+	n, err := zerocopy.CopyBuffer(dst, src, make([]byte, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Fatalf("transferred %d bytes, want %d", n, len(content))
+	}
+	if got := received(); !bytes.Equal(got, content) {
+		t.Errorf("received %d bytes, which do not match", len(got))
+	}
+}
+
+func TestTransferFiles(t *testing.T) {
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+
+	t.Run("All", func(t *testing.T) {
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+		dst := newCopyFileRangeDst(t, 0)
+		defer os.Remove(dst.Name())
+		defer dst.Close()
+
+		n, err := zerocopy.Transfer(dst, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(content)) {
+			t.Fatalf("transferred %d bytes, want %d", n, len(content))
+		}
+		expectFileContent(t, dst, content)
+	})
+	t.Run("Limited", func(t *testing.T) {
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+		dst := newCopyFileRangeDst(t, 0)
+		defer os.Remove(dst.Name())
+		defer dst.Close()
+
+		lr := &io.LimitedReader{R: src, N: 1000}
+		n, err := zerocopy.Transfer(dst, lr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1000 || lr.N != 0 {
+			t.Fatalf("transferred %d bytes, lr.N = %d, want 1000, 0", n, lr.N)
+		}
+		expectFileContent(t, dst, content[:1000])
+	})
+	t.Run("Append", func(t *testing.T) {
+		// copy_file_range(2) fails with EBADF for destinations
+		// opened with O_APPEND, so Transfer must move the data by
+		// other means.
+		src := newSendFileTestFile(t, content)
+		defer os.Remove(src.Name())
+		defer src.Close()
+		dst := newCopyFileRangeDst(t, os.O_APPEND)
+		defer os.Remove(dst.Name())
+		defer dst.Close()
+
+		n, err := zerocopy.Transfer(dst, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(content)) {
+			t.Fatalf("transferred %d bytes, want %d", n, len(content))
+		}
+		expectFileContent(t, dst, content)
+	})
+}
+
+func TestTransferUnixNetworks(t *testing.T) {
+	for _, network := range []string{"unix@", "unixpacket", "unixpacket@"} {
+		network := network
+		t.Run(network, func(t *testing.T) {
+			srcClient, src, err := transferTestSocketPair(network)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			dst, dstClient, err := transferTestSocketPair(network)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dst.Close()
+
+			want := zerocopy.StrategySplice
+			if network != "unix@" {
+				want = zerocopy.StrategyGenericDatagram
+			}
+			if got, err := zerocopy.Probe(dst, src); err != nil || got != want {
+				t.Fatalf("Probe: got %v, %v, want %v", got, err, want)
+			}
+
+			// The last message is larger than the pipe buffer, which
+			// splice(2) would truncate.
+			sizes := []int{1000, 2000, 150000}
+			msgs := make([][]byte, len(sizes))
+			for i, size := range sizes {
+				msgs[i] = make([]byte, size)
+				rand.Read(msgs[i])
+			}
+			go func() {
+				for _, m := range msgs {
+					srcClient.Write(m)
+				}
+				srcClient.Close()
+			}()
+			received := make(chan [][]byte)
+			go func() {
+				var got [][]byte
+				buf := make([]byte, 1<<18)
+				for {
+					n, err := dstClient.Read(buf)
+					if n > 0 {
+						got = append(got, append([]byte(nil), buf[:n]...))
+					}
+					if err != nil {
+						break
+					}
+				}
+				received <- got
+			}()
+			n, err := zerocopy.Transfer(dst, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst.Close()
+			got := <-received
+			if network == "unix@" {
+				got = [][]byte{bytes.Join(got, nil)}
+				msgs = [][]byte{bytes.Join(msgs, nil)}
+			}
+			if total := int64(len(bytes.Join(msgs, nil))); n != total {
+				t.Errorf("transferred %d bytes, want %d", n, total)
+			}
+			if len(got) != len(msgs) {
+				t.Fatalf("received %d messages, want %d", len(got), len(msgs))
+			}
+			for i := range msgs {
+				if !bytes.Equal(got[i], msgs[i]) {
+					t.Errorf("message %d: got %d bytes, want %d", i, len(got[i]), len(msgs[i]))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Errors which the function passed to TwoFDWaiter.Do may return, in order
+// to request a wait for a specific file descriptor.
+var (
+	ErrWaitRead  = errors.New("zerocopy: wait for the read file descriptor")
+	ErrWaitWrite = errors.New("zerocopy: wait for the write file descriptor")
+)
+
+// A TwoFDWaiter drives system calls which operate on two file descriptors
+// in non-blocking mode, such as splice(2), tee(2) or copy_file_range(2),
+// waiting for readiness using the runtime network poller.
+//
+// Waiting for one file descriptor while holding a reference to another
+// one can deadlock: for example, a Close of the latter would block until
+// the former becomes ready. TwoFDWaiter never does so. On Linux, the
+// algorithm is described in detail at the top of zerocopy_linux.go.
+//
+// R is the file descriptor which is read from, and W is the file descriptor
+// which is written to. Their file descriptors must be in non-blocking mode.
+type TwoFDWaiter struct {
+	R, W syscall.RawConn
+}
+
+// Do calls fn with the file descriptors of R and W, until fn returns an
+// error other than syscall.EAGAIN, ErrWaitRead or ErrWaitWrite, and returns
+// that error.
+//
+// If fn returns syscall.EAGAIN, Do waits for R to become readable, unless
+// it already is, then, if fn still returns syscall.EAGAIN, for W to become
+// writable, and so on.
+// If fn knows which file descriptor is not ready, it may return ErrWaitRead
+// or ErrWaitWrite instead, and Do waits for that file descriptor only.
+//
+// fn must not block, and may be called several times. If waiting for R or
+// W fails, for example because the file descriptor was closed, or because
+// its deadline expired, Do returns the error.
+func (w *TwoFDWaiter) Do(fn func(rfd, wfd uintptr) error) error {
+	err, rerr, werr := w.do(fn)
+	if rerr != nil {
+		return rerr
+	}
+	if werr != nil {
+		return werr
+	}
+	return err
+}
+
+// do is like Do, but reports errors from waiting for R and W separately,
+// as rerr and werr respectively. If either is not nil, err is nil.
+func (w *TwoFDWaiter) do(fn func(rfd, wfd uintptr) error) (err, rerr, werr error) {
+	var s fdscope
+	for {
+		// Round 1: wait for R, holding no reference to W while
+		// waiting.
+		readready := false
+		waitwrite := false
+		rerr = s.read(w.R, func(rfd uintptr) bool {
+			werr = s.write(w.W, func(wfd uintptr) bool {
+				err = fn(rfd, wfd)
+				return true
+			})
+			if werr != nil {
+				return true
+			}
+			switch err {
+			case syscall.EAGAIN:
+				if readready || fdReadable(rfd) {
+					waitwrite = true
+					return true
+				}
+			case ErrWaitRead:
+			case ErrWaitWrite:
+				waitwrite = true
+				return true
+			default:
+				return true
+			}
+			readready = true
+			return false
+		})
+		if rerr != nil || werr != nil {
+			return nil, rerr, werr
+		}
+		if !waitwrite {
+			return err, nil, nil
+		}
+
+		// Round 2: wait for W, holding no reference to R while
+		// waiting.
+		writeready := false
+		werr = s.write(w.W, func(wfd uintptr) bool {
+			rerr = s.read(w.R, func(rfd uintptr) bool {
+				err = fn(rfd, wfd)
+				return true
+			})
+			if rerr != nil {
+				return true
+			}
+			switch err {
+			case syscall.EAGAIN:
+				if writeready {
+					return true
+				}
+			case ErrWaitWrite:
+			default:
+				return true
+			}
+			writeready = true
+			return false
+		})
+		if rerr != nil || werr != nil {
+			return nil, rerr, werr
+		}
+		if err != syscall.EAGAIN && err != ErrWaitRead {
+			return err, nil, nil
+		}
+		// Go around, and wait for R again.
+	}
+}
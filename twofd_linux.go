@@ -0,0 +1,24 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import "golang.org/x/sys/unix"
+
+// fdReadable reports whether fd is readable, or at EOF, without waiting.
+// If the state of fd cannot be determined, fdReadable returns false.
+func fdReadable(fd uintptr) bool {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		_, err := unix.Poll(fds, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return false
+		}
+		return fds[0].Revents&(unix.POLLIN|unix.POLLHUP) != 0
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestTwoFDWaiter(t *testing.T) {
+	t.Run("WaitRead", testTwoFDWaiterWaitRead)
+	t.Run("WaitWrite", testTwoFDWaiterWaitWrite)
+	t.Run("CloseWhileWaiting", testTwoFDWaiterCloseWhileWaiting)
+	t.Run("ConsumedReadiness", testTwoFDWaiterConsumedReadiness)
+}
+
+func testTwoFDWaiterWaitRead(t *testing.T) {
+	src, dst := newTwoFDWaiterPipes(t)
+	defer src.close()
+	defer dst.close()
+
+	errc := make(chan error, 1)
+	var n int
+	go func() {
+		errc <- newTwoFDWaiter(t, src, dst).Do(func(rfd, wfd uintptr) error {
+			var err error
+			n, err = splice(rfd, wfd)
+			return err
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	io.WriteString(src.w, "hello")
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if n != len("hello") {
+		t.Fatalf("spliced %d bytes, want %d", n, len("hello"))
+	}
+}
+
+func testTwoFDWaiterWaitWrite(t *testing.T) {
+	src, dst := newTwoFDWaiterPipes(t)
+	defer src.close()
+	defer dst.close()
+
+	// Fill dst, so that the splice must wait for it to drain.
+	fillPipe(t, dst.w)
+	io.WriteString(src.w, "hello")
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- newTwoFDWaiter(t, src, dst).Do(func(rfd, wfd uintptr) error {
+			_, err := splice(rfd, wfd)
+			return err
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	got, err := ioutil.ReadAll(io.LimitReader(dst.r, 1<<16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	rest := make([]byte, len("hello"))
+	if _, err := io.ReadFull(dst.r, rest); err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "hello" || strings.Trim(string(got), "x") != "" {
+		t.Fatalf("unexpected data in dst: %q", rest)
+	}
+}
+
+func testTwoFDWaiterCloseWhileWaiting(t *testing.T) {
+	src, dst := newTwoFDWaiterPipes(t)
+	defer src.close()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- newTwoFDWaiter(t, src, dst).Do(func(rfd, wfd uintptr) error {
+			_, err := splice(rfd, wfd)
+			return err
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// Do is waiting for src to become readable. It must not hold
+	// a reference to dst while doing so, so closing dst must not block.
+	closed := make(chan struct{})
+	go func() {
+		dst.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked while Do was waiting for another file descriptor")
+	}
+
+	src.r.Close()
+	if err := <-errc; err == nil {
+		t.Fatal("Do succeeded after the file descriptors were closed")
+	}
+}
+
+func testTwoFDWaiterConsumedReadiness(t *testing.T) {
+	src, dst := newTwoFDWaiterPipes(t)
+	defer src.close()
+	defer dst.close()
+
+	io.WriteString(src.w, "hello")
+	tw := newTwoFDWaiter(t, src, dst)
+
+	// Wait for src once, without reading from it, so that the readiness
+	// notification is consumed, even though the data is still there.
+	waited := false
+	err := tw.Do(func(rfd, wfd uintptr) error {
+		if !waited {
+			waited = true
+			return zerocopy.ErrWaitRead
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Now the splice must wait for dst to drain, and not for src, which
+	// is readable, but will not be notified as such again.
+	fillPipe(t, dst.w)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tw.Do(func(rfd, wfd uintptr) error {
+			_, err := splice(rfd, wfd)
+			return err
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := ioutil.ReadAll(io.LimitReader(dst.r, 1<<16)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		src.close()
+		t.Fatal("Do waited for a readable file descriptor")
+	}
+}
+
+type twoFDWaiterPipe struct {
+	r, w *os.File
+}
+
+func (p twoFDWaiterPipe) close() {
+	p.r.Close()
+	p.w.Close()
+}
+
+func newTwoFDWaiterPipes(t *testing.T) (src, dst twoFDWaiterPipe) {
+	t.Helper()
+	var err error
+	src.r, src.w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst.r, dst.w, err = os.Pipe()
+	if err != nil {
+		src.close()
+		t.Fatal(err)
+	}
+	return src, dst
+}
+
+func newTwoFDWaiter(t *testing.T, src, dst twoFDWaiterPipe) *zerocopy.TwoFDWaiter {
+	rrc, err := src.r.SyscallConn()
+	if err != nil {
+		t.Error(err)
+	}
+	wrc, err := dst.w.SyscallConn()
+	if err != nil {
+		t.Error(err)
+	}
+	return &zerocopy.TwoFDWaiter{R: rrc, W: wrc}
+}
+
+func fillPipe(t *testing.T, w *os.File) {
+	t.Helper()
+	rc, err := w.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := []byte(strings.Repeat("x", 1<<16))
+	err = rc.Write(func(fd uintptr) bool {
+		for {
+			_, err := unix.Write(int(fd), b)
+			if err != nil {
+				return true
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func splice(rfd, wfd uintptr) (int, error) {
+	n, err := unix.Splice(int(rfd), nil, int(wfd), nil, 1<<20, unix.SPLICE_F_NONBLOCK)
+	return int(n), err
+}
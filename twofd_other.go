@@ -0,0 +1,15 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package zerocopy
+
+// fdReadable reports whether fd is readable without waiting. On platforms
+// other than Linux, it always returns false, and TwoFDWaiter waits for the
+// read file descriptor whenever it is not sure.
+func fdReadable(fd uintptr) bool {
+	return false
+}
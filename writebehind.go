@@ -0,0 +1,32 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+)
+
+// TransferWriteBehind is like Transfer from a socket to a file, but keeps
+// reading from src while writes to dst are slow, for example on storage
+// which stalls on fsync(2). Up to budget bytes read from src, rounded up
+// to whole pipes, are staged in pipes, and written to dst, in order, by
+// the calling goroutine, while another goroutine reads from src. This
+// bounds the stalls the producer on the other side of src observes.
+// TransferWriteBehind reads src until EOF.
+//
+// If budget is not positive, or src does not implement syscall.Conn and
+// SetReadDeadline, as net.Conn values do, TransferWriteBehind behaves
+// like Transfer.
+//
+// If writing to dst fails, TransferWriteBehind interrupts the pending
+// read from src by setting its read deadline to a time in the past, as
+// TransferContext does, and returns a *StagedError, which holds all the
+// data read from src, but not written to dst. The read deadline must be
+// reset before src can be used again.
+func TransferWriteBehind(dst *os.File, src io.Reader, budget int) (int64, error) {
+	return transferWriteBehind(dst, src, budget)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+func transferWriteBehind(dst *os.File, src io.Reader, budget int) (int64, error) {
+	if budget <= 0 {
+		return transfer(dst, src)
+	}
+	rdl, ok := src.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return transfer(dst, src)
+	}
+	rsc, ok := src.(syscall.Conn)
+	if !ok {
+		return transfer(dst, src)
+	}
+	rrc, err := rsc.SyscallConn()
+	if err != nil {
+		return transfer(dst, src)
+	}
+	wrc, err := dst.SyscallConn()
+	if err != nil {
+		return transfer(dst, src)
+	}
+	pipes, err := getPipes(2, budget)
+	if err != nil {
+		return transfer(dst, src)
+	}
+	defer putPipes(pipes)
+
+	interrupt := func() {
+		rdl.SetReadDeadline(time.Unix(1, 0))
+	}
+	moved, unsent, fallback, err := relay(dst, wrc, rrc, isRegularFile(src), pipes, interrupt)
+	if err != nil {
+		if len(unsent) == 0 {
+			return moved, err
+		}
+		se := &StagedError{Err: err}
+		short := false
+		for _, c := range unsent {
+			se.Staged += c.n
+			if short {
+				continue
+			}
+			// Stop recovering at the first gap, so that Data
+			// is a prefix of the staged data.
+			data := make([]byte, c.n)
+			n := c.p.readStaged(data)
+			se.Data = append(se.Data, data[:n]...)
+			short = n < c.n
+		}
+		return moved, se
+	}
+	if fallback {
+		n, err := copyFallback(dst, src)
+		return moved + n, err
+	}
+	return moved, nil
+}
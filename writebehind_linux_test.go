@@ -0,0 +1,65 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+
+	"acln.ro/zerocopy"
+)
+
+func TestTransferWriteBehind(t *testing.T) {
+	content := make([]byte, 4<<20)
+	rand.Read(content)
+
+	t.Run("File", func(t *testing.T) {
+		src := newParitySource(t, content)
+		defer src.Close()
+		dst := newCopyFileRangeDst(t, 0)
+		defer os.Remove(dst.Name())
+		defer dst.Close()
+
+		n, err := zerocopy.TransferWriteBehind(dst, src, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(content)) {
+			t.Fatalf("transferred %d bytes, want %d", n, len(content))
+		}
+		expectFileContent(t, dst, content)
+	})
+	t.Run("WriteError", func(t *testing.T) {
+		src := newParitySource(t, content)
+		defer src.Close()
+		f := newCopyFileRangeDst(t, 0)
+		defer os.Remove(f.Name())
+		f.Close()
+		// Writes to a file opened for reading fail.
+		dst, err := os.Open(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dst.Close()
+
+		n, err := zerocopy.TransferWriteBehind(dst, src, 1<<20)
+		se, ok := err.(*zerocopy.StagedError)
+		if !ok {
+			t.Fatalf("got %v, want a *StagedError", err)
+		}
+		if n != 0 {
+			t.Fatalf("transferred %d bytes to a read-only file", n)
+		}
+		if se.Staged == 0 || len(se.Data) != se.Staged {
+			t.Fatalf("staged %d bytes, recovered %d", se.Staged, len(se.Data))
+		}
+		if !bytes.Equal(se.Data, content[:len(se.Data)]) {
+			t.Fatal("recovered data does not match")
+		}
+	})
+}
@@ -6,18 +6,189 @@
 package zerocopy
 
 import (
+	"errors"
 	"io"
 	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
+// ErrClosedPipe is the error returned by Pipe methods which operate on a
+// side of the pipe which has been closed, either explicitly, or, in the
+// case of writes, by closing the read side. It is the same value as
+// io.ErrClosedPipe, so code migrated from io.Pipe can keep comparing
+// against the latter.
+var ErrClosedPipe = io.ErrClosedPipe
+
+// ErrTeeTimeout is returned by Read and WriteTo if the deadline set by
+// SetTeeDeadline expires while waiting for the tee target to gain space.
+// It implements net.Error, and its Timeout method returns true.
+var ErrTeeTimeout error = teeTimeoutError{}
+
+type teeTimeoutError struct{}
+
+func (teeTimeoutError) Error() string   { return "zerocopy: timeout waiting for tee target" }
+func (teeTimeoutError) Timeout() bool   { return true }
+func (teeTimeoutError) Temporary() bool { return true }
+
+// A StagedError is returned by Transfer if writing to the destination fails
+// after data has been read from the source into the internal pipe, but
+// before all of it has been written. Such data would otherwise be lost.
+// The number of bytes which were accepted by the destination is the count
+// returned by Transfer.
+//
+// Transfers delegated to the standard library, which SetStdlibDelegation
+// enables, are the exception: if writing to the destination fails, the
+// data which the standard library read from the source is lost, and no
+// StagedError is returned.
+//
+// A StagedError implements net.Error if Err does, so timeouts can still be
+// detected through the Timeout method.
+type StagedError struct {
+	// Err is the error returned by the destination.
+	Err error
+
+	// Staged is the number of bytes which were read from the source,
+	// but not written to the destination.
+	Staged int
+
+	// Data holds the staged bytes, recovered from the pipe. It may be
+	// shorter than Staged if recovering the data failed.
+	Data []byte
+}
+
+func (e *StagedError) Error() string {
+	return e.Err.Error() + " (" + strconv.Itoa(e.Staged) + " bytes staged)"
+}
+
+// Timeout reports whether e.Err is a timeout.
+func (e *StagedError) Timeout() bool { return isTimeout(e.Err) }
+
+// Temporary reports whether e.Err is temporary.
+func (e *StagedError) Temporary() bool {
+	te, ok := e.Err.(interface{ Temporary() bool })
+	return ok && te.Temporary()
+}
+
+// Unwrap returns e.Err.
+func (e *StagedError) Unwrap() error { return e.Err }
+
+// A Side identifies the part of a transfer which failed. See TransferError.
+type Side int
+
+// Sides of a transfer.
+const (
+	// SideSource is the source, which data is read from.
+	SideSource Side = iota + 1
+
+	// SideDestination is the destination, which data is written to.
+	SideDestination
+
+	// SidePipe is the pipe which data moves through, between the
+	// source and the destination.
+	SidePipe
+)
+
+func (s Side) String() string {
+	switch s {
+	case SideSource:
+		return "source"
+	case SideDestination:
+		return "destination"
+	case SidePipe:
+		return "pipe"
+	default:
+		return "unknown"
+	}
+}
+
+// A TransferError is returned by Transfer if splice(2) fails, or if
+// waiting for a file descriptor to become ready for splice(2) fails, for
+// example because its deadline expired. It records the side of the
+// transfer which failed, so that proxies can tell which connection to
+// reset.
+//
+// If data was left in the pipe, the TransferError is the Err field of a
+// *StagedError. Errors from generic copies are returned as the Read and
+// Write methods of the source and destination report them, as io.Copy
+// would return them. The side is therefore only known on the splice(2)
+// path, with one exception: transfers delegated to the ReadFrom method of
+// a *net.TCPConn, if SetStdlibDelegation enabled delegation. Their error
+// is wrapped if the *net.OpError inside it names the failing operation as
+// "read" (SideSource) or "write" (SideDestination), and returned
+// unchanged otherwise.
+//
+// A TransferError implements net.Error if Err does, so timeouts can still
+// be detected through the Timeout method.
+type TransferError struct {
+	// Side is the side of the transfer which failed.
+	Side Side
+
+	// Err is the underlying error, such as an *os.SyscallError.
+	Err error
+}
+
+func (e *TransferError) Error() string {
+	return "zerocopy: " + e.Side.String() + ": " + e.Err.Error()
+}
+
+// Unwrap returns e.Err.
+func (e *TransferError) Unwrap() error { return e.Err }
+
+// Timeout reports whether e.Err is a timeout.
+func (e *TransferError) Timeout() bool { return isTimeout(e.Err) }
+
+// Temporary reports whether e.Err is temporary.
+func (e *TransferError) Temporary() bool {
+	te, ok := e.Err.(interface{ Temporary() bool })
+	return ok && te.Temporary()
+}
+
+// sideError returns a *TransferError for err on the specified side, or nil
+// if err is nil.
+func sideError(side Side, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TransferError{Side: side, Err: err}
+}
+
+// isTimeout reports whether err is a timeout error.
+func isTimeout(err error) bool {
+	te, ok := err.(interface{ Timeout() bool })
+	return ok && te.Timeout()
+}
+
 // A Pipe is a buffered, unidirectional data channel.
 type Pipe struct {
+	bufsize int64        // atomic; cached buffer size, or 0; first for alignment
+	stats   pipeCounters // 64-bit aligned, following bufsize
+
 	r, w     *os.File
 	rrc, wrc syscall.RawConn
 
-	teerd   io.Reader
-	teepipe *Pipe
+	rclosed, wclosed int32         // atomic
+	closec           chan struct{} // closed by CloseRead
+
+	bufsizefn func(requested, effective int)
+
+	tracer atomic.Value // *traceRing, set by SetTrace
+
+	teerd    io.Reader // guarded by teemu
+	teepipes []*Pipe   // guarded by teemu; targets of tee(2)
+	teegen   uint32    // atomic; incremented when the tee target changes
+
+	teemu         sync.Mutex
+	teew          io.Writer // tee target, or nil
+	teeCloseWrite bool
+	teepolicy     TeePolicy
+	teefn         func(error)
+	teestall      chan struct{} // closed by Tee, under TeeStall
+
+	strict bool // see SetStrict
 }
 
 // NewPipe creates a new pipe.
@@ -35,70 +206,284 @@ func NewPipe() (*Pipe, error) {
 		return nil, err
 	}
 	return &Pipe{
-		r:     r,
-		w:     w,
-		rrc:   rrc,
-		wrc:   wrc,
-		teerd: r,
+		r:      r,
+		w:      w,
+		rrc:    rrc,
+		wrc:    wrc,
+		closec: make(chan struct{}),
+		teerd:  r,
 	}, nil
 }
 
-// BufferSize returns the buffer size of the pipe.
+// BufferSize returns the buffer size of the pipe. The size is cached
+// after the first call, and after calls to SetBufferSize, so changes
+// made through other means, such as by way of SyscallConns, are not
+// observed.
 func (p *Pipe) BufferSize() (int, error) {
-	return p.bufferSize()
+	if n := atomic.LoadInt64(&p.bufsize); n > 0 {
+		return int(n), nil
+	}
+	n, err := p.bufferSize()
+	if err != nil {
+		return 0, err
+	}
+	atomic.StoreInt64(&p.bufsize, int64(n))
+	return n, nil
 }
 
-// SetBufferSize sets the pipe's buffer size to n.
+// SetBufferSize sets the pipe's buffer size to n. The operating system
+// may round n up, in which case the function registered using
+// SetBufferSizeFunc, if any, is called. Afterwards, BufferSize returns the
+// effective size, without a system call.
+//
+// On Linux, unprivileged processes cannot set sizes larger than the limit
+// reported by MaxBufferSize, and the call fails with EPERM.
 func (p *Pipe) SetBufferSize(n int) error {
-	return p.setBufferSize(n)
+	effective, err := p.setBufferSize(n)
+	if err != nil {
+		atomic.StoreInt64(&p.bufsize, 0)
+		return err
+	}
+	atomic.StoreInt64(&p.bufsize, int64(effective))
+	if effective != n && p.bufsizefn != nil {
+		p.bufsizefn(n, effective)
+	}
+	return nil
+}
+
+// MaxBufferSize returns the largest pipe buffer size an unprivileged
+// process may set using SetBufferSize, or 0 if there is no such limit, or
+// it cannot be determined. On Linux, the limit is read once, from
+// /proc/sys/fs/pipe-max-size.
+func MaxBufferSize() int {
+	return pipeMaxSize()
+}
+
+// SetBufferSizeFunc registers a function to be called by SetBufferSize
+// if the effective buffer size differs from the requested one. On Linux,
+// the size is rounded up to a power of two multiple of the page size.
+//
+// SetBufferSizeFunc must not be called concurrently with SetBufferSize.
+func (p *Pipe) SetBufferSizeFunc(fn func(requested, effective int)) {
+	p.bufsizefn = fn
+}
+
+// SyscallConns returns raw connections to the read and write sides of the
+// pipe, for use by frameworks which drive I/O from their own event loops.
+// The file descriptors, which may be obtained by calling Control, are in
+// non-blocking mode. They may be registered with an external poller, such
+// as an epoll instance, alongside the runtime network poller. Callers must
+// not change the mode of the file descriptors, nor close them.
+func (p *Pipe) SyscallConns() (r, w syscall.RawConn) {
+	return p.rrc, p.wrc
 }
 
 // Read reads data from the pipe.
 func (p *Pipe) Read(b []byte) (n int, err error) {
-	return p.read(b)
+	n, err = p.readPolicy(b)
+	count(&p.stats.bytesOut, int64(n))
+	p.trace("read", int64(n), err)
+	return n, err
+}
+
+// readPolicy implements Read, applying the tee policy, but does not
+// update statistics, or record trace events.
+func (p *Pipe) readPolicy(b []byte) (n int, err error) {
+again:
+	n, err = p.read(b)
+	if err == errTeeChanged {
+		goto again
+	}
+	if te, ok := err.(teeError); ok {
+		if err = p.teeFailed(te.err); err == nil {
+			if n == 0 {
+				goto again
+			}
+			err = p.mirror(b[:n])
+		}
+	}
+	if err == io.EOF {
+		p.DetachTee()
+	}
+	return n, p.readErr(err)
 }
 
-// CloseRead closes the read side of the pipe.
+// CloseRead closes the read side of the pipe, and detaches the tee, if any.
+// Subsequent calls to CloseRead return nil, unless the misuse policy says
+// otherwise. See SetMisusePolicy.
 func (p *Pipe) CloseRead() error {
-	return p.r.Close()
+	closed, err := p.closeRead()
+	if !closed {
+		return misuse("CloseRead", "read side already closed")
+	}
+	return err
+}
+
+// closeRead is like CloseRead, but reports whether it closed the read side,
+// rather than reporting misuse.
+func (p *Pipe) closeRead() (closed bool, err error) {
+	if !atomic.CompareAndSwapInt32(&p.rclosed, 0, 1) {
+		return false, nil
+	}
+	close(p.closec)
+	err = p.r.Close()
+	if err1 := p.DetachTee(); err == nil {
+		err = err1
+	}
+	p.trace("close read", 0, err)
+	return true, err
 }
 
 // Write writes data to the pipe.
 func (p *Pipe) Write(b []byte) (n int, err error) {
-	return p.w.Write(b)
+	n, err = p.w.Write(b)
+	err = p.writeErr(err)
+	count(&p.stats.bytesIn, int64(n))
+	p.trace("write", int64(n), err)
+	return n, err
 }
 
-// CloseWrite closes the write side of the pipe.
+// CloseWrite closes the write side of the pipe. Subsequent calls to
+// CloseWrite return nil, unless the misuse policy says otherwise. See
+// SetMisusePolicy.
 func (p *Pipe) CloseWrite() error {
-	return p.w.Close()
+	closed, err := p.closeWrite()
+	if !closed {
+		return misuse("CloseWrite", "write side already closed")
+	}
+	return err
 }
 
-// Close closes both sides of the pipe.
+// closeWrite is like CloseWrite, but reports whether it closed the write
+// side, rather than reporting misuse.
+func (p *Pipe) closeWrite() (closed bool, err error) {
+	if !atomic.CompareAndSwapInt32(&p.wclosed, 0, 1) {
+		return false, nil
+	}
+	err = p.w.Close()
+	p.trace("close write", 0, err)
+	return true, err
+}
+
+// Close closes both sides of the pipe, and detaches the tee, if any.
+// Closing a pipe whose sides are already closed does nothing, and returns
+// nil, unless the misuse policy says otherwise. See SetMisusePolicy.
 func (p *Pipe) Close() error {
-	err := p.r.Close()
-	err1 := p.w.Close()
-	if err != nil {
-		return err
+	rclosed, err := p.closeRead()
+	wclosed, err1 := p.closeWrite()
+	if err == nil {
+		err = err1
+	}
+	if !rclosed && !wclosed {
+		return misuse("Close", "pipe already closed")
+	}
+	return err
+}
+
+// close is like Close, but never reports misuse. It is used for pipes
+// which package zerocopy owns, and which may be closed more than once.
+func (p *Pipe) close() error {
+	_, err := p.closeRead()
+	_, err1 := p.closeWrite()
+	if err == nil {
+		err = err1
+	}
+	return err
+}
+
+// SetDeadline sets the read and write deadlines of the pipe. It is
+// equivalent to calling both SetReadDeadline and SetWriteDeadline.
+func (p *Pipe) SetDeadline(t time.Time) error {
+	err := p.SetReadDeadline(t)
+	if err1 := p.SetWriteDeadline(t); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// SetReadDeadline sets the deadline for waiting on data to become available
+// in the pipe, in Read and WriteTo. If the deadline expires, they return an
+// error whose Timeout method returns true, as package os does for files.
+// Waits for the destination of WriteTo are subject to the deadlines of the
+// destination, if any. A zero value for t means no deadline.
+func (p *Pipe) SetReadDeadline(t time.Time) error {
+	return p.r.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for waiting on the pipe to gain space,
+// in Write and ReadFrom. If the deadline expires, they return an error
+// whose Timeout method returns true, as package os does for files. A zero
+// value for t means no deadline.
+//
+// If p is the tee target of another pipe, its write deadline is also the
+// deadline set by SetTeeDeadline on the other pipe.
+func (p *Pipe) SetWriteDeadline(t time.Time) error {
+	return p.w.SetWriteDeadline(t)
+}
+
+// readErr returns ErrClosedPipe in place of err if err is the result of
+// operating on the read side of p after it was closed.
+func (p *Pipe) readErr(err error) error {
+	if err != nil && err != io.EOF && atomic.LoadInt32(&p.rclosed) != 0 {
+		return ErrClosedPipe
+	}
+	return err
+}
+
+// writeErr returns ErrClosedPipe in place of err if err is the result of
+// operating on the write side of p after either side was closed.
+func (p *Pipe) writeErr(err error) error {
+	if err == nil {
+		return nil
 	}
-	return err1
+	if atomic.LoadInt32(&p.wclosed) != 0 || atomic.LoadInt32(&p.rclosed) != 0 {
+		return ErrClosedPipe
+	}
+	return err
 }
 
 // ReadFrom transfers data from src to the pipe.
 //
 // If src implements syscall.Conn, ReadFrom tries to use splice(2) for the
 // data transfer from the source file descriptor to the pipe. If that is
-// not possible, ReadFrom falls back to a generic copy.
+// not possible, ReadFrom falls back to a generic copy, unless p is in
+// strict mode (see SetStrict).
 func (p *Pipe) ReadFrom(src io.Reader) (int64, error) {
-	return p.readFrom(src)
+	n, err := p.readFrom(src)
+	err = p.writeErr(err)
+	count(&p.stats.bytesIn, n)
+	p.trace("readfrom", n, err)
+	return n, err
 }
 
-// WriteTo transfers data from the pipe to dst.
+// WriteTo transfers data from the pipe to dst, until EOF.
 //
 // If dst implements syscall.Conn, WriteTo tries to use splice(2) for the
 // data transfer from the pipe to the destination file descriptor. If that
-// is not possible, WriteTo falls back to a generic copy.
+// is not possible, WriteTo falls back to a generic copy. If p tees to
+// another *Pipe, each tee(2) call is followed by a splice(2) call, on the
+// same wakeup.
 func (p *Pipe) WriteTo(dst io.Writer) (int64, error) {
-	return p.writeTo(dst)
+	var moved int64
+	for {
+		n, err := p.writeTo(dst)
+		moved += n
+		if err == errTeeChanged {
+			continue
+		}
+		if te, ok := err.(teeError); ok {
+			if err = p.teeFailed(te.err); err == nil {
+				continue
+			}
+		}
+		if err == nil {
+			p.DetachTee()
+		}
+		err = p.readErr(err)
+		count(&p.stats.bytesOut, moved)
+		p.trace("writeto", moved, err)
+		return moved, err
+	}
 }
 
 // Tee arranges for data in the read side of the pipe to be mirrored to the
@@ -106,12 +491,153 @@ func (p *Pipe) WriteTo(dst io.Writer) (int64, error) {
 // before the associated read completes.
 //
 // If the argument is of concrete type *Pipe, the tee(2) system call
-// is used when mirroring data from the read side of the pipe.
+// is used when mirroring data from the read side of the pipe, unless it
+// is blocked for the process, in which case data is mirrored through
+// userspace, as for other writers.
+//
+// Tee may be called at any time, including concurrently with Read and
+// WriteTo, to attach a target, or to replace the current one, and
+// DetachTee removes it. Changes take effect at the next read from the
+// pipe: data which a concurrent Read or WriteTo has already started to
+// read is mirrored to the previous target, if any. A read which is waiting
+// for the previous target to gain space keeps waiting, subject to
+// SetTeeDeadline. A WriteTo which fell back to a generic copy before a
+// target was attached, because dst does not support splice(2), does not
+// observe the new target.
 //
-// Tee must not be called concurrently with I/O methods, and must be called
-// only once, and before any calls to Read or WriteTo.
+// Under the TeeStall policy, Tee also resumes Read and WriteTo calls
+// which are waiting for a replacement for a failed target. See
+// SetTeePolicy.
+//
+// Data is mirrored as it is read from the pipe. The tee is detached when
+// the read side of the pipe observes EOF, or when the pipe is closed using
+// Close or CloseRead. Data which is still in the pipe when it is closed is
+// never mirrored. See also SetTeeCloseWrite.
 func (p *Pipe) Tee(w io.Writer) {
+	p.teemu.Lock()
+	defer p.teemu.Unlock()
+
+	p.teew = w
 	p.tee(w)
+	atomic.AddUint32(&p.teegen, 1)
+	p.trace("tee", 0, nil)
+	if p.teestall != nil {
+		close(p.teestall)
+		p.teestall = nil
+	}
+}
+
+// TeeAll is like Tee, but mirrors data to all of the specified writers.
+// Calling TeeAll with no writers is misuse: unless the misuse policy is
+// MisusePanic, it detaches the tee, as DetachTee does.
+//
+// If all the writers are of concrete type *Pipe, data is mirrored to each
+// of them directly, using tee(2) where possible: Read tees the data to
+// every target before it consumes it from p. If a target has room for
+// less than the first one accepted, the rest is written to it from the
+// data Read returns, waiting for the target to gain space, subject to
+// SetTeeDeadline. WriteTo copies the data through userspace, by way of
+// Read, if there is more than one target.
+//
+// Otherwise, data is written to each writer in turn, as by io.MultiWriter.
+//
+// In both cases, a failure of any of the writers is a failure of the tee
+// target, subject to the tee policy. SetTeeCloseWrite and SetTeeDeadline
+// apply to all the targets.
+func (p *Pipe) TeeAll(ws ...io.Writer) {
+	if len(ws) == 0 {
+		misuse("TeeAll", "no writers")
+		p.DetachTee()
+		return
+	}
+	p.Tee(multiTee(ws))
+}
+
+// SetTeeCloseWrite sets whether the write side of the tee target is closed
+// when the tee is detached, so that readers of the target observe EOF. If
+// the target is a *Pipe, or implements a CloseWrite method, as
+// *net.TCPConn and *net.UnixConn do, that method is called. Otherwise,
+// the setting has no effect. By default, the tee target is left open.
+//
+// SetTeeCloseWrite must be called before any calls to Read or WriteTo.
+func (p *Pipe) SetTeeCloseWrite(v bool) {
+	p.teemu.Lock()
+	p.teeCloseWrite = v
+	p.teemu.Unlock()
+}
+
+// SetTeeDeadline sets the deadline for waiting on the tee target to gain
+// space, which happens if the reader of the target falls behind. If the
+// deadline expires, Read and WriteTo return ErrTeeTimeout, and leave the
+// data which could not be mirrored in p, so that the call may be retried
+// after extending the deadline. A zero value for t means no deadline.
+//
+// SetTeeDeadline sets the write deadline of the tee target, which must be
+// a *Pipe, or, for targets set by TeeAll, of each of the targets, which
+// must all be pipes. On platforms other than Linux, and when writing the
+// part of the data which TeeAll could not tee, the tee target reports the
+// timeout instead, and data which could not be mirrored is nevertheless
+// consumed from p.
+func (p *Pipe) SetTeeDeadline(t time.Time) error {
+	p.teemu.Lock()
+	w := p.teew
+	p.teemu.Unlock()
+	pipes := teeTargetPipes(w)
+	if pipes == nil {
+		return errors.New("zerocopy: SetTeeDeadline: tee target is not a *Pipe")
+	}
+	for _, tp := range pipes {
+		if err := tp.w.SetWriteDeadline(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Salvage writes the data held in p to w, without waiting for more data to
+// arrive, and returns the number of bytes written. It is meant for
+// recovering data which was read from a source, but not delivered to a
+// destination, after a transfer through p failed, such that the data can
+// be sent to an alternate destination. Salvage does not mirror data to a
+// tee set on p.
+//
+// If writing to w fails, Salvage returns the error, and the rest of the
+// data remains in p. Salvage must not be called concurrently with other
+// methods which read from p. On platforms other than Linux, Salvage
+// returns an error.
+func (p *Pipe) Salvage(w io.Writer) (int64, error) {
+	if atomic.LoadInt32(&p.rclosed) != 0 {
+		return 0, ErrClosedPipe
+	}
+	n, err := p.salvage(w)
+	count(&p.stats.bytesOut, n)
+	return n, err
+}
+
+// DetachTee removes the tee target of p, if any, so that data is no longer
+// mirrored, and closes the write side of the target, if so configured
+// using SetTeeCloseWrite. DetachTee may be called concurrently with Read
+// and WriteTo. See Tee for when the change takes effect.
+//
+// Closing the write side of the target may make a concurrent mirroring
+// operation fail. Such failures are not reported, since the target has
+// been detached.
+func (p *Pipe) DetachTee() error {
+	p.teemu.Lock()
+	defer p.teemu.Unlock()
+
+	if p.teew == nil {
+		return nil
+	}
+	w := p.teew
+	p.teew = nil
+	p.tee(nil)
+	atomic.AddUint32(&p.teegen, 1)
+	p.trace("detach tee", 0, nil)
+	if !p.teeCloseWrite {
+		return nil
+	}
+	return closeWriteTarget(w)
 }
 
 // Transfer is like io.Copy, but moves data through a pipe rather than through
@@ -130,6 +656,138 @@ func (p *Pipe) Tee(w io.Writer) {
 // 	p.WriteTo(upstream)
 //
 // but in more compact form, and slightly more resource-efficient.
+//
+// On Linux, if src and dst are both *os.File values which refer to regular
+// files, Transfer uses copy_file_range(2), which moves data between the
+// files without a pipe, and lets some file systems copy it on the server
+// side. If copy_file_range(2) is not supported for the files, Transfer
+// splices through a pipe, or copies through a buffer, as usual.
+//
+// Unix sockets with addresses in the abstract namespace ("@name") are
+// spliced like other Unix sockets. Sockets which preserve message
+// boundaries, such as those on the "udp", "unixgram" and "unixpacket"
+// networks, are not spliced, since splice(2) would truncate messages
+// larger than the pipe buffer. Transfer copies data from them one whole
+// message at a time, with one Write to dst per message.
+//
+// If src is an *io.LimitedReader, Transfer honors the limit, and updates
+// src.N. This makes it possible to pass through a region of known length
+// of a stream which is otherwise inspected in userspace.
+//
+// Transfer honors deadlines set on src and dst, such as those set by
+// net.Conn.SetReadDeadline and net.Conn.SetWriteDeadline, as io.Copy would:
+// waiting for src to become readable is subject to its read deadline, and
+// waiting for dst to become writable is subject to its write deadline. If
+// a deadline expires, Transfer returns an error which implements net.Error,
+// and whose Timeout method returns true.
+//
+// The results of Transfer follow the contract of io.Copy: n is the number
+// of bytes written to dst, a successful Transfer returns err == nil rather
+// than io.EOF, and if src is an *io.LimitedReader, src.N accounts for all
+// the data read from src, including data which could not be written to
+// dst. The differences are in the type of some errors: failures of
+// splice(2) are reported as *TransferError values, rather than in the
+// form the Read or Write methods of src or dst would use, and errors which
+// leave data read from src in the internal pipe are reported as
+// *StagedError values.
 func Transfer(dst io.Writer, src io.Reader) (int64, error) {
+	defer startTransfer()()
 	return transfer(dst, src)
 }
+
+// Transfer is like the package-level Transfer, but splices through p,
+// rather than through a pipe taken from the internal pool. This suits
+// applications which manage pipes of their own, for example to give them
+// a custom buffer size using SetBufferSize.
+//
+// p must be empty, and must not be used otherwise, including by Close,
+// until Transfer returns. Transfer does not mirror data to the tee target
+// of p, if any. Data left in p by a failed write to dst is recovered into
+// a *StagedError, so that p is empty again, and can be reused for another
+// transfer. The exception is strict mode: if dst cannot be spliced to,
+// Transfer returns a *StagedError wrapping ErrFallbackRequired, and the
+// staged bytes stay in p, for the caller to read.
+func (p *Pipe) Transfer(dst io.Writer, src io.Reader) (int64, error) {
+	defer startTransfer()()
+	return transferPipe(p, dst, src)
+}
+
+// CopyBuffer is like Transfer, but generic copies go through buf, rather
+// than through a buffer from the internal pool, as with io.CopyBuffer.
+// This eases migration for code which manages its own buffers. If buf is
+// nil, a pooled buffer is used. If buf is not nil, and has length zero,
+// CopyBuffer panics.
+//
+// As with io.CopyBuffer, buf is not used if src implements io.WriterTo,
+// or dst implements io.ReaderFrom, as *net.TCPConn and *Pipe do.
+func CopyBuffer(dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	if buf != nil && len(buf) == 0 {
+		panic("zerocopy: empty buffer in CopyBuffer")
+	}
+	defer startTransfer()()
+	return transferPipeBuffer(nil, dst, src, buf)
+}
+
+var stdlibDelegation int32 // atomic; 1 if enabled
+
+// SetStdlibDelegation sets whether Transfer, CopyBuffer and
+// TransferAccounted delegate transfers to a *net.TCPConn from a TCP
+// connection, or, since Go 1.15, from a Unix stream socket, to the ReadFrom
+// method of the destination, which splices on its own. Delegation is
+// disabled by default. It never applies to Pipe.Transfer, which always
+// moves data through its pipe, nor on platforms other than Linux.
+//
+// The standard library has direct access to the runtime network poller,
+// and may move data faster. BenchmarkTransfer and BenchmarkStdlibCopy
+// compare the two on the target system. Delegated transfers lose some of
+// the guarantees of this package, however: data read from the source but
+// not written to the destination is lost, rather than returned in a
+// *StagedError, and errors are attributed to a side only as described for
+// TransferError.
+func SetStdlibDelegation(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&stdlibDelegation, v)
+}
+
+// stdlibDelegationEnabled reports whether SetStdlibDelegation enabled
+// delegation.
+func stdlibDelegationEnabled() bool {
+	return atomic.LoadInt32(&stdlibDelegation) != 0
+}
+
+// TransferN is like io.CopyN, but moves data through a pipe, as Transfer
+// does. It copies n bytes, or until an error occurs, and returns the
+// number of bytes copied. On return, written == n if and only if err ==
+// nil. If src reaches EOF before n bytes have been copied, TransferN
+// returns io.EOF.
+func TransferN(dst io.Writer, src io.Reader, n int64) (written int64, err error) {
+	written, err = Transfer(dst, &io.LimitedReader{R: src, N: n})
+	if written == n {
+		return n, nil
+	}
+	if written < n && err == nil {
+		// src stopped early; must have been EOF.
+		err = io.EOF
+	}
+	return written, err
+}
+
+// TransferExactly is to TransferN what io.ReadFull is to io.Reader.Read:
+// it moves exactly n bytes from src to dst, and reports a source which
+// ends early as an error. On return, written == n if and only if err ==
+// nil. If src reaches EOF before any data has been moved, TransferExactly
+// returns io.EOF. If it reaches EOF after moving some, but not all of the
+// data, TransferExactly returns io.ErrUnexpectedEOF.
+//
+// TransferExactly is meant for protocols which announce the length of a
+// payload before sending it, where a short payload is a protocol error.
+func TransferExactly(dst io.Writer, src io.Reader, n int64) (written int64, err error) {
+	written, err = TransferN(dst, src, n)
+	if err == io.EOF && written > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return written, err
+}
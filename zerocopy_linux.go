@@ -13,11 +13,13 @@ splice(2) and tee(2) operate on two. This makes using them from Go quite
 tricky. Special precautions have to be taken in order to avoid a certain
 class of deadlocks, and play nice with the outside world.
 
-The algorithm to acheive this is rather complicated and subtle, but it is
-used in a number of places in this package, with minor adaptations based on
-context. This comment describes the algorithm. Because the real Go code that
-implements the algorithm is much more messy, and involves more book-keeping
-in terms of error handling, the algorithm is presented in pseudo-code.
+The algorithm to acheive this is rather complicated and subtle. It is
+implemented once, by TwoFDWaiter, which is used in a number of places in this
+package, and is exported for the benefit of callers with two-fd system calls
+of their own. This comment describes the algorithm. Because the real Go code
+that implements the algorithm is much more messy, and involves more
+book-keeping in terms of error handling, the algorithm is presented in
+pseudo-code.
 
 Preparatory definitions
 -----------------------
@@ -31,6 +33,9 @@ transfer is a data transfer function, either splice(2) or tee(2).
 wait waits for its file descriptor argument to be ready for an operation,
 either 'r' for reading, or 'w' for writing.
 
+readable reports whether its file descriptor argument is readable right
+now, without waiting, using poll(2).
+
 The algorithm
 -------------
 
@@ -45,7 +50,7 @@ The algorithm
 		increfscope(wfd) {
 			transfered, errno = transfer(rfd, wfd)
 			if errno is EAGAIN {
-				if readready {
+				if readready or readable(rfd) {
 					goto round2
 				}
 			} else {
@@ -111,15 +116,36 @@ perform any operation on rfd, such as a hypothetical close(rfd), then the
 other caller is blocked, waiting for an unrelated operation, on another
 file descriptor.
 
+The check for readable(rfd) in round 1 matters because readiness
+notifications from the runtime network poller are edge-triggered. If an
+earlier wait(rfd, 'r') consumed the notification, and the data is still
+there because wfd was full, there may be no new notification: the writer
+on the other side of rfd may itself be waiting for us to read. Waiting for
+rfd again would then block forever.
+
 The algorithm is so complicated because has to take great care to not end up
 in a situation equivalent to this snippet. See also golang.org/issues/25985
 for an example of such a bug, from the stdlib splice implementation.
 
-Any changes to this package must retain these properties.
+Any changes to this package must retain these properties. When built with
+the zerocopydebug build tag, the package checks them at run time: a wait on
+a file descriptor while holding a reference to another one panics. Run the
+tests with -tags zerocopydebug after changing any of the algorithms.
+
+writeToTee, which coalesces tee(2) and splice(2) across three file
+descriptors, implements its own variant of the algorithm, following the
+same rules.
+
+The pipe used by Transfer is the one exception: it is either taken from
+the internal pool, and never visible to callers, or passed to
+Pipe.Transfer, which forbids other uses of the pipe while it runs, so
+nobody else can block on it. spliceDrain and splicePump rely on this, and
+are not checked.
 */
 
 import (
 	"io"
+	"net"
 	"os"
 	"syscall"
 
@@ -148,10 +174,13 @@ func (p *Pipe) bufferSize() (int, error) {
 	return int(size), nil
 }
 
-func (p *Pipe) setBufferSize(n int) error {
-	var errno syscall.Errno
+func (p *Pipe) setBufferSize(n int) (int, error) {
+	var (
+		size  uintptr
+		errno syscall.Errno
+	)
 	err := p.wrc.Control(func(fd uintptr) {
-		_, _, errno = unix.Syscall(
+		size, _, errno = unix.Syscall(
 			unix.SYS_FCNTL,
 			fd,
 			unix.F_SETPIPE_SZ,
@@ -159,28 +188,68 @@ func (p *Pipe) setBufferSize(n int) error {
 		)
 	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if errno != 0 {
-		return os.NewSyscallError("setpipesz", errno)
+		return 0, os.NewSyscallError("setpipesz", errno)
+	}
+	return int(size), nil
+}
+
+// pipeBuffered returns the number of bytes in the pipe referred to by fd.
+func pipeBuffered(fd uintptr) (int, error) {
+	// TIOCINQ is FIONREAD, on Linux.
+	n, err := unix.IoctlGetInt(int(fd), unix.TIOCINQ)
+	if err != nil {
+		return 0, os.NewSyscallError("ioctl", err)
+	}
+	return n, nil
+}
+
+// pipeAtEOF reports whether the pipe referred to by fd, which must be the
+// read side, is empty, and has no writers left.
+func pipeAtEOF(fd uintptr) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		_, err := unix.Poll(fds, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, os.NewSyscallError("poll", err)
+		}
+		return fds[0].Revents&unix.POLLHUP != 0, nil
 	}
-	return nil
 }
 
 func (p *Pipe) read(b []byte) (int, error) {
 	// There are three cases here:
 	//
 	// If p is not configured to tee data to another writer, then
-	// p.teepipe is nil, and p.teerd is p.r.
+	// p.teepipes is empty, and p.teerd is p.r.
 	//
 	// If p is configured to tee data to an io.Writer that is not a *Pipe,
-	// then p.teepipe is nil, and p.teerd is an io.TeeReader of p.r and
+	// then p.teepipes is empty, and p.teerd is a teeReader of p.r and
 	// the io.Writer.
 	//
-	// Finally, if p is configured to tee data to another *Pipe, then
-	// p.teepipe is not nil, and p.teerd is p.r.
-	if p.teepipe == nil {
-		return p.teerd.Read(b)
+	// Finally, if p is configured to tee data to one or more pipes, then
+	// p.teepipes holds them, and p.teerd is p.r.
+	//
+	// The configuration may change concurrently, so we work on a
+	// snapshot of it. Most pipes never have a tee, however, so until
+	// one is first attached, which moves the generation past zero, we
+	// skip the snapshot and read directly.
+	if !p.teeChanged(0) {
+		return p.readDirect(b)
+	}
+	teerd, teepipes, gen := p.teeState()
+	if len(teepipes) == 0 {
+		// Wait for data before reading, rather than in teerd.Read, so
+		// that a target attached while we wait sees the data.
+		if p.awaitRead(gen) {
+			return 0, errTeeChanged
+		}
+		return p.readTee(teerd, gen, b)
 	}
 
 	// Here, we are on the tee(2) code path. When more than one stream of
@@ -193,102 +262,189 @@ func (p *Pipe) read(b []byte) (int, error) {
 	// the library to be either very configurable, or very opinionated.
 	//
 	// HC SVNT DRACONES. See the comment at the top of the file.
+	teepipe := teepipes[0]
 	var (
-		copied int64
-		operr  error // error from tee(2)
-		rrcerr error // non-nil if read FD is dead
-		wrcerr error // non-nil if write FD is dead
-
-		waitread      = false
-		readready     = false
-		done          = false
-		writeready    = false
-		waitwrite     = false
-		waitreadagain = false
+		copied  int64
+		pollerr error // error from checking the state of our pipe
 	)
-again:
-	rrcerr = p.rrc.Read(func(prfd uintptr) bool {
-		wrcerr = p.teepipe.wrc.Write(func(pwfd uintptr) bool {
-			copied, operr = tee(prfd, pwfd, len(b))
-			if operr == unix.EAGAIN {
-				if !readready {
-					waitread = true
-				}
-				return true
-			}
-			done = true
-			operr = os.NewSyscallError("tee", operr)
-			return true
-		})
-		if waitread {
-			readready = true
-			waitread = false
-			return false
+	tw := TwoFDWaiter{R: p.rrc, W: teepipe.wrc}
+	operr, _, wrcerr := tw.do(func(prfd, pwfd uintptr) error {
+		var err error
+		copied, err = tee(prfd, pwfd, len(b))
+		p.countTee(copied, err)
+		if err != unix.EAGAIN {
+			return err
 		}
-		return true
-	})
-	if rrcerr != nil || done {
-		goto end
-	}
-	wrcerr = p.teepipe.wrc.Write(func(pwfd uintptr) bool {
-		p.rrc.Read(func(prfd uintptr) bool {
-			copied, operr = tee(prfd, pwfd, len(b))
-			if operr == unix.EAGAIN {
-				if writeready {
-					waitreadagain = true
-				} else {
-					waitwrite = true
-				}
-				return true
-			}
-			operr = os.NewSyscallError("tee", operr)
-			return true
-		})
-		if waitwrite {
-			writeready = true
-			waitwrite = false
-			return false
+		// Either the pipe is empty, or the pipe we tee to is full.
+		// Readiness notifications are edge-triggered, so we must not
+		// wait for our pipe in the latter case, nor if it is at EOF,
+		// which tee(2) does not report if the other pipe is full.
+		var inpipe int
+		inpipe, pollerr = pipeBuffered(prfd)
+		if pollerr != nil {
+			return pollerr
 		}
-		return true
+		if inpipe > 0 {
+			return ErrWaitWrite
+		}
+		var atEOF bool
+		atEOF, pollerr = pipeAtEOF(prfd)
+		if pollerr != nil {
+			return pollerr
+		}
+		if atEOF {
+			return nil
+		}
+		return ErrWaitRead
 	})
-	if wrcerr != nil {
-		goto end
+	if (wrcerr != nil || operr != nil) && p.teeChanged(gen) {
+		// The target was detached or replaced while we were
+		// waiting for it, or teeing to it. Start over.
+		return 0, errTeeChanged
 	}
-	if waitreadagain {
-		goto again
+	if isTimeout(wrcerr) {
+		return 0, ErrTeeTimeout
 	}
-end:
-	// If rrcerr is not nil, we do not report it immediately: a Read on
-	// a syscall.RawConn only returns an error if the file descriptor
-	// is closed. If the read side of the pipe we own is indeed closed,
-	// the next call to Read on p.teerd will observe this condition. In
-	// that case, we let the better error reporting of package os kick in.
+	if pollerr != nil {
+		return 0, pollerr
+	}
+	if operr != nil {
+		operr = os.NewSyscallError("tee", operr)
+	}
+	// If waiting for the read side fails, we do not report the error
+	// immediately: a Read on a syscall.RawConn only returns an error if
+	// the file descriptor is closed. If the read side of the pipe we
+	// own is indeed closed, the next call to Read on p.teerd will
+	// observe this condition. In that case, we let the better error
+	// reporting of package os kick in.
 	//
 	// As for write errors on the pipe we tee to, if the target FD is
 	// closed, then the pipeline is dead anyway. All we've done so far
 	// is to try to tee from p.r. We haven't consumed anything from the
-	// pipe. Nevertheless, we should read from the pipe, but report
-	// the dead pipe file descriptor as an error, since this is what
-	// io.TeeReader does as well.
+	// pipe. Under TeeFailPrimary, we should nevertheless read from the
+	// pipe, but report the dead pipe file descriptor as an error, since
+	// this is what io.TeeReader does as well. Under the other policies,
+	// we leave the data in the pipe, for the caller to retry.
 	//
 	// Finally, we must be careful not to read more than we copied to
 	// the other pipe, otherwise we will have missed tee-ing some data.
+	teeerr := wrcerr
+	if teeerr == nil {
+		teeerr = operr
+	}
+	if teeerr != nil && p.teepolicy != TeeFailPrimary {
+		return 0, teeError{teeerr}
+	}
 	limit := len(b)
 	if copied > 0 {
 		limit = int(copied)
 	}
-	n, err := p.teerd.Read(b[:limit])
-	if wrcerr != nil {
-		return n, wrcerr
+	// The other targets, if any, must receive the same data as the
+	// first one. Tee as much of it to them as they have room for, then
+	// write the rest from b, once we have read it.
+	var teed []int
+	if len(teepipes) > 1 {
+		teed = p.teeRest(teepipes[1:], int(copied))
 	}
-	if operr != nil {
-		return n, operr
+	n, err := teerd.Read(b[:limit])
+	if teed != nil && n > 0 {
+		if err := p.mirrorRest(teepipes[1:], teed, b[:n]); err != nil && teeerr == nil {
+			teeerr = err
+		}
+	}
+	if teeerr != nil {
+		return n, teeError{teeerr}
 	}
 	return n, err
 }
 
+// teeRest tees up to max bytes from p to each of the targets, without
+// waiting for them, and returns the number of bytes each one received.
+func (p *Pipe) teeRest(targets []*Pipe, max int) []int {
+	teed := make([]int, len(targets))
+	if max <= 0 {
+		return teed
+	}
+	p.rrc.Control(func(prfd uintptr) {
+		for i, tp := range targets {
+			tp.wrc.Control(func(twfd uintptr) {
+				n, err := tee(prfd, twfd, max)
+				p.countTee(n, err)
+				if err == nil {
+					teed[i] = int(n)
+				}
+			})
+		}
+	})
+	return teed
+}
+
+// mirrorRest writes the part of b which teeRest did not tee to each of the
+// targets, as reported by teed. Writes wait for the targets to gain space,
+// subject to SetTeeDeadline.
+func (p *Pipe) mirrorRest(targets []*Pipe, teed []int, b []byte) error {
+	for i, tp := range targets {
+		if teed[i] >= len(b) {
+			continue
+		}
+		n, err := tp.Write(b[teed[i]:])
+		count(&p.stats.teed, int64(n))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 const maxSpliceSize = 4 << 20
 
+// readDirect reads from p, which has never had a tee, with a single
+// read(2) call if data is available. If a tee is attached while we wait
+// for data, readDirect returns errTeeChanged without reading, so that the
+// new target sees the data.
+func (p *Pipe) readDirect(b []byte) (int, error) {
+	var (
+		n       int
+		operr   error
+		changed bool
+	)
+	err := p.rrc.Read(func(prfd uintptr) bool {
+		if p.teeChanged(0) {
+			changed = true
+			return true
+		}
+		n, operr = unix.Read(int(prfd), b)
+		return operr != unix.EAGAIN
+	})
+	if changed {
+		return 0, errTeeChanged
+	}
+	if err != nil || operr != nil {
+		// Let os.File report the error, as it would have had we
+		// called p.r.Read directly.
+		return p.r.Read(b)
+	}
+	if n == 0 && len(b) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// awaitRead waits for the read side of p to become readable, or to reach
+// EOF, without consuming anything from it. It reports whether the tee
+// target of p changed since generation gen in the meantime. Errors from
+// waiting are not reported: the subsequent read observes them.
+func (p *Pipe) awaitRead(gen uint32) (changed bool) {
+	p.rrc.Read(func(prfd uintptr) bool {
+		if p.teeChanged(gen) {
+			changed = true
+			return true
+		}
+		return fdReadable(prfd)
+	})
+	return changed
+}
+
 func (p *Pipe) readFrom(src io.Reader) (int64, error) {
 	// If src is a limited reader, honor the limit.
 	var (
@@ -304,242 +460,399 @@ func (p *Pipe) readFrom(src io.Reader) (int64, error) {
 	}
 	sc, ok := rd.(syscall.Conn)
 	if !ok {
-		return io.Copy(p.w, src)
+		count(&p.stats.fallbacks, 1)
+		return p.copyGeneric(p.w, src)
 	}
 	rrc, err := sc.SyscallConn()
 	if err != nil {
-		return io.Copy(p.w, src)
+		count(&p.stats.fallbacks, 1)
+		return p.copyGeneric(p.w, src)
+	}
+	if typ := socketType(rd); isMessageSocketType(typ) {
+		// splice(2) would truncate messages larger than the space
+		// left in the pipe.
+		count(&p.stats.fallbacks, 1)
+		if p.isStrict() {
+			return 0, ErrDatagramFallback
+		}
+		return copyMessages(p.w, src, typ, nil)
 	}
+	rfile := isRegularFile(rd)
 
-	var (
-		atEOF  bool
-		moved  int64
-		operr  error
-		rrcerr error
-		wrcerr error
-
-		fallback      = false
-		waitread      = false
-		readready     = false
-		writeready    = false
-		waitwrite     = false
-		waitreadagain = false
-	)
+	var moved int64
 	if lr != nil {
 		defer func(v *int64) {
 			lr.N -= *v
 		}(&moved)
 	}
-again:
-	ok = false
-	max := maxSpliceSize
-	if int64(max) > limit {
-		max = int(limit)
+	tw := TwoFDWaiter{R: rrc, W: p.wrc}
+	for limit > 0 {
+		max := maxSpliceSize
+		if int64(max) > limit {
+			max = int(limit)
+		}
+		var n int
+		release := acquireFileIOIf(rfile)
+		operr, rrcerr, wrcerr := tw.do(func(rfd, pwfd uintptr) error {
+			var err error
+			n, err = splice(rfd, pwfd, max)
+			p.countSplice(n, err, false)
+			return err
+		})
+		release()
+		if rrcerr != nil {
+			return moved, rrcerr
+		}
+		if wrcerr != nil {
+			return moved, wrcerr
+		}
+		if operr == unix.EINVAL {
+			goto generic
+		}
+		if operr != nil {
+			return moved, os.NewSyscallError("splice", operr)
+		}
+		if n == 0 {
+			return moved, nil
+		}
+		limit -= int64(n)
+		moved += int64(n)
+	}
+	return moved, nil
+generic:
+	// src does not support splice(2). This is usually detected on the
+	// first try, but we may have moved some data already if the pipe
+	// was full at the time, so account for it when switching to
+	// a generic copy.
+	p.trace("fallback", moved, nil)
+	count(&p.stats.fallbacks, 1)
+	if lr != nil {
+		src = &io.LimitedReader{R: rd, N: limit}
+	}
+	n, err := p.copyGeneric(p.w, src)
+	moved += n
+	return moved, err
+}
+
+func (p *Pipe) writeTo(dst io.Writer) (int64, error) {
+	sc, ok := dst.(syscall.Conn)
+	if !ok {
+		count(&p.stats.fallbacks, 1)
+		return p.copyGeneric(dst, p.fallbackReader())
+	}
+	wrc, err := sc.SyscallConn()
+	if err != nil {
+		count(&p.stats.fallbacks, 1)
+		return p.copyGeneric(dst, p.fallbackReader())
+	}
+	teerd, teepipes, gen := p.teeState()
+	if len(teepipes) == 1 {
+		return p.writeToTee(dst, wrc, teepipes[0], gen)
+	}
+	if teerd != io.Reader(p.r) || len(teepipes) > 1 {
+		// Data must be mirrored to a writer which is not a pipe, or
+		// to several pipes, which Read handles, so it must pass
+		// through userspace anyway.
+		count(&p.stats.fallbacks, 1)
+		return p.copyGeneric(dst, p.fallbackReader())
 	}
-	rrcerr = rrc.Read(func(rfd uintptr) bool {
-		wrcerr = p.wrc.Write(func(pwfd uintptr) bool {
+	var moved int64
+	tw := TwoFDWaiter{R: p.rrc, W: wrc}
+	for {
+		var n int
+		operr, rrcerr, wrcerr := tw.do(func(rfd, wfd uintptr) error {
+			if p.teeChanged(gen) {
+				// A tee target was attached.
+				return errTeeChanged
+			}
+			var err error
+			n, err = splice(rfd, wfd, maxSpliceSize)
+			p.countSplice(n, err, true)
+			return err
+		})
+		if operr == errTeeChanged {
+			return moved, operr
+		}
+		if rrcerr != nil {
+			return moved, rrcerr
+		}
+		if wrcerr != nil {
+			return moved, wrcerr
+		}
+		if operr == unix.EINVAL {
+			goto generic
+		}
+		if operr != nil {
+			return moved, os.NewSyscallError("splice", operr)
+		}
+		if n == 0 {
+			return moved, nil
+		}
+		moved += int64(n)
+	}
+generic:
+	// See the corresponding comment in readFrom.
+	p.trace("fallback", moved, nil)
+	count(&p.stats.fallbacks, 1)
+	n, err := p.copyGeneric(dst, p.fallbackReader())
+	moved += n
+	return moved, err
+}
+
+// writeToTee implements writeTo for pipes which tee to another pipe.
+//
+// Data is mirrored using tee(2), then moved to dst using splice(2).
+// In the common case, both steps are driven by a single wakeup on the
+// read side of p: once the pipe is readable, we tee, then splice what we
+// teed, without waiting in between. Only if dst is not ready to accept
+// all of it do we wait for dst, in a separate round.
+//
+// Data which has been teed, but not yet spliced, must not be teed
+// again. We keep track of it in pending, and drain it before teeing
+// anything new.
+//
+// tp is the tee target, as of generation gen of the tee configuration of
+// p. If the configuration changes, writeToTee returns errTeeChanged once
+// no teed data is pending.
+func (p *Pipe) writeToTee(dst io.Writer, wrc syscall.RawConn, tp *Pipe, gen uint32) (int64, error) {
+	var (
+		s       fdscope
+		moved   int64
+		copied  int64
+		pending int
+		operr   error
+		rrcerr  error
+		twrcerr error // non-nil if the tee target FD is dead
+		wrcerr  error
+
+		atEOF      = false
+		fallback   = false
+		teefull    = false
+		writeready = false
+	)
+	// spliceOut splices pending bytes from the pipe to dst, and never
+	// waits. It must be called with a reference to the read side of
+	// the pipe held.
+	spliceOut := func(prfd uintptr) {
+		wrcerr = s.write(wrc, func(wfd uintptr) bool {
 			var n int
-			n, operr = splice(rfd, pwfd, max)
+			n, operr = splice(prfd, wfd, pending)
+			p.countSplice(n, operr, true)
 			if n > 0 {
-				limit -= int64(n)
+				pending -= n
 				moved += int64(n)
 			}
-			if operr == unix.EINVAL {
+			switch operr {
+			case nil, unix.EAGAIN:
+				operr = nil
+			case unix.EINVAL:
+				operr = nil
 				fallback = true
-				return true
-			}
-			if operr == unix.EAGAIN {
-				waitread = !readready
-				return true
-			}
-			if operr == nil {
-				if n == 0 {
-					atEOF = true
-				} else {
-					ok = true
-				}
+			default:
+				operr = os.NewSyscallError("splice", operr)
 			}
-			operr = os.NewSyscallError("splice", operr)
 			return true
 		})
-		if waitread {
-			readready = true
-			waitread = false
-			return false
-		}
-		return true
-	})
-	if fallback {
-		return io.Copy(p.w, src)
 	}
-	if wrcerr != nil || atEOF {
-		return moved, wrcerr
+again:
+	if pending > 0 {
+		goto drain
 	}
-	if ok {
-		if limit > 0 {
-			goto again
-		}
-		goto end
+	if p.teeChanged(gen) {
+		return moved, errTeeChanged
 	}
-	// If we're here, we have not spliced yet on this round, and we're
-	// waiting for the pipe to be ready.
-	wrcerr = p.wrc.Write(func(pwfd uintptr) bool {
-		rrcerr = rrc.Read(func(rfd uintptr) bool {
-			var n int
-			n, operr = splice(rfd, pwfd, max)
-			if n > 0 {
-				limit -= int64(n)
-				moved += int64(n)
+
+	// Round 1: wait for the pipe to be readable, then tee and splice.
+	teefull = false
+	rrcerr = s.read(p.rrc, func(prfd uintptr) bool {
+		twrcerr = s.write(tp.wrc, func(twfd uintptr) bool {
+			copied, operr = tee(prfd, twfd, maxSpliceSize)
+			p.countTee(copied, operr)
+			return true
+		})
+		if twrcerr != nil {
+			return true
+		}
+		switch {
+		case operr == unix.EAGAIN:
+			// Either the pipe is empty, or the tee target is full.
+			// Since readiness notifications are edge-triggered, we
+			// must not wait for the pipe in the latter case, so
+			// find out which one it is. Furthermore, tee(2) checks
+			// the target before it checks for EOF, so if the pipe
+			// is empty, we must check for EOF ourselves.
+			var inpipe int
+			inpipe, operr = pipeBuffered(prfd)
+			if operr != nil {
+				return true
 			}
-			if operr == unix.EAGAIN {
-				if writeready {
-					waitwrite = false
-					waitreadagain = true
-				} else {
-					waitwrite = true
-				}
+			if inpipe > 0 {
+				teefull = true
 				return true
 			}
-			operr = os.NewSyscallError("splice", operr)
+			atEOF, operr = pipeAtEOF(prfd)
+			return atEOF || operr != nil
+		case operr != nil:
+			operr = teeError{os.NewSyscallError("tee", operr)}
+			return true
+		case copied == 0:
+			atEOF = true
 			return true
-		})
-		if waitwrite {
-			writeready = true
-			waitwrite = false
-			return false
 		}
+		pending = int(copied)
+		spliceOut(prfd)
 		return true
 	})
 	if rrcerr != nil {
 		return moved, rrcerr
 	}
+	if _, ok := operr.(teeError); (ok || twrcerr != nil) && p.teeChanged(gen) {
+		// The target was detached or replaced while we were teeing
+		// to it, and nothing was teed.
+		return moved, errTeeChanged
+	}
+	if isTimeout(twrcerr) {
+		return moved, ErrTeeTimeout
+	}
+	if twrcerr != nil {
+		return moved, teeError{twrcerr}
+	}
 	if wrcerr != nil {
 		return moved, wrcerr
 	}
 	if operr != nil {
 		return moved, operr
 	}
-	if limit > 0 || waitreadagain {
-		goto again
+	if fallback {
+		goto generic
 	}
-end:
-	return moved, nil
-}
-
-func (p *Pipe) writeTo(dst io.Writer) (int64, error) {
-	sc, ok := dst.(syscall.Conn)
-	if !ok {
-		return io.Copy(dst, onlyReader{p})
+	if atEOF {
+		return moved, nil
 	}
-	wrc, err := sc.SyscallConn()
-	if err != nil {
-		return io.Copy(dst, onlyReader{p})
+	if !teefull {
+		goto again
 	}
 
-	var (
-		atEOF  bool
-		moved  int64
-		operr  error
-		rrcerr error
-		wrcerr error
-
-		fallback      = false
-		waitread      = false
-		readready     = false
-		writeready    = false
-		waitwrite     = false
-		waitreadagain = false
-	)
-again:
-	ok = false
-	rrcerr = p.rrc.Read(func(rfd uintptr) bool {
-		wrcerr = wrc.Write(func(pwfd uintptr) bool {
-			var n int
-			n, operr = splice(rfd, pwfd, maxSpliceSize)
-			if n > 0 {
-				moved += int64(n)
-			}
-			if operr == unix.EINVAL {
-				fallback = true
-				return true
-			}
-			if operr == unix.EAGAIN {
-				if !readready {
-					waitread = true
-				}
-				return true
-			}
-			if operr == nil {
-				if n == 0 {
-					atEOF = true
-				} else {
-					ok = true
-				}
-			}
-			operr = os.NewSyscallError("splice", operr)
+	// Round 2: wait for the tee target to gain space, then tee.
+	writeready = false
+	twrcerr = s.write(tp.wrc, func(twfd uintptr) bool {
+		rrcerr = s.read(p.rrc, func(prfd uintptr) bool {
+			copied, operr = tee(prfd, twfd, maxSpliceSize)
+			p.countTee(copied, operr)
 			return true
 		})
-		if waitread {
-			readready = true
-			waitread = false
+		if operr == unix.EAGAIN && !writeready {
+			writeready = true
 			return false
 		}
 		return true
 	})
-	if fallback {
-		return io.Copy(dst, onlyReader{p})
+	if (twrcerr != nil || (operr != nil && operr != unix.EAGAIN)) && p.teeChanged(gen) {
+		return moved, errTeeChanged
 	}
-	if wrcerr != nil || atEOF {
-		return moved, wrcerr
+	if isTimeout(twrcerr) {
+		return moved, ErrTeeTimeout
 	}
-	if ok {
-		goto end
+	if twrcerr != nil {
+		return moved, teeError{twrcerr}
+	}
+	if rrcerr != nil {
+		return moved, rrcerr
+	}
+	switch {
+	case operr == unix.EAGAIN:
+		// The pipe may have been empty after all. Start over.
+	case operr != nil:
+		return moved, teeError{os.NewSyscallError("tee", operr)}
+	case copied == 0:
+		return moved, nil
+	default:
+		pending = int(copied)
 	}
+	goto again
 
-	// If we're here, we have not spliced yet on this round, and we're
-	// waiting for the destination file descriptor to be ready.
-	wrcerr = wrc.Write(func(pwfd uintptr) bool {
-		rrcerr = p.rrc.Read(func(rfd uintptr) bool {
+drain:
+	// Round 3: the teed data is already in the pipe, so only wait for
+	// dst to become writable.
+	writeready = false
+	wrcerr = s.write(wrc, func(wfd uintptr) bool {
+		rrcerr = s.read(p.rrc, func(prfd uintptr) bool {
 			var n int
-			n, operr = splice(rfd, pwfd, maxSpliceSize)
+			n, operr = splice(prfd, wfd, pending)
+			p.countSplice(n, operr, true)
 			if n > 0 {
+				pending -= n
 				moved += int64(n)
 			}
-			if operr == unix.EAGAIN {
-				if writeready {
-					waitreadagain = true
-				} else {
-					waitwrite = true
-				}
-				return true
-			}
-			operr = os.NewSyscallError("splice", operr)
 			return true
 		})
-		if waitwrite {
+		if operr == unix.EAGAIN && !writeready {
 			writeready = true
-			waitwrite = false
 			return false
 		}
 		return true
 	})
-	if rrcerr != nil {
-		return moved, rrcerr
-	}
 	if wrcerr != nil {
 		return moved, wrcerr
 	}
-	if operr != nil {
-		return moved, operr
+	if rrcerr != nil {
+		return moved, rrcerr
 	}
-	if waitreadagain {
+	switch operr {
+	case nil, unix.EAGAIN:
 		goto again
+	case unix.EINVAL:
+		goto generic
+	default:
+		return moved, os.NewSyscallError("splice", operr)
 	}
-end:
-	return moved, nil
+
+generic:
+	p.trace("fallback", moved, nil)
+	count(&p.stats.fallbacks, 1)
+	if p.strict {
+		return moved, ErrFallbackRequired
+	}
+	// Data which was teed, but not spliced, must bypass the tee.
+	if pending > 0 {
+		n, err := io.CopyN(dst, p.r, int64(pending))
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+	n, err := p.copyGeneric(dst, p.fallbackReader())
+	moved += n
+	return moved, err
 }
 
 func transfer(dst io.Writer, src io.Reader) (int64, error) {
+	return transferPipe(nil, dst, src)
+}
+
+func transferStrict(dst io.Writer, src io.Reader) (int64, error) {
+	p, err := getPipe()
+	if err != nil {
+		return 0, err
+	}
+	p.strict = true
+	defer func() {
+		p.strict = false
+		putPipe(p)
+	}()
+	return transferPipe(p, dst, src)
+}
+
+// transferPipe implements Transfer. If p is not nil, transferPipe uses p
+// for splicing, rather than allocating a new pipe. If the transfer
+// succeeds, p is left empty, and can be reused.
+func transferPipe(p *Pipe, dst io.Writer, src io.Reader) (int64, error) {
+	return transferPipeBuffer(p, dst, src, nil)
+}
+
+// transferPipeBuffer is like transferPipe, but generic copies use buf, if
+// it is not nil, rather than a pooled buffer.
+func transferPipeBuffer(p *Pipe, dst io.Writer, src io.Reader, buf []byte) (int64, error) {
 	// If src is a limited reader, honor the limit.
 	var (
 		rd    io.Reader
@@ -552,22 +865,52 @@ func transfer(dst io.Writer, src io.Reader) (int64, error) {
 	} else {
 		rd = src
 	}
-	rsc, ok := rd.(syscall.Conn)
-	if !ok {
-		return io.Copy(dst, src)
+	// The choices below are mirrored by probe, in probe_linux.go.
+	if isTLSConn(rd) || isTLSConn(dst) {
+		// Nothing can be spliced, so do not bother inspecting the
+		// other side, or getting a pipe.
+		return p.copyGenericBuffer(dst, src, buf)
 	}
-	rrc, err := rsc.SyscallConn()
-	if err != nil {
-		return io.Copy(dst, src)
+	if rtyp := socketType(rd); isMessageSocketType(rtyp) || isDatagramSocket(dst) {
+		if p.isStrict() {
+			return 0, ErrDatagramFallback
+		}
+		if isMessageSocketType(rtyp) {
+			return copyMessages(dst, src, rtyp, buf)
+		}
+		return copyFallbackBuffer(dst, src, buf)
 	}
-
-	wsc, ok := dst.(syscall.Conn)
-	if !ok {
-		return io.Copy(dst, src)
+	if tc, ok := dst.(*net.TCPConn); ok && p == nil && delegateToStdlib(rd) {
+		// The caller did not supply a pipe, so it does not care which
+		// one carries the data.
+		return readFromStdlib(tc, src)
 	}
-	wrc, err := wsc.SyscallConn()
-	if err != nil {
-		return io.Copy(dst, src)
+	rfile := isRegularFile(rd)
+	if rfile && isRegularFile(dst) {
+		// Between two regular files, copy_file_range(2) keeps the
+		// data in the kernel, or on the server for some network file
+		// systems, and needs no pipe.
+		n, handled, err := copyFileRangeKernel(dst.(*os.File), rd.(*os.File), limit)
+		if lr != nil {
+			lr.N -= n
+		}
+		if handled {
+			return n, err
+		}
+		if n > 0 {
+			// copy_file_range(2) gave up part way through. Move
+			// the rest by other means.
+			rest := &io.LimitedReader{R: rd, N: limit - n}
+			m, err := transferPipeBuffer(p, dst, rest, buf)
+			if lr != nil {
+				lr.N -= limit - n - rest.N
+			}
+			return n + m, err
+		}
+	}
+	rrc, wrc, ok := rawConns(dst, rd)
+	if !ok {
+		return p.copyGenericBuffer(dst, src, buf)
 	}
 
 	// Now, we know that dst and src are two file descriptors
@@ -576,56 +919,196 @@ func transfer(dst io.Writer, src io.Reader) (int64, error) {
 	//
 	// See also src/internal/poll/splice_linux.go, which this code
 	// is a pretty direct translation of.
-	p, err := NewPipe()
+	if p == nil {
+		var err error
+		p, err = getPipe()
+		if err != nil {
+			return p.copyGenericBuffer(dst, src, buf)
+		}
+		defer putPipe(p)
+		sizePipeForSockets(p, rrc, wrc)
+	}
+	return spliceBuffer(p, dst, rd, lr, limit, rrc, wrc, rfile, buf)
+}
+
+// readFromStdlib delegates a transfer from src to tc to the standard
+// library. delegateToStdlib must report true for the reader under src.
+func readFromStdlib(tc *net.TCPConn, src io.Reader) (int64, error) {
+	n, err := tc.ReadFrom(src)
+	if spliceAllowed() {
+		count(&metrics.bytesSplice, n)
+	} else {
+		count(&metrics.bytesGeneric, n)
+	}
+	return n, stdlibSideError(err)
+}
+
+// rawConns returns the raw connections of dst and src, if both have one.
+func rawConns(dst io.Writer, src io.Reader) (rrc, wrc syscall.RawConn, ok bool) {
+	rsc, ok := src.(syscall.Conn)
+	if !ok {
+		return nil, nil, false
+	}
+	rrc, err := rsc.SyscallConn()
 	if err != nil {
-		return io.Copy(dst, src)
+		return nil, nil, false
 	}
+	wsc, ok := dst.(syscall.Conn)
+	if !ok {
+		return nil, nil, false
+	}
+	wrc, err = wsc.SyscallConn()
+	if err != nil {
+		return nil, nil, false
+	}
+	return rrc, wrc, true
+}
 
+// spliceBuffer moves up to limit bytes from rd to dst through p, by
+// splicing between rrc and wrc. If lr is not nil, it is the
+// *io.LimitedReader wrapping rd, and its N field is updated. rfile
+// reports whether rd is a regular file. If either side turns out not to
+// support splicing, spliceBuffer moves the rest of the data using buf.
+func spliceBuffer(p *Pipe, dst io.Writer, rd io.Reader, lr *io.LimitedReader, limit int64, rrc, wrc syscall.RawConn, rfile bool, buf []byte) (int64, error) {
 	var moved int64 = 0
 	if lr != nil {
-		defer func(v *int64) {
-			lr.N -= *v
-		}(&moved)
+		// As with io.Copy, lr.N accounts for all the data read
+		// from src, including data which could not be written to
+		// dst.
+		defer func() {
+			lr.N = limit
+		}()
+	}
+	fallback := func() (int64, error) {
+		r := rd
+		if lr != nil {
+			r = &io.LimitedReader{R: rd, N: limit}
+			defer func() {
+				limit = r.(*io.LimitedReader).N
+			}()
+		}
+		n, err := p.copyGenericBuffer(dst, r, buf)
+		return moved + n, err
 	}
 	for limit > 0 {
 		max := maxSpliceSize
 		if int64(max) > limit {
 			max = int(limit)
 		}
-		inpipe, fallback, err := spliceDrain(p, rrc, max)
-		limit -= int64(inpipe)
-		if fallback {
-			return io.Copy(dst, src)
+		release := acquireFileIOIf(rfile)
+		inpipe, fellback, err := spliceDrain(p, rrc, max)
+		release()
+		if fellback {
+			return fallback()
 		}
+		limit -= int64(inpipe)
 		if inpipe == 0 && err == nil {
 			return moved, nil
 		}
 		if err != nil {
 			return moved, err
 		}
-		n, fallback, err := splicePump(wrc, p, inpipe)
-		if n > 0 {
-			moved += int64(n)
-		}
-		if fallback {
+		n, fellback, err := splicePump(wrc, p, inpipe)
+		moved += int64(n)
+		if fellback {
 			// dst doesn't support splicing, but we've already
 			// read from src, so we need to empty the pipe,
-			// and then switch to a regular io.Copy.
-			n1, err := io.CopyN(dst, p.w, int64(inpipe))
+			// and then switch to a regular io.Copy. In strict
+			// mode, the data stays in the pipe.
+			if p.strict {
+				return moved, &StagedError{Err: ErrFallbackRequired, Staged: inpipe - n}
+			}
+			n1, err := io.CopyN(dst, p.r, int64(inpipe-n))
 			moved += n1
+			count(&p.stats.bytesOut, n1)
 			if err != nil {
-				return n1, err
+				return moved, stagedError(p, err, inpipe-n-int(n1))
 			}
-			n2, err := io.Copy(dst, src)
-			return n1 + n2, err
+			return fallback()
 		}
 		if err != nil {
-			return moved, err
+			return moved, stagedError(p, err, inpipe-n)
 		}
 	}
 	return moved, nil
 }
 
+// stagedError returns a *StagedError for err, which occurred while staged
+// bytes were left in p, and recovers the bytes.
+func stagedError(p *Pipe, err error, staged int) error {
+	if staged <= 0 {
+		return err
+	}
+	data := make([]byte, staged)
+	n := p.readStaged(data)
+	return &StagedError{Err: err, Staged: staged, Data: data[:n]}
+}
+
+// readStaged reads the data held in p into b, like Salvage, without
+// waiting for more data to arrive, should there be less than len(b)
+// bytes in p. It returns the number of bytes read.
+func (p *Pipe) readStaged(b []byte) int {
+	n := 0
+	p.rrc.Control(func(prfd uintptr) {
+		for n < len(b) {
+			m, err := unix.Read(int(prfd), b[n:])
+			if m <= 0 || err != nil {
+				return
+			}
+			n += m
+		}
+	})
+	return n
+}
+
+// delegateToStdlib reports whether a transfer from src to a *net.TCPConn
+// is delegated to the ReadFrom method of the latter. See
+// SetStdlibDelegation.
+func delegateToStdlib(src io.Reader) bool {
+	return stdlibDelegationEnabled() && stdlibSplicesFrom(src)
+}
+
+// stdlibSplicesFrom reports whether *net.TCPConn.ReadFrom splices from src.
+// It splices from TCP connections since Go 1.11, and from Unix stream
+// sockets since Go 1.15.
+func stdlibSplicesFrom(src io.Reader) bool {
+	switch c := src.(type) {
+	case *net.TCPConn:
+		return true
+	case *net.UnixConn:
+		if !stdlibSplicesFromUnix {
+			return false
+		}
+		addr, ok := c.LocalAddr().(*net.UnixAddr)
+		return ok && addr != nil && addr.Net == "unix"
+	default:
+		return false
+	}
+}
+
+// stdlibSideError wraps err, as returned by *net.TCPConn.ReadFrom, in a
+// *TransferError, if the *net.OpError it wraps tells which side of the
+// transfer failed. Otherwise, it returns err unchanged.
+func stdlibSideError(err error) error {
+	oe, ok := err.(*net.OpError)
+	if !ok {
+		return err
+	}
+	// ReadFrom reports errors as "readfrom", wrapping the error from
+	// the side which failed, if it knows it.
+	if inner, ok := oe.Err.(*net.OpError); ok {
+		oe = inner
+	}
+	switch oe.Op {
+	case "read":
+		return sideError(SideSource, err)
+	case "write":
+		return sideError(SideDestination, err)
+	default:
+		return err
+	}
+}
+
 func spliceDrain(p *Pipe, rrc syscall.RawConn, max int) (int, bool, error) {
 	var (
 		moved  int
@@ -637,7 +1120,11 @@ func spliceDrain(p *Pipe, rrc syscall.RawConn, max int) (int, bool, error) {
 		rrcerr = rrc.Read(func(rfd uintptr) bool {
 			var n int
 			n, serr = splice(rfd, pwfd, max)
-			moved = int(n)
+			p.countSplice(n, serr, false)
+			if n > 0 {
+				moved = n
+				count(&p.stats.bytesIn, int64(n))
+			}
 			if serr == unix.EINVAL {
 				fallback = true
 				return true
@@ -651,12 +1138,12 @@ func spliceDrain(p *Pipe, rrc syscall.RawConn, max int) (int, bool, error) {
 		return true
 	})
 	if err != nil {
-		return 0, false, err
+		return 0, false, sideError(SidePipe, err)
 	}
 	if rrcerr != nil {
-		return 0, false, rrcerr
+		return 0, false, sideError(SideSource, rrcerr)
 	}
-	return moved, fallback, serr
+	return moved, fallback, sideError(SideSource, serr)
 }
 
 func splicePump(wrc syscall.RawConn, p *Pipe, inpipe int) (int, bool, error) {
@@ -671,9 +1158,11 @@ again:
 		wrcerr = wrc.Write(func(wfd uintptr) bool {
 			var n int
 			n, serr = splice(prfd, wfd, inpipe)
+			p.countSplice(n, serr, true)
 			if n > 0 {
 				moved += int(n)
 				inpipe -= int(n)
+				count(&p.stats.bytesOut, int64(n))
 			}
 			if serr == unix.EINVAL {
 				fallback = true
@@ -691,13 +1180,13 @@ again:
 		return moved, true, nil
 	}
 	if err != nil {
-		return moved, false, err
+		return moved, false, sideError(SidePipe, err)
 	}
 	if wrcerr != nil {
-		return moved, false, wrcerr
+		return moved, false, sideError(SideDestination, wrcerr)
 	}
 	if serr != nil {
-		return moved, false, serr
+		return moved, false, sideError(SideDestination, serr)
 	}
 	if inpipe > 0 {
 		goto again
@@ -705,26 +1194,66 @@ again:
 	return moved, false, nil
 }
 
+// tee sets up p to mirror data to w, or not at all if w is nil. It must
+// be called with p.teemu held.
 func (p *Pipe) tee(w io.Writer) {
-	tp, ok := w.(*Pipe)
-	if ok {
-		p.teepipe = tp
-	} else {
-		p.teerd = io.TeeReader(p.r, w)
+	p.teepipes = nil
+	p.teerd = p.r
+	if w == nil {
+		return
+	}
+	if teeAllowed() {
+		if pipes := teeTargetPipes(w); pipes != nil {
+			p.teepipes = pipes
+			return
+		}
 	}
+	p.teerd = teeReader{r: p.r, w: w}
 }
 
-type onlyReader struct {
-	io.Reader
+func (p *Pipe) salvage(w io.Writer) (int64, error) {
+	var salvaged int64
+	for {
+		inpipe, err := p.buffered()
+		if err != nil {
+			return salvaged, p.readErr(err)
+		}
+		if inpipe == 0 {
+			return salvaged, nil
+		}
+		n, err := io.CopyN(w, p.r, int64(inpipe))
+		salvaged += n
+		if err != nil {
+			return salvaged, p.readErr(err)
+		}
+	}
 }
 
 // tee calls tee(2) with SPLICE_F_NONBLOCK.
+//
+// We make the system call ourselves, because on 32-bit platforms,
+// unix.Tee assembles its result from two registers, as if tee(2) returned
+// a 64-bit value. It returns a ssize_t, so the upper half is garbage.
 func tee(rfd, wfd uintptr, max int) (int64, error) {
-	return unix.Tee(int(rfd), int(wfd), max, unix.SPLICE_F_NONBLOCK)
+	n, _, errno := unix.Syscall6(unix.SYS_TEE, rfd, wfd, uintptr(max), unix.SPLICE_F_NONBLOCK, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int64(n), nil
 }
 
-// splice calls splice(2) with SPLICE_F_NONBLOCK.
+// splice calls splice(2) with SPLICE_F_NONBLOCK. If splice(2) is not
+// available to the process, splice reports EINVAL, which makes callers
+// fall back to a generic copy.
 func splice(rfd, wfd uintptr, max int) (int, error) {
-	n, err := unix.Splice(int(rfd), nil, int(wfd), nil, max, unix.SPLICE_F_NONBLOCK)
+	return spliceFlags(rfd, wfd, max, 0)
+}
+
+// spliceFlags is like splice, but also passes flags to splice(2).
+func spliceFlags(rfd, wfd uintptr, max int, flags int) (int, error) {
+	if !spliceAllowed() {
+		return 0, unix.EINVAL
+	}
+	n, err := unix.Splice(int(rfd), nil, int(wfd), nil, max, flags|unix.SPLICE_F_NONBLOCK)
 	return int(n), err
 }
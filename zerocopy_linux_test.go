@@ -7,19 +7,23 @@
 package zerocopy_test
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"acln.ro/zerocopy"
+	"golang.org/x/sys/unix"
 )
 
 func TestTeeRead(t *testing.T) {
@@ -144,6 +148,498 @@ func testTeeChain(t *testing.T, n int) {
 	}
 }
 
+func TestTeeAll(t *testing.T) {
+	t.Run("Pipes", func(t *testing.T) { testTeeAll(t, false) })
+	t.Run("Writers", func(t *testing.T) { testTeeAll(t, true) })
+}
+
+func testTeeAll(t *testing.T, mixed bool) {
+	primary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	const n = 3
+	targets := make([]io.Writer, n)
+	pipes := make([]*zerocopy.Pipe, n)
+	for i := range pipes {
+		pipes[i], err = zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pipes[i].Close()
+		targets[i] = pipes[i]
+	}
+	buf := new(bytes.Buffer)
+	if mixed {
+		targets = append(targets, buf)
+	}
+	primary.SetTeeCloseWrite(true)
+	primary.TeeAll(targets...)
+
+	msg := make([]byte, 1<<18)
+	rand.Read(msg)
+	go func() {
+		primary.Write(msg)
+		primary.CloseWrite()
+	}()
+
+	// Read all the targets to EOF, which they only observe if the
+	// SetTeeCloseWrite setting reached them.
+	errs := make(chan error, n)
+	for i := range pipes {
+		go func(i int) {
+			got, err := ioutil.ReadAll(pipes[i])
+			if err == nil && !bytes.Equal(got, msg) {
+				err = fmt.Errorf("target %d: got %d bytes, want %d bytes, matching", i, len(got), len(msg))
+			}
+			errs <- err
+		}(i)
+	}
+	got, err := ioutil.ReadAll(primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("primary: got %d bytes, want %d bytes, matching", len(got), len(msg))
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if mixed && !bytes.Equal(buf.Bytes(), msg) {
+		t.Errorf("buffer: got %d bytes, want %d bytes, matching", buf.Len(), len(msg))
+	}
+}
+
+func TestTeeAllDirect(t *testing.T) {
+	primary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	targets := make([]io.Writer, 3)
+	for i := range targets {
+		tp, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tp.Close()
+		targets[i] = tp
+	}
+	primary.TeeAll(targets...)
+
+	msg := "hello world"
+	if _, err := io.WriteString(primary, msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(primary, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every target received the data as it was read from primary, so
+	// they can be read in any order, and none waits for another.
+	for i := len(targets) - 1; i >= 0; i-- {
+		tp := targets[i].(*zerocopy.Pipe)
+		tp.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(tp, buf); err != nil {
+			t.Fatalf("target %d: %v", i, err)
+		}
+		if string(buf) != msg {
+			t.Fatalf("target %d: got %q, want %q", i, buf, msg)
+		}
+	}
+}
+
+func TestTeeAllSmallTarget(t *testing.T) {
+	primary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	large, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer large.Close()
+	small, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer small.Close()
+	// The small target has room for fewer of the pipe buffers than the
+	// large one, so some of the data must be written to it from
+	// userspace.
+	if err := small.SetBufferSize(os.Getpagesize()); err != nil {
+		t.Fatal(err)
+	}
+	primary.SetTeeCloseWrite(true)
+	primary.TeeAll(large, small)
+
+	var msg []byte
+	for i := 0; i < 16; i++ {
+		msg = append(msg, bytes.Repeat([]byte{byte('a' + i)}, 1000)...)
+	}
+	for i := 0; i < len(msg); i += 1000 {
+		if _, err := primary.Write(msg[i : i+1000]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	primary.CloseWrite()
+
+	results := make(chan []byte, 2)
+	for _, tp := range []*zerocopy.Pipe{large, small} {
+		go func(tp *zerocopy.Pipe) {
+			got, _ := ioutil.ReadAll(tp)
+			results <- got
+		}(tp)
+	}
+	got, err := ioutil.ReadAll(primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("primary: got %d bytes, want %d bytes, matching", len(got), len(msg))
+	}
+	for i := 0; i < 2; i++ {
+		if got := <-results; !bytes.Equal(got, msg) {
+			t.Errorf("target: got %d bytes, want %d bytes, matching", len(got), len(msg))
+		}
+	}
+}
+
+func TestTeeAllEmpty(t *testing.T) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// Under the default policy, TeeAll with no writers detaches the tee.
+	var target bytes.Buffer
+	p.Tee(&target)
+	p.TeeAll()
+	if _, err := p.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(p, buf); err != nil {
+		t.Fatal(err)
+	}
+	if target.Len() != 0 {
+		t.Fatalf("mirrored %q after TeeAll with no writers", target.Bytes())
+	}
+
+	defer zerocopy.SetMisusePolicy(zerocopy.MisuseIgnore)
+	zerocopy.SetMisusePolicy(zerocopy.MisusePanic)
+	defer func() {
+		if _, ok := recover().(*zerocopy.MisuseError); !ok {
+			t.Fatal("TeeAll with no writers did not panic with a *MisuseError")
+		}
+	}()
+	p.TeeAll()
+}
+
+func TestTeeWriteTo(t *testing.T) {
+	t.Run("Pipe", func(t *testing.T) { testTeeWriteTo(t, true) })
+	t.Run("Writer", func(t *testing.T) { testTeeWriteTo(t, false) })
+}
+
+func testTeeWriteTo(t *testing.T, teePipe bool) {
+	p, client, server, cleanup := newSpliceTest(t)
+	defer cleanup()
+
+	// Send more than fits in the tee target, to exercise the case
+	// where it fills up.
+	msg := make([]byte, 1<<20)
+	rand.Read(msg)
+
+	mirrorc := make(chan []byte, 1)
+	buf := new(bytes.Buffer)
+	if teePipe {
+		secondary, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer secondary.Close()
+		p.Tee(secondary)
+		p.SetTeeCloseWrite(true)
+		go func() {
+			got, _ := ioutil.ReadAll(secondary)
+			mirrorc <- got
+		}()
+	} else {
+		p.Tee(buf)
+	}
+
+	go func() {
+		p.Write(msg)
+		p.CloseWrite()
+	}()
+	gotc := make(chan []byte, 1)
+	go func() {
+		got, _ := ioutil.ReadAll(client)
+		gotc <- got
+	}()
+
+	n, err := p.WriteTo(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(msg)) {
+		t.Errorf("WriteTo moved %d bytes, want %d", n, len(msg))
+	}
+	server.Close()
+	if got := <-gotc; !bytes.Equal(got, msg) {
+		t.Errorf("destination got %d bytes, want %d bytes, matching", len(got), len(msg))
+	}
+	mirror := buf.Bytes()
+	if teePipe {
+		mirror = <-mirrorc
+	}
+	if !bytes.Equal(mirror, msg) {
+		t.Errorf("tee got %d bytes, want %d bytes, matching", len(mirror), len(msg))
+	}
+}
+
+func TestTeeReadEOFTargetFull(t *testing.T) {
+	primary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	secondary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+
+	primary.Tee(secondary)
+	size, err := secondary.BufferSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the secondary exactly, and don't read from it until primary
+	// reaches EOF, which must be reported nevertheless.
+	msg := make([]byte, size)
+	rand.Read(msg)
+	go func() {
+		primary.Write(msg)
+		primary.CloseWrite()
+	}()
+	var got bytes.Buffer
+	if err := readInChunks(&got, primary, size); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), msg) {
+		t.Fatalf("primary: got %d bytes, want %d bytes, matching", got.Len(), len(msg))
+	}
+	mirror := make([]byte, size)
+	if _, err := io.ReadFull(secondary, mirror); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mirror, msg) {
+		t.Fatal("secondary: data mismatch")
+	}
+}
+
+func TestTeeDeadline(t *testing.T) {
+	primary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	secondary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+
+	primary.Tee(secondary)
+	size, err := secondary.BufferSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := make([]byte, 2*size)
+	rand.Read(msg)
+	go func() {
+		primary.Write(msg)
+		primary.CloseWrite()
+	}()
+
+	// Nobody reads from secondary, so once it fills up, Read must
+	// time out, without consuming anything it did not mirror.
+	if err := primary.SetTeeDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	err = readInChunks(&got, primary, size)
+	if err != zerocopy.ErrTeeTimeout {
+		t.Fatalf("got %v, want ErrTeeTimeout", err)
+	}
+	if got.Len() != size {
+		t.Fatalf("read %d bytes before timing out, want %d", got.Len(), size)
+	}
+
+	// Recover: extend the deadline, and drain secondary.
+	if err := primary.SetTeeDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	mirrorc := make(chan []byte, 1)
+	go func() {
+		mirror, _ := ioutil.ReadAll(io.LimitReader(secondary, int64(len(msg))))
+		mirrorc <- mirror
+	}()
+	if err := readInChunks(&got, primary, size); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), msg) {
+		t.Errorf("primary: got %d bytes, want %d bytes, matching", got.Len(), len(msg))
+	}
+	if mirror := <-mirrorc; !bytes.Equal(mirror, msg) {
+		t.Errorf("secondary: got %d bytes, want %d bytes, matching", len(mirror), len(msg))
+	}
+}
+
+// readInChunks reads from r into buf until EOF, using reads of the
+// specified size. Small reads on a pipe which tees data would fill up
+// the tee target long before its buffer size is reached, since each
+// tee(2) call takes up at least one slot in the target.
+func TestTeeReadDeadline(t *testing.T) {
+	primary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	secondary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+
+	// primary is empty, and secondary has space, so the timeout is
+	// attributable to primary, not to the tee target.
+	primary.Tee(secondary)
+	primary.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	for _, fn := range []func() error{
+		func() error {
+			_, err := primary.Read(make([]byte, 1))
+			return err
+		},
+		func() error {
+			_, err := primary.WriteTo(secondary)
+			return err
+		},
+	} {
+		err := fn()
+		if err == zerocopy.ErrTeeTimeout {
+			t.Fatal("got ErrTeeTimeout, want a timeout on the primary pipe")
+		}
+		if te, ok := err.(interface{ Timeout() bool }); !ok || !te.Timeout() {
+			t.Fatalf("got %v, want a timeout", err)
+		}
+	}
+}
+
+func readInChunks(buf *bytes.Buffer, r io.Reader, size int) error {
+	b := make([]byte, size)
+	for {
+		n, err := r.Read(b)
+		buf.Write(b[:n])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestSetTeeDeadlineNotPipe(t *testing.T) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Tee(ioutil.Discard)
+	if err := p.SetTeeDeadline(time.Now()); err == nil {
+		t.Fatal("SetTeeDeadline succeeded for a tee target which is not a *Pipe")
+	}
+}
+
+func TestTeeCloseWrite(t *testing.T) {
+	t.Run("EOF", func(t *testing.T) { testTeeCloseWrite(t, false) })
+	t.Run("Close", func(t *testing.T) { testTeeCloseWrite(t, true) })
+}
+
+func testTeeCloseWrite(t *testing.T, closePrimary bool) {
+	primary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	secondary, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondary.Close()
+
+	primary.SetTrace(64)
+	primary.Tee(secondary)
+	primary.SetTeeCloseWrite(true)
+
+	msg := "hello world"
+	if _, err := io.WriteString(primary, msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(primary, buf); err != nil {
+		t.Fatal(err)
+	}
+	if closePrimary {
+		// Data written after the last read is never mirrored.
+		io.WriteString(primary, "unread")
+		if err := primary.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		primary.CloseWrite()
+		if _, err := io.Copy(ioutil.Discard, primary); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// secondary must observe EOF after the mirrored data.
+	got, err := ioutil.ReadAll(secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != msg {
+		t.Fatalf("got %q, want %q", got, msg)
+	}
+
+	// The tee is detached, not merely closed.
+	detached := false
+	for _, ev := range primary.Trace() {
+		if ev.Op == "detach tee" {
+			detached = true
+		}
+	}
+	if !detached {
+		t.Fatal("tee target still attached after EOF or Close")
+	}
+}
+
 func TestReadFrom(t *testing.T) {
 	t.Run("RacyOrder", testReadFromRacyOrder)
 	t.Run("BlockedInRead", testReadFromBlockedInRead)
@@ -389,6 +885,74 @@ func TestWriteTo(t *testing.T) {
 	}
 }
 
+func TestWriteToMultipleWrites(t *testing.T) {
+	p, client, server, cleanup := newSpliceTest(t)
+	defer cleanup()
+
+	msgs := []string{"hello", " ", "world"}
+	go func() {
+		for _, msg := range msgs {
+			io.WriteString(p, msg)
+			time.Sleep(time.Millisecond)
+		}
+		p.CloseWrite()
+	}()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := p.WriteTo(server)
+		server.Close()
+		errc <- err
+	}()
+	got, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(msgs, ""); string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestSalvage(t *testing.T) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// Salvage does not wait for data if p is empty.
+	var salvaged bytes.Buffer
+	if n, err := p.Salvage(&salvaged); n != 0 || err != nil {
+		t.Fatalf("Salvage on an empty pipe: got (%d, %v), want (0, nil)", n, err)
+	}
+
+	msg := make([]byte, 10000)
+	rand.Read(msg)
+	if _, err := p.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	client, dst, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	client.Close()
+	if _, err := p.WriteTo(dst); err == nil {
+		t.Fatal("WriteTo to a closed peer succeeded")
+	}
+
+	n, err := p.Salvage(&salvaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(msg)) || !bytes.Equal(salvaged.Bytes(), msg) {
+		t.Fatalf("salvaged %d bytes, want %d bytes, matching", n, len(msg))
+	}
+}
+
 func newSpliceTest(t *testing.T) (*zerocopy.Pipe, net.Conn, net.Conn, func()) {
 	t.Helper()
 	p, err := zerocopy.NewPipe()
@@ -587,13 +1151,52 @@ func testTransferIssue25985(t *testing.T, upNet, downNet string) {
 }
 
 func BenchmarkTransfer(b *testing.B) {
-	b.Run("tcp-to-tcp", func(b *testing.B) { benchTransfer(b, "tcp", "tcp") })
-	b.Run("unix-to-tcp", func(b *testing.B) { benchTransfer(b, "unix", "tcp") })
-	b.Run("tcp-to-unix", func(b *testing.B) { benchTransfer(b, "tcp", "unix") })
-	b.Run("unix-to-unix", func(b *testing.B) { benchTransfer(b, "unix", "unix") })
+	benchCopy(b, zerocopy.Transfer)
+}
+
+// BenchmarkStdlibCopy is the baseline for BenchmarkTransfer. The standard
+// library uses splice(2) on its own for some combinations of endpoints,
+// such as *net.TCPConn to *net.TCPConn, so Transfer should never be
+// slower than io.Copy on the same connections.
+func BenchmarkStdlibCopy(b *testing.B) {
+	benchCopy(b, io.Copy)
+}
+
+func BenchmarkPipeRead(b *testing.B) {
+	for _, size := range []int{64, 4096, 65536} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			p, err := zerocopy.NewPipe()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer p.Close()
+			buf := make([]byte, size)
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Write(buf); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(p, buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type copyFunc func(dst io.Writer, src io.Reader) (int64, error)
+
+func benchCopy(b *testing.B, copy copyFunc) {
+	b.Run("tcp-to-tcp", func(b *testing.B) { benchTransfer(b, copy, "tcp", "tcp") })
+	b.Run("unix-to-tcp", func(b *testing.B) { benchTransfer(b, copy, "unix", "tcp") })
+	b.Run("tcp-to-unix", func(b *testing.B) { benchTransfer(b, copy, "tcp", "unix") })
+	b.Run("unix-to-unix", func(b *testing.B) { benchTransfer(b, copy, "unix", "unix") })
 }
 
-func benchTransfer(b *testing.B, upNet, downNet string) {
+func benchTransfer(b *testing.B, copy copyFunc, upNet, downNet string) {
 	for i := 0; i <= 10; i++ {
 		chunkSize := 1 << uint(i+10)
 		tc := transferTestCase{
@@ -602,14 +1205,11 @@ func benchTransfer(b *testing.B, upNet, downNet string) {
 			chunkSize: chunkSize,
 		}
 
-		b.Run(strconv.Itoa(chunkSize), tc.bench)
+		b.Run(strconv.Itoa(chunkSize), func(b *testing.B) { tc.bench(b, copy) })
 	}
 }
 
-func (tc transferTestCase) bench(b *testing.B) {
-	// To benchmark the generic transfer code path, set this to false.
-	useTransfer := true
-
+func (tc transferTestCase) bench(b *testing.B, copy copyFunc) {
 	clientUp, serverUp, err := transferTestSocketPair(tc.upNet)
 	if err != nil {
 		b.Fatal(err)
@@ -637,22 +1237,73 @@ func (tc transferTestCase) bench(b *testing.B) {
 	b.SetBytes(int64(tc.chunkSize))
 	b.ResetTimer()
 
-	if useTransfer {
-		_, err := zerocopy.Transfer(serverDown, serverUp)
-		if err != nil {
-			b.Fatal(err)
-		}
-	} else {
-		type onlyReader struct {
-			io.Reader
-		}
-		_, err := io.Copy(serverDown, onlyReader{serverUp})
-		if err != nil {
-			b.Fatal(err)
+	if _, err := copy(serverDown, serverUp); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestTransferDeadlines(t *testing.T) {
+	t.Run("read", testTransferReadDeadline)
+	t.Run("write", testTransferWriteDeadline)
+}
+
+func testTransferReadDeadline(t *testing.T) {
+	clientUp, serverUp, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientUp.Close()
+	defer serverUp.Close()
+	clientDown, serverDown, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientDown.Close()
+	defer serverDown.Close()
+
+	// Nothing is ever written upstream.
+	serverUp.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = zerocopy.Transfer(serverDown, serverUp)
+	if !isNetTimeout(err) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+}
+
+func testTransferWriteDeadline(t *testing.T) {
+	clientUp, serverUp, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientUp.Close()
+	defer serverUp.Close()
+	clientDown, serverDown, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientDown.Close()
+	defer serverDown.Close()
+
+	// Data flows in upstream, but nothing is ever read downstream.
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := clientUp.Write(buf); err != nil {
+				return
+			}
 		}
+	}()
+	serverDown.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = zerocopy.Transfer(serverDown, serverUp)
+	if !isNetTimeout(err) {
+		t.Fatalf("got %v, want a timeout", err)
 	}
 }
 
+func isNetTimeout(err error) bool {
+	nerr, ok := err.(net.Error)
+	return ok && nerr.Timeout()
+}
+
 func transferTestSocketPair(network string) (client, server net.Conn, err error) {
 	ln, err := newLocalListener(network)
 	if err != nil {
@@ -789,10 +1440,20 @@ func newLocalListener(network string) (net.Listener, error) {
 		return net.Listen("tcp6", "[::1]:0")
 	case "unix", "unixpacket":
 		return net.Listen(network, testUnixAddr())
+	case "unix@", "unixpacket@":
+		// A trailing @ asks for an address in the abstract namespace.
+		return net.Listen(strings.TrimSuffix(network, "@"), testAbstractUnixAddr())
 	}
 	return nil, fmt.Errorf("%s is not supported", network)
 }
 
+// testAbstractUnixAddr returns a unique name in the abstract namespace.
+func testAbstractUnixAddr() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("@zerocopy-nettest-%x", b)
+}
+
 // testUnixAddr uses ioutil.TempFile to get a name that is unique.
 func testUnixAddr() string {
 	f, err := ioutil.TempFile("", "zerocopy-nettest")
@@ -824,3 +1485,230 @@ func TestSetBufferSize(t *testing.T) {
 		t.Fatalf("got %d, want %d", got, n)
 	}
 }
+
+func TestMaxBufferSize(t *testing.T) {
+	b, err := ioutil.ReadFile("/proc/sys/fs/pipe-max-size")
+	if err != nil {
+		t.Skip(err)
+	}
+	want, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	max := zerocopy.MaxBufferSize()
+	if max != want {
+		t.Fatalf("got %d, want %d", max, want)
+	}
+
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.SetBufferSize(max); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.BufferSize(); err != nil || got < max {
+		t.Fatalf("BufferSize: got (%d, %v), want at least %d", got, err, max)
+	}
+}
+
+func TestSyscallConnsExternalPoller(t *testing.T) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(epfd)
+
+	rrc, _ := p.SyscallConns()
+	var ctlerr error
+	err = rrc.Control(func(fd uintptr) {
+		ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(fd)}
+		ctlerr = unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, int(fd), &ev)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctlerr != nil {
+		t.Fatal(ctlerr)
+	}
+
+	events := make([]unix.EpollEvent, 1)
+	if n, err := unix.EpollWait(epfd, events, 0); err != nil || n != 0 {
+		t.Fatalf("empty pipe: got %d events, %v", n, err)
+	}
+	if _, err := io.WriteString(p, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := unix.EpollWait(epfd, events, 1000); err != nil || n != 1 {
+		t.Fatalf("after write: got %d events, %v", n, err)
+	}
+	if events[0].Events&unix.EPOLLIN == 0 {
+		t.Fatalf("got events %#x, want EPOLLIN", events[0].Events)
+	}
+}
+
+func TestSetBufferSizeFunc(t *testing.T) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var calls, requested, effective int
+	p.SetBufferSizeFunc(func(r, e int) {
+		calls++
+		requested, effective = r, e
+	})
+
+	pagesize := os.Getpagesize()
+	if err := p.SetBufferSize(4 * pagesize); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Fatalf("callback called for exact size %d", 4*pagesize)
+	}
+
+	// The kernel rounds up to a power of two multiple of the page size.
+	n := 3*pagesize + 1
+	if err := p.SetBufferSize(n); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("got %d calls, want 1", calls)
+	}
+	if requested != n || effective != 4*pagesize {
+		t.Fatalf("got (%d, %d), want (%d, %d)", requested, effective, n, 4*pagesize)
+	}
+	got, err := p.BufferSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != effective {
+		t.Fatalf("BufferSize returned %d, want %d", got, effective)
+	}
+}
+
+func TestPseudoTerminal(t *testing.T) {
+	t.Run("WriteTo", testPseudoTerminalWriteTo)
+	t.Run("ReadFrom", testPseudoTerminalReadFrom)
+	t.Run("Transfer", testPseudoTerminalTransfer)
+}
+
+func testPseudoTerminalWriteTo(t *testing.T) {
+	master, slave := newPseudoTerminal(t)
+	defer master.Close()
+	defer slave.Close()
+
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	msg := "hello world\n"
+	go func() {
+		io.WriteString(p, msg)
+		p.CloseWrite()
+	}()
+	if _, err := p.WriteTo(master); err != nil {
+		t.Fatal(err)
+	}
+	expectPseudoTerminalInput(t, slave, msg)
+}
+
+func testPseudoTerminalReadFrom(t *testing.T) {
+	master, slave := newPseudoTerminal(t)
+	defer master.Close()
+	defer slave.Close()
+
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	msg := "hello world\n"
+	if _, err := io.WriteString(master, msg); err != nil {
+		t.Fatal(err)
+	}
+	lr := &io.LimitedReader{N: int64(len(msg)), R: slave}
+	n, err := p.ReadFrom(lr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(msg)) {
+		t.Fatalf("read %d bytes, want %d", n, len(msg))
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(p, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != msg {
+		t.Fatalf("got %q, want %q", buf, msg)
+	}
+}
+
+func testPseudoTerminalTransfer(t *testing.T) {
+	master, slave := newPseudoTerminal(t)
+	defer master.Close()
+	defer slave.Close()
+
+	client, server, err := transferTestSocketPair("unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+
+	msg := "hello world\n"
+	go func() {
+		io.WriteString(client, msg)
+		client.Close()
+	}()
+	if _, err := zerocopy.Transfer(master, server); err != nil {
+		t.Fatal(err)
+	}
+	expectPseudoTerminalInput(t, slave, msg)
+}
+
+func expectPseudoTerminalInput(t *testing.T, slave *os.File, msg string) {
+	t.Helper()
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(slave, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != msg {
+		t.Fatalf("got %q, want %q", buf, msg)
+	}
+}
+
+func newPseudoTerminal(t *testing.T) (master, slave *os.File) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo-terminals not available: %v", err)
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	slave, err = os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	return master, slave
+}
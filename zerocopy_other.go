@@ -16,26 +16,59 @@ func (p *Pipe) bufferSize() (int, error) {
 	return 0, errors.New("not supported")
 }
 
-func (p *Pipe) setBufferSize(n int) error {
-	return errors.New("not supported")
+func (p *Pipe) setBufferSize(n int) (int, error) {
+	return 0, errors.New("not supported")
+}
+
+func pipeMaxSize() int {
+	return 0
 }
 
 func (p *Pipe) read(b []byte) (n int, err error) {
-	return p.teerd.Read(b)
+	teerd, _, gen := p.teeState()
+	return p.readTee(teerd, gen, b)
 }
 
 func (p *Pipe) readFrom(src io.Reader) (int64, error) {
-	return io.Copy(p.w, src)
+	return p.copyGeneric(p.w, src)
 }
 
 func (p *Pipe) writeTo(dst io.Writer) (int64, error) {
-	return io.Copy(dst, p.r)
+	return p.copyGeneric(dst, p.fallbackReader())
+}
+
+func (p *Pipe) buffered() (int, error) {
+	return 0, errors.New("not supported")
+}
+
+func (p *Pipe) salvage(w io.Writer) (int64, error) {
+	return 0, errors.New("zerocopy: Salvage not supported on this platform")
 }
 
 func transfer(dst io.Writer, src io.Reader) (int64, error) {
-	return io.Copy(dst, src)
+	return copyAdaptive(dst, src)
+}
+
+func transferPipe(p *Pipe, dst io.Writer, src io.Reader) (int64, error) {
+	if p.isStrict() {
+		return 0, ErrFallbackRequired
+	}
+	return copyAdaptive(dst, src)
+}
+
+func transferStrict(dst io.Writer, src io.Reader) (int64, error) {
+	// There is no way to move data without copying it on this platform.
+	return 0, ErrFallbackRequired
+}
+
+func transferPipeBuffer(p *Pipe, dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	return p.copyGenericBuffer(dst, src, buf)
 }
 
 func (p *Pipe) tee(w io.Writer) {
-	p.teerd = io.TeeReader(p.r, w)
+	if w == nil {
+		p.teerd = p.r
+		return
+	}
+	p.teerd = teeReader{r: p.r, w: w}
 }
@@ -0,0 +1,425 @@
+// Copyright (c) 2019 Andrei Tudor Călin <mail@acln.ro>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zerocopy_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"acln.ro/zerocopy"
+)
+
+func TestFallbackBufferSize(t *testing.T) {
+	const size = 100
+	zerocopy.SetFallbackBufferSize(size)
+	defer zerocopy.SetFallbackBufferSize(32 << 10)
+
+	msg := strings.Repeat("x", 1000)
+	w := &maxWriteRecorder{}
+	n, err := zerocopy.Transfer(w, onlyReader{strings.NewReader(msg)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(msg)) {
+		t.Errorf("transferred %d bytes, want %d", n, len(msg))
+	}
+	if w.buf.String() != msg {
+		t.Errorf("data mismatch")
+	}
+	if w.max != size {
+		t.Errorf("largest write was %d bytes, want %d", w.max, size)
+	}
+}
+
+func TestCopyBuffer(t *testing.T) {
+	msg := strings.Repeat("x", 1000)
+	w := &maxWriteRecorder{}
+	buf := make([]byte, 100)
+	n, err := zerocopy.CopyBuffer(w, onlyReader{strings.NewReader(msg)}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(msg)) || w.buf.String() != msg {
+		t.Errorf("got %d bytes, want %d", n, len(msg))
+	}
+	if w.max != len(buf) {
+		t.Errorf("largest write was %d bytes, want %d", w.max, len(buf))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("CopyBuffer with an empty buffer did not panic")
+		}
+	}()
+	zerocopy.CopyBuffer(w, strings.NewReader(msg), []byte{})
+}
+
+type onlyReader struct {
+	io.Reader
+}
+
+type maxWriteRecorder struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (w *maxWriteRecorder) Write(b []byte) (int, error) {
+	if len(b) > w.max {
+		w.max = len(b)
+	}
+	return w.buf.Write(b)
+}
+
+func TestWriteToFallback(t *testing.T) {
+	t.Run("plain", func(t *testing.T) { testWriteToFallback(t, false) })
+	t.Run("tee", func(t *testing.T) { testWriteToFallback(t, true) })
+}
+
+func testWriteToFallback(t *testing.T, tee bool) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var mirror bytes.Buffer
+	if tee {
+		p.Tee(&mirror)
+	}
+
+	msg := "hello world"
+	go func() {
+		io.WriteString(p, msg)
+		p.CloseWrite()
+	}()
+
+	// *bytes.Buffer implements io.ReaderFrom, but not syscall.Conn.
+	// The fallback must use its ReadFrom method.
+	var dst readFromRecorder
+	n, err := p.WriteTo(&dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(msg)) || dst.String() != msg {
+		t.Errorf("got %d bytes (%q), want %q", n, dst.String(), msg)
+	}
+	if !dst.readFrom {
+		t.Errorf("ReadFrom was not used")
+	}
+	if tee && mirror.String() != msg {
+		t.Errorf("mirror got %q, want %q", mirror.String(), msg)
+	}
+}
+
+type readFromRecorder struct {
+	bytes.Buffer
+	readFrom bool
+}
+
+func (r *readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return r.Buffer.ReadFrom(src)
+}
+
+func TestErrClosedPipe(t *testing.T) {
+	newPipe := func(t *testing.T) *zerocopy.Pipe {
+		t.Helper()
+		p, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	expect := func(t *testing.T, err error) {
+		t.Helper()
+		if err != zerocopy.ErrClosedPipe {
+			t.Fatalf("got %v, want ErrClosedPipe", err)
+		}
+	}
+
+	t.Run("ReadAfterClose", func(t *testing.T) {
+		p := newPipe(t)
+		p.Close()
+		_, err := p.Read(make([]byte, 1))
+		expect(t, err)
+		_, err = p.WriteTo(ioutil.Discard)
+		expect(t, err)
+	})
+	t.Run("WriteAfterCloseWrite", func(t *testing.T) {
+		p := newPipe(t)
+		defer p.Close()
+		p.CloseWrite()
+		_, err := p.Write([]byte("hello"))
+		expect(t, err)
+		_, err = p.ReadFrom(strings.NewReader("hello"))
+		expect(t, err)
+	})
+	t.Run("WriteAfterCloseRead", func(t *testing.T) {
+		p := newPipe(t)
+		defer p.Close()
+		p.CloseRead()
+		_, err := p.Write([]byte("hello"))
+		expect(t, err)
+	})
+	t.Run("CloseUnblocksRead", func(t *testing.T) {
+		p := newPipe(t)
+		errc := make(chan error)
+		go func() {
+			_, err := p.Read(make([]byte, 1))
+			errc <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		p.Close()
+		expect(t, <-errc)
+	})
+	t.Run("DoubleClose", func(t *testing.T) {
+		p := newPipe(t)
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatalf("second Close: %v", err)
+		}
+		if err := p.CloseRead(); err != nil {
+			t.Fatalf("CloseRead after Close: %v", err)
+		}
+	})
+}
+
+func TestPipeDeadlines(t *testing.T) {
+	newPipe := func(t *testing.T) *zerocopy.Pipe {
+		t.Helper()
+		p, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	expectTimeout := func(t *testing.T, err error) {
+		t.Helper()
+		te, ok := err.(interface{ Timeout() bool })
+		if !ok || !te.Timeout() {
+			t.Fatalf("got %v, want a timeout", err)
+		}
+		if err == zerocopy.ErrTeeTimeout {
+			t.Fatal("got ErrTeeTimeout for a deadline on the pipe itself")
+		}
+	}
+	deadline := func() time.Time {
+		return time.Now().Add(20 * time.Millisecond)
+	}
+
+	t.Run("Read", func(t *testing.T) {
+		p := newPipe(t)
+		defer p.Close()
+		p.SetReadDeadline(deadline())
+		_, err := p.Read(make([]byte, 1))
+		expectTimeout(t, err)
+
+		// Clearing the deadline makes the pipe usable again.
+		p.SetReadDeadline(time.Time{})
+		go p.Write([]byte("x"))
+		if _, err := p.Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("WriteTo", func(t *testing.T) {
+		p := newPipe(t)
+		defer p.Close()
+		p.SetReadDeadline(deadline())
+		_, err := p.WriteTo(ioutil.Discard)
+		expectTimeout(t, err)
+	})
+	t.Run("Write", func(t *testing.T) {
+		p := newPipe(t)
+		defer p.Close()
+		p.SetWriteDeadline(deadline())
+		_, err := p.Write(make([]byte, 16<<20))
+		expectTimeout(t, err)
+	})
+	t.Run("ReadFrom", func(t *testing.T) {
+		p := newPipe(t)
+		defer p.Close()
+		p.SetDeadline(deadline())
+		src := io.LimitReader(zeroReader{}, 16<<20)
+		_, err := p.ReadFrom(src)
+		expectTimeout(t, err)
+	})
+}
+
+// zeroReader is an io.Reader which reads zeros forever.
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+func TestMisusePolicy(t *testing.T) {
+	defer zerocopy.SetMisusePolicy(zerocopy.MisuseIgnore)
+
+	// misuse closes both sides of a new pipe, then closes them again.
+	misuse := func(t *testing.T) []error {
+		t.Helper()
+		p, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return []error{p.CloseRead(), p.CloseWrite(), p.Close()}
+	}
+
+	t.Run("Ignore", func(t *testing.T) {
+		zerocopy.SetMisusePolicy(zerocopy.MisuseIgnore)
+		for _, err := range misuse(t) {
+			if err != nil {
+				t.Errorf("got %v, want nil", err)
+			}
+		}
+	})
+	t.Run("Report", func(t *testing.T) {
+		zerocopy.SetMisusePolicy(zerocopy.MisuseReport)
+		for i, err := range misuse(t) {
+			me, ok := err.(*zerocopy.MisuseError)
+			if !ok {
+				t.Errorf("%d: got %v, want a *MisuseError", i, err)
+				continue
+			}
+			if op := []string{"CloseRead", "CloseWrite", "Close"}[i]; me.Op != op {
+				t.Errorf("%d: got Op %q, want %q", i, me.Op, op)
+			}
+		}
+	})
+	t.Run("Panic", func(t *testing.T) {
+		zerocopy.SetMisusePolicy(zerocopy.MisusePanic)
+		defer func() {
+			if _, ok := recover().(*zerocopy.MisuseError); !ok {
+				t.Errorf("did not panic with a *MisuseError")
+			}
+		}()
+		misuse(t)
+	})
+	t.Run("CloseAfterCloseWrite", func(t *testing.T) {
+		zerocopy.SetMisusePolicy(zerocopy.MisusePanic)
+		p, err := zerocopy.NewPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.CloseWrite(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestTrace(t *testing.T) {
+	p, err := zerocopy.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if events := p.Trace(); events != nil {
+		t.Fatalf("got %d events before SetTrace", len(events))
+	}
+	p.SetTrace(3)
+	p.Write([]byte("hello"))
+	p.Write([]byte("world"))
+	p.CloseWrite()
+	b, _ := ioutil.ReadAll(p)
+
+	// The first write is gone from the ring. ReadAll reads the data,
+	// then EOF.
+	events := p.Trace()
+	var ops []string
+	for _, e := range events {
+		ops = append(ops, e.Op)
+	}
+	want := []string{"close write", "read", "read"}
+	if strings.Join(ops, ",") != strings.Join(want, ",") {
+		t.Fatalf("got events %q, want %q", ops, want)
+	}
+	if events[1].N != int64(len(b)) {
+		t.Errorf("read event: got n = %d, want %d", events[1].N, len(b))
+	}
+	if events[2].Err != io.EOF {
+		t.Errorf("last read event: got error %v, want EOF", events[2].Err)
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Time.Before(events[i-1].Time) {
+			t.Errorf("events out of order: %v", events)
+		}
+	}
+
+	p.SetTrace(0)
+	if events := p.Trace(); events != nil {
+		t.Fatalf("got %d events after disabling the trace", len(events))
+	}
+}
+
+func TestGroup(t *testing.T) {
+	zerocopy.SetMisusePolicy(zerocopy.MisusePanic)
+	defer zerocopy.SetMisusePolicy(zerocopy.MisuseIgnore)
+
+	var g zerocopy.Group
+	p, err := g.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, err := g.NewPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pipes closed by their users must not trip the misuse policy.
+	closed.Close()
+	var c closeRecorder
+	g.Add(&c)
+	g.Go(func() error {
+		_, err := ioutil.ReadAll(p)
+		return err
+	})
+	g.Go(func() error { return nil })
+
+	time.Sleep(10 * time.Millisecond)
+	if err := g.CloseAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Wait(); err != zerocopy.ErrClosedPipe {
+		t.Fatalf("Wait: got %v, want %v", err, zerocopy.ErrClosedPipe)
+	}
+	if c != 1 {
+		t.Fatalf("closer closed %d times, want 1", c)
+	}
+
+	if _, err := g.NewPipe(); err == nil {
+		t.Fatal("NewPipe succeeded after CloseAll")
+	}
+	var late closeRecorder
+	g.Add(&late)
+	if late != 1 {
+		t.Fatalf("closer added after CloseAll closed %d times, want 1", late)
+	}
+	if err := g.CloseAll(); err != nil {
+		t.Fatalf("second CloseAll: %v", err)
+	}
+}
+
+type closeRecorder int
+
+func (c *closeRecorder) Close() error {
+	*c++
+	return nil
+}